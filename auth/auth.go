@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"g_chat/config"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Identity is what a verified token says about the caller
+type Identity struct {
+	UserId    string
+	Email     string
+	Claims    map[string]any
+	AuthTime  time.Time
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Authenticator verifies bearer tokens, the implementation is selected by the auth provider setting
+type Authenticator interface {
+	Verify(ctx context.Context, token string) (Identity, error)
+	// Revalidate returns false if the user was disabled or tokens issued at issuedAt were revoked
+	Revalidate(ctx context.Context, userId string, issuedAt time.Time) (bool, error)
+}
+
+var authenticator Authenticator
+
+func GetAuthenticator() Authenticator {
+	return authenticator
+}
+
+func SetAuthenticator(a Authenticator) {
+	authenticator = a
+}
+
+// CreateAuthenticator builds the authenticator of the configured provider (firebase, jwt or fake)
+func CreateAuthenticator(authConfig config.AuthConfig) error {
+	var err error
+
+	switch authConfig.Provider {
+	case "firebase":
+		authenticator, err = NewFirebaseAuthenticator(authConfig.FirebaseCredentials)
+	case "jwt":
+		authenticator, err = NewLocalJWTAuthenticator(LocalJWTConfig{
+			HMACSecret: authConfig.JWT.HS256Secret,
+			JWKSFile:   authConfig.JWT.JWKSFile,
+			Issuer:     authConfig.JWT.Issuer,
+			Audience:   authConfig.JWT.Audience,
+		})
+	case "fake":
+		if !authConfig.AllowFake {
+			return errors.New("fake auth provider needs allow_fake (AUTH_ALLOW_FAKE) to be set")
+		}
+		authenticator = NewFakeAuthenticator()
+	default:
+		err = fmt.Errorf("unknown auth provider %v", authConfig.Provider)
+	}
+
+	return err
+}
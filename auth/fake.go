@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+const FAKE_TOKEN_TTL = time.Hour
+
+// FakeAuthenticator is for local development and tests, a token is "<userId>" or "<userId>:<email>".
+// Users can be disabled to exercise revocation.
+type FakeAuthenticator struct {
+	disabled map[string]bool
+	sync.RWMutex
+}
+
+func NewFakeAuthenticator() *FakeAuthenticator {
+	return &FakeAuthenticator{
+		disabled: make(map[string]bool),
+	}
+}
+
+func (authenticator *FakeAuthenticator) Verify(ctx context.Context, token string) (Identity, error) {
+	userId, email, _ := strings.Cut(token, ":")
+	if userId == "" {
+		return Identity{}, ErrInvalidToken
+	}
+
+	authenticator.RLock()
+	disabled := authenticator.disabled[userId]
+	authenticator.RUnlock()
+
+	if disabled {
+		return Identity{}, ErrInvalidToken
+	}
+
+	now := time.Now()
+	return Identity{
+		UserId: userId,
+		Email:  email,
+		Claims: map[string]any{
+			"email": email,
+		},
+		AuthTime:  now,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(FAKE_TOKEN_TTL),
+	}, nil
+}
+
+func (authenticator *FakeAuthenticator) Revalidate(ctx context.Context, userId string, issuedAt time.Time) (bool, error) {
+	authenticator.RLock()
+	defer authenticator.RUnlock()
+
+	return !authenticator.disabled[userId], nil
+}
+
+// Disable makes every token of the user invalid
+func (authenticator *FakeAuthenticator) Disable(userId string) {
+	authenticator.Lock()
+	defer authenticator.Unlock()
+
+	authenticator.disabled[userId] = true
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"g_chat/firebase"
+	"time"
+
+	firebaseAuth "firebase.google.com/go/auth"
+)
+
+// FirebaseAuthenticator verifies firebase id tokens, it needs the service account credentials file
+type FirebaseAuthenticator struct {
+	client *firebaseAuth.Client
+}
+
+func NewFirebaseAuthenticator(credentialsFile string) (*FirebaseAuthenticator, error) {
+	if err := firebase.CreateFirebaseApp(credentialsFile); err != nil {
+		return nil, err
+	}
+
+	return &FirebaseAuthenticator{
+		client: firebase.GetFirebaseAuthClient(),
+	}, nil
+}
+
+// Verify only checks the token signature and claims locally, revoked tokens and disabled users are caught by
+// Revalidate which runs periodically instead of a firebase round trip on every request
+func (authenticator *FirebaseAuthenticator) Verify(ctx context.Context, token string) (Identity, error) {
+	verified, err := authenticator.client.VerifyIDToken(ctx, token)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{
+		UserId:    verified.UID,
+		Claims:    verified.Claims,
+		AuthTime:  time.Unix(verified.AuthTime, 0),
+		IssuedAt:  time.Unix(verified.IssuedAt, 0),
+		ExpiresAt: time.Unix(verified.Expires, 0),
+	}
+	if email, ok := verified.Claims["email"].(string); ok {
+		identity.Email = email
+	}
+
+	return identity, nil
+}
+
+func (authenticator *FirebaseAuthenticator) Revalidate(ctx context.Context, userId string, issuedAt time.Time) (bool, error) {
+	user, err := authenticator.client.GetUser(ctx, userId)
+	if firebaseAuth.IsUserNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if user.Disabled {
+		return false, nil
+	}
+
+	// tokens issued before the revocation time are no longer valid
+	if !issuedAt.IsZero() && user.TokensValidAfterMillis > issuedAt.UnixMilli() {
+		return false, nil
+	}
+
+	return true, nil
+}
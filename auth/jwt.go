@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// allowed clock difference between the token issuer and this server
+const JWT_LEEWAY = 30 * time.Second
+
+type LocalJWTConfig struct {
+	// HS256 shared secret, leave empty to only accept RS256
+	HMACSecret string
+	// JWKS file with the RS256 public keys, leave empty to only accept HS256
+	JWKSFile string
+	// checked when set
+	Issuer   string
+	Audience string
+}
+
+// LocalJWTAuthenticator verifies self issued HS256/RS256 tokens without any external service.
+// The user id is the sub claim. There is no revocation, tokens are valid until they expire.
+type LocalJWTAuthenticator struct {
+	hmacSecret []byte
+	rsaKeys    map[string]*rsa.PublicKey
+	issuer     string
+	audience   string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func NewLocalJWTAuthenticator(config LocalJWTConfig) (*LocalJWTAuthenticator, error) {
+	if config.HMACSecret == "" && config.JWKSFile == "" {
+		return nil, errors.New("jwt auth needs an HS256 secret or a JWKS file")
+	}
+
+	authenticator := &LocalJWTAuthenticator{
+		hmacSecret: []byte(config.HMACSecret),
+		rsaKeys:    make(map[string]*rsa.PublicKey),
+		issuer:     config.Issuer,
+		audience:   config.Audience,
+	}
+
+	if config.JWKSFile != "" {
+		if err := authenticator.loadJWKS(config.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return authenticator, nil
+}
+
+func (authenticator *LocalJWTAuthenticator) loadJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return fmt.Errorf("invalid JWKS file %v : %w", path, err)
+	}
+
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return fmt.Errorf("invalid modulus of key %v : %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return fmt.Errorf("invalid exponent of key %v : %w", key.Kid, err)
+		}
+
+		authenticator.rsaKeys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(authenticator.rsaKeys) == 0 {
+		return fmt.Errorf("no RSA keys in JWKS file %v", path)
+	}
+	return nil
+}
+
+func (authenticator *LocalJWTAuthenticator) Verify(ctx context.Context, token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Identity{}, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, ErrInvalidToken
+	}
+
+	if err := authenticator.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return Identity{}, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, ErrInvalidToken
+	}
+
+	return authenticator.identityFromClaims(claims)
+}
+
+func (authenticator *LocalJWTAuthenticator) verifySignature(header jwtHeader, signed string, signature []byte) error {
+	switch header.Alg {
+	case "HS256":
+		if len(authenticator.hmacSecret) == 0 {
+			return ErrInvalidToken
+		}
+		mac := hmac.New(sha256.New, authenticator.hmacSecret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidToken
+		}
+		return nil
+
+	case "RS256":
+		key, ok := authenticator.rsaKeys[header.Kid]
+		if !ok && header.Kid == "" && len(authenticator.rsaKeys) == 1 {
+			for _, onlyKey := range authenticator.rsaKeys {
+				key, ok = onlyKey, true
+			}
+		}
+		if !ok {
+			return ErrInvalidToken
+		}
+		digest := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidToken
+		}
+		return nil
+	}
+
+	return ErrInvalidToken
+}
+
+func (authenticator *LocalJWTAuthenticator) identityFromClaims(claims map[string]any) (Identity, error) {
+	now := time.Now()
+
+	expiresAt, ok := timeClaim(claims, "exp")
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	if now.After(expiresAt.Add(JWT_LEEWAY)) {
+		return Identity{}, ErrTokenExpired
+	}
+
+	if notBefore, ok := timeClaim(claims, "nbf"); ok && now.Add(JWT_LEEWAY).Before(notBefore) {
+		return Identity{}, ErrInvalidToken
+	}
+
+	if authenticator.issuer != "" && claims["iss"] != authenticator.issuer {
+		return Identity{}, ErrInvalidToken
+	}
+
+	if authenticator.audience != "" && !hasAudience(claims["aud"], authenticator.audience) {
+		return Identity{}, ErrInvalidToken
+	}
+
+	userId, _ := claims["sub"].(string)
+	if userId == "" {
+		return Identity{}, ErrInvalidToken
+	}
+
+	identity := Identity{
+		UserId:    userId,
+		Claims:    claims,
+		ExpiresAt: expiresAt,
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.IssuedAt, _ = timeClaim(claims, "iat")
+	identity.AuthTime, _ = timeClaim(claims, "auth_time")
+
+	return identity, nil
+}
+
+func (authenticator *LocalJWTAuthenticator) Revalidate(ctx context.Context, userId string, issuedAt time.Time) (bool, error) {
+	return true, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func timeClaim(claims map[string]any, name string) (time.Time, bool) {
+	seconds, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// aud can be a single string or a list of strings
+func hasAudience(aud any, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []any:
+		for _, item := range value {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
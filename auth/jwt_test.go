@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testSecret   = "test-secret"
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "g_chat"
+)
+
+// testKeys are the RS256 keys in the JWKS file, one per kid
+type testKeys map[string]*rsa.PrivateKey
+
+func newTestKeys(t *testing.T, kids ...string) testKeys {
+	t.Helper()
+	keys := make(testKeys)
+	for _, kid := range kids {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("generating rsa key: %v", err)
+		}
+		keys[kid] = key
+	}
+	return keys
+}
+
+// writeJWKS writes the public keys to a JWKS file and returns its path
+func (keys testKeys) writeJWKS(t *testing.T) string {
+	t.Helper()
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range keys {
+		jwks.Keys = append(jwks.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("marshalling jwks: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing jwks: %v", err)
+	}
+	return path
+}
+
+func encodeSegment(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshalling segment: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// hs256Token signs the claims with the secret
+func hs256Token(t *testing.T, secret string, claims map[string]any) string {
+	t.Helper()
+	signed := encodeSegment(t, map[string]any{"alg": "HS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// rs256Token signs the claims with the key and puts kid in the header when set
+func rs256Token(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	header := map[string]any{"alg": "RS256", "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// unsignedToken builds a token with the alg and an empty signature
+func unsignedToken(t *testing.T, alg string, claims map[string]any) string {
+	t.Helper()
+	return encodeSegment(t, map[string]any{"alg": alg, "typ": "JWT"}) + "." + encodeSegment(t, claims) + "."
+}
+
+// testClaims returns valid claims for user with changes applied, a nil value removes the claim
+func testClaims(changes map[string]any) map[string]any {
+	claims := map[string]any{
+		"sub": "user",
+		"iss": testIssuer,
+		"aud": testAudience,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+	return claims
+}
+
+func TestLocalJWTAuthenticatorVerify(t *testing.T) {
+	keys := newTestKeys(t, "key-1", "key-2")
+	jwksFile := keys.writeJWKS(t)
+
+	// accepts HS256 and RS256 and checks issuer and audience
+	both, err := NewLocalJWTAuthenticator(LocalJWTConfig{HMACSecret: testSecret, JWKSFile: jwksFile, Issuer: testIssuer, Audience: testAudience})
+	if err != nil {
+		t.Fatalf("creating authenticator: %v", err)
+	}
+	jwksOnly, err := NewLocalJWTAuthenticator(LocalJWTConfig{JWKSFile: jwksFile})
+	if err != nil {
+		t.Fatalf("creating jwks only authenticator: %v", err)
+	}
+
+	now := time.Now()
+	margin := 5 * time.Second
+	otherKey := newTestKeys(t, "other")["other"]
+
+	tests := []struct {
+		name          string
+		authenticator *LocalJWTAuthenticator
+		token         string
+		wantErr       error
+	}{
+		{name: "valid HS256", authenticator: both, token: hs256Token(t, testSecret, testClaims(nil))},
+		{name: "valid RS256", authenticator: both, token: rs256Token(t, keys["key-1"], "key-1", testClaims(nil))},
+		{name: "valid RS256 second key", authenticator: both, token: rs256Token(t, keys["key-2"], "key-2", testClaims(nil))},
+		{name: "alg none", authenticator: both, token: unsignedToken(t, "none", testClaims(nil)), wantErr: ErrInvalidToken},
+		{name: "unknown alg", authenticator: both, token: unsignedToken(t, "HS512", testClaims(nil)), wantErr: ErrInvalidToken},
+		{name: "HS256 with only JWKS configured", authenticator: jwksOnly, token: hs256Token(t, "", testClaims(nil)), wantErr: ErrInvalidToken},
+		{name: "HS256 bad signature", authenticator: both, token: hs256Token(t, "other-secret", testClaims(nil)), wantErr: ErrInvalidToken},
+		{name: "RS256 bad signature", authenticator: both, token: rs256Token(t, otherKey, "key-1", testClaims(nil)), wantErr: ErrInvalidToken},
+		{name: "unknown kid", authenticator: both, token: rs256Token(t, keys["key-1"], "key-9", testClaims(nil)), wantErr: ErrInvalidToken},
+		{name: "no kid with several keys", authenticator: both, token: rs256Token(t, keys["key-1"], "", testClaims(nil)), wantErr: ErrInvalidToken},
+		{name: "malformed token", authenticator: both, token: "not.a-token", wantErr: ErrInvalidToken},
+		{name: "exp inside leeway", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"exp": now.Add(-JWT_LEEWAY + margin).Unix()}))},
+		{name: "exp outside leeway", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"exp": now.Add(-JWT_LEEWAY - margin).Unix()})), wantErr: ErrTokenExpired},
+		{name: "missing exp", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"exp": nil})), wantErr: ErrInvalidToken},
+		{name: "nbf inside leeway", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"nbf": now.Add(JWT_LEEWAY - margin).Unix()}))},
+		{name: "nbf outside leeway", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"nbf": now.Add(JWT_LEEWAY + margin).Unix()})), wantErr: ErrInvalidToken},
+		{name: "wrong iss", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"iss": "https://other.example.com"})), wantErr: ErrInvalidToken},
+		{name: "missing iss", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"iss": nil})), wantErr: ErrInvalidToken},
+		{name: "aud as string", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"aud": testAudience}))},
+		{name: "aud as list", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"aud": []string{"other", testAudience}}))},
+		{name: "wrong aud string", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"aud": "other"})), wantErr: ErrInvalidToken},
+		{name: "wrong aud list", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"aud": []string{"other"}})), wantErr: ErrInvalidToken},
+		{name: "missing sub", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"sub": nil})), wantErr: ErrInvalidToken},
+		{name: "empty sub", authenticator: both, token: hs256Token(t, testSecret, testClaims(map[string]any{"sub": ""})), wantErr: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := tt.authenticator.Verify(context.Background(), tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if identity.UserId != "user" {
+				t.Fatalf("user id = %q, want user", identity.UserId)
+			}
+		})
+	}
+}
+
+func TestLocalJWTAuthenticatorSingleKeyWithoutKid(t *testing.T) {
+	keys := newTestKeys(t, "key-1")
+	authenticator, err := NewLocalJWTAuthenticator(LocalJWTConfig{JWKSFile: keys.writeJWKS(t)})
+	if err != nil {
+		t.Fatalf("creating authenticator: %v", err)
+	}
+
+	identity, err := authenticator.Verify(context.Background(), rs256Token(t, keys["key-1"], "", testClaims(nil)))
+	if err != nil || identity.UserId != "user" {
+		t.Fatalf("verify = (%+v, %v), want user", identity, err)
+	}
+}
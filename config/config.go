@@ -0,0 +1,285 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const DEFAULT_CONFIG_FILE = "config.yaml"
+
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	CORS      CORSConfig      `yaml:"cors"`
+	WebSocket WebSocketConfig `yaml:"websocket"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Features  FeatureConfig   `yaml:"features"`
+}
+
+type ServerConfig struct {
+	// address passed to the http server, ":8080" by default
+	Address string `yaml:"address"`
+	// how long sockets, requests and the database get to finish on SIGINT/SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// clients are told to wait this long (plus jitter) before reconnecting after a restart
+	RestartReconnectAfter time.Duration `yaml:"restart_reconnect_after"`
+}
+
+type DatabaseConfig struct {
+	DSN string `yaml:"dsn"`
+}
+
+type CORSConfig struct {
+	// origins allowed to call the API and open websockets : exact origins, wildcard subdomains
+	// (https://*.example.com) or "*" for every origin
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	// how long browsers may cache a preflight response
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+type WebSocketConfig struct {
+	// a socket is closed when no pong arrives within PongWait, pings are sent every PingInterval
+	PongWait     time.Duration `yaml:"pong_wait"`
+	PingInterval time.Duration `yaml:"ping_interval"`
+	// largest frame read from a client in bytes
+	ReadLimit int64 `yaml:"read_limit"`
+	// per event limits keyed by the event's string code (e.g. chat.message.incoming), merged over the defaults
+	EventSizeLimits map[string]int64  `yaml:"event_size_limits"`
+	ReadBufferSize  int               `yaml:"read_buffer_size"`
+	WriteBufferSize int               `yaml:"write_buffer_size"`
+	TicketTTL       time.Duration     `yaml:"ticket_ttl"`
+	Compression     CompressionConfig `yaml:"compression"`
+	// memory or postgres, postgres by default in cluster mode
+	TicketStore string `yaml:"ticket_store"`
+	// unique per instance, setting it enables cluster mode
+	ClusterNodeId string `yaml:"cluster_node_id"`
+	// events waiting to be written per socket
+	SendQueueSize int `yaml:"send_queue_size"`
+	// drop_oldest or disconnect, see SlowConsumerPolicy in the websocket package
+	SlowConsumerPolicy   string `yaml:"slow_consumer_policy"`
+	SlowConsumerMaxDrops int    `yaml:"slow_consumer_max_drops"`
+	// events of a socket are handled in order, reading pauses once this many wait
+	IncomingQueueSize int `yaml:"incoming_queue_size"`
+	// handlers running at once across every socket
+	HandlerWorkers int `yaml:"handler_workers"`
+	// sockets a user can hold per instance, zero is unlimited. not shared in cluster mode, a user can hold
+	// this many on every instance
+	MaxConnectionsPerUser int `yaml:"max_connections_per_user"`
+	// reject_new or evict_oldest
+	ConnectionLimitStrategy string `yaml:"connection_limit_strategy"`
+}
+
+// permessage-deflate, frames below threshold bytes are sent uncompressed
+type CompressionConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	Level     int  `yaml:"level"`
+	Threshold int  `yaml:"threshold"`
+}
+
+type AuthConfig struct {
+	// firebase, jwt or fake
+	Provider            string    `yaml:"provider"`
+	FirebaseCredentials string    `yaml:"firebase_credentials"`
+	JWT                 JWTConfig `yaml:"jwt"`
+	// the fake provider accepts any user id as token, it is only allowed for development and tests
+	AllowFake bool `yaml:"allow_fake"`
+}
+
+type JWTConfig struct {
+	HS256Secret string `yaml:"hs256_secret"`
+	JWKSFile    string `yaml:"jwks_file"`
+	Issuer      string `yaml:"issuer"`
+	Audience    string `yaml:"audience"`
+}
+
+type FeatureConfig struct {
+	// coalesce delivered/read receipts per conversation instead of one event per message
+	ReceiptBatching bool `yaml:"receipt_batching"`
+	// periodically check open sockets still belong to an enabled account
+	TokenRevalidation bool `yaml:"token_revalidation"`
+	AdminRoutes       bool `yaml:"admin_routes"`
+}
+
+var config *Config
+
+// Get returns the loaded configuration, Load must be called first
+func Get() *Config {
+	return config
+}
+
+func Defaults() Config {
+	return Config{
+		Server: ServerConfig{
+			Address:               ":8080",
+			ShutdownTimeout:       15 * time.Second,
+			RestartReconnectAfter: 2 * time.Second,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   []string{"http://localhost:3000"},
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		},
+		WebSocket: WebSocketConfig{
+			PongWait:        60 * time.Second,
+			PingInterval:    54 * time.Second,
+			ReadLimit:       64 * 1024,
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			TicketTTL:       30 * time.Second,
+			Compression: CompressionConfig{
+				Enabled:   true,
+				Level:     1,
+				Threshold: 512,
+			},
+			SendQueueSize:           256,
+			SlowConsumerPolicy:      "drop_oldest",
+			SlowConsumerMaxDrops:    64,
+			IncomingQueueSize:       64,
+			HandlerWorkers:          256,
+			MaxConnectionsPerUser:   5,
+			ConnectionLimitStrategy: "evict_oldest",
+		},
+		Auth: AuthConfig{
+			Provider:            "firebase",
+			FirebaseCredentials: "./serviceAccount.json",
+		},
+		Features: FeatureConfig{
+			ReceiptBatching:   true,
+			TokenRevalidation: true,
+			AdminRoutes:       true,
+		},
+	}
+}
+
+// Load reads the configuration, later sources win : defaults, YAML file (CONFIG_FILE or config.yaml),
+// .env and finally the environment
+func Load() error {
+	loaded := Defaults()
+
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error loading .env file : %w", err)
+	}
+
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		path = DEFAULT_CONFIG_FILE
+	}
+	if err := loadYAML(path, &loaded, os.Getenv("CONFIG_FILE") != ""); err != nil {
+		return err
+	}
+
+	if err := loadEnv(&loaded); err != nil {
+		return err
+	}
+
+	if err := loaded.Validate(); err != nil {
+		return err
+	}
+
+	config = &loaded
+	return nil
+}
+
+// loadYAML only fails on a missing file when the file was asked for explicitly
+func loadYAML(path string, into *Config, required bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading config file %v : %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, into); err != nil {
+		return fmt.Errorf("error parsing config file %v : %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server shutdown timeout must be positive"))
+	}
+	if c.Server.RestartReconnectAfter < 0 {
+		errs = append(errs, errors.New("server restart reconnect delay can not be negative"))
+	}
+
+	if c.Database.DSN == "" {
+		errs = append(errs, errors.New("database dsn is required (DATABASE_DSN)"))
+	}
+
+	if len(c.CORS.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("at least one allowed origin is required (CORS_ALLOWED_ORIGINS)"))
+	}
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		errs = append(errs, errors.New("allow credentials can not be used with the * origin"))
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, errors.New("cors max age can not be negative"))
+	}
+
+	ws := c.WebSocket
+	if ws.PongWait <= 0 || ws.PingInterval <= 0 || ws.PingInterval >= ws.PongWait {
+		errs = append(errs, errors.New("websocket ping interval must be positive and shorter than pong wait"))
+	}
+	if ws.ReadLimit <= 0 || ws.ReadBufferSize <= 0 || ws.WriteBufferSize <= 0 {
+		errs = append(errs, errors.New("websocket read limit and buffer sizes must be positive"))
+	}
+	for name, limit := range ws.EventSizeLimits {
+		if limit <= 0 || limit > ws.ReadLimit {
+			errs = append(errs, fmt.Errorf("websocket size limit of %v must be positive and at most the read limit", name))
+		}
+	}
+	if ws.Compression.Level < -2 || ws.Compression.Level > 9 || ws.Compression.Threshold < 0 {
+		errs = append(errs, errors.New("websocket compression level must be between -2 and 9 and threshold not negative"))
+	}
+	if ws.TicketTTL <= 0 {
+		errs = append(errs, errors.New("websocket ticket ttl must be positive"))
+	}
+	if !slices.Contains([]string{"", "memory", "postgres"}, ws.TicketStore) {
+		errs = append(errs, fmt.Errorf("unknown websocket ticket store %v", ws.TicketStore))
+	}
+	if ws.SendQueueSize <= 0 || ws.SlowConsumerMaxDrops < 0 {
+		errs = append(errs, errors.New("websocket send queue size must be positive and max drops not negative"))
+	}
+	if ws.IncomingQueueSize <= 0 || ws.HandlerWorkers <= 0 {
+		errs = append(errs, errors.New("websocket incoming queue size and handler workers must be positive"))
+	}
+	if ws.MaxConnectionsPerUser < 0 {
+		errs = append(errs, errors.New("websocket max connections per user can not be negative"))
+	}
+	if !slices.Contains([]string{"reject_new", "evict_oldest"}, ws.ConnectionLimitStrategy) {
+		errs = append(errs, fmt.Errorf("unknown websocket connection limit strategy %v", ws.ConnectionLimitStrategy))
+	}
+	if !slices.Contains([]string{"drop_oldest", "disconnect"}, ws.SlowConsumerPolicy) {
+		errs = append(errs, fmt.Errorf("unknown websocket slow consumer policy %v", ws.SlowConsumerPolicy))
+	}
+
+	switch c.Auth.Provider {
+	case "firebase":
+		if c.Auth.FirebaseCredentials == "" {
+			errs = append(errs, errors.New("firebase credentials file is required (AUTH_FIREBASE_CREDENTIALS)"))
+		}
+	case "jwt":
+		if c.Auth.JWT.HS256Secret == "" && c.Auth.JWT.JWKSFile == "" {
+			errs = append(errs, errors.New("jwt auth needs AUTH_JWT_HS256_SECRET or AUTH_JWT_JWKS_FILE"))
+		}
+	case "fake":
+		if !c.Auth.AllowFake {
+			errs = append(errs, errors.New("fake auth provider is only for development and tests, set AUTH_ALLOW_FAKE to use it"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown auth provider %v", c.Auth.Provider))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadEnv overrides values for every variable which is set
+func loadEnv(c *Config) error {
+	var errs []string
+	fail := func(name string, err error) {
+		errs = append(errs, fmt.Sprintf("%v : %v", name, err))
+	}
+
+	envString("SERVER_ADDRESS", &c.Server.Address)
+	envString("DATABASE_DSN", &c.Database.DSN)
+
+	envList("CORS_ALLOWED_ORIGINS", &c.CORS.AllowedOrigins)
+	if err := envBool("CORS_ALLOW_CREDENTIALS", &c.CORS.AllowCredentials); err != nil {
+		fail("CORS_ALLOW_CREDENTIALS", err)
+	}
+
+	for name, target := range map[string]*time.Duration{
+		"WS_PONG_WAIT":     &c.WebSocket.PongWait,
+		"WS_PING_INTERVAL": &c.WebSocket.PingInterval,
+		"WS_TICKET_TTL":    &c.WebSocket.TicketTTL,
+		"CORS_MAX_AGE":     &c.CORS.MaxAge,
+
+		"SERVER_SHUTDOWN_TIMEOUT":        &c.Server.ShutdownTimeout,
+		"SERVER_RESTART_RECONNECT_AFTER": &c.Server.RestartReconnectAfter,
+	} {
+		if err := envDuration(name, target); err != nil {
+			fail(name, err)
+		}
+	}
+	if err := envInt64("WS_READ_LIMIT", &c.WebSocket.ReadLimit); err != nil {
+		fail("WS_READ_LIMIT", err)
+	}
+	for name, target := range map[string]*int{
+		"WS_READ_BUFFER_SIZE":         &c.WebSocket.ReadBufferSize,
+		"WS_WRITE_BUFFER_SIZE":        &c.WebSocket.WriteBufferSize,
+		"WS_SEND_QUEUE_SIZE":          &c.WebSocket.SendQueueSize,
+		"WS_SLOW_CONSUMER_MAX_DROPS":  &c.WebSocket.SlowConsumerMaxDrops,
+		"WS_INCOMING_QUEUE_SIZE":      &c.WebSocket.IncomingQueueSize,
+		"WS_HANDLER_WORKERS":          &c.WebSocket.HandlerWorkers,
+		"WS_COMPRESSION_LEVEL":        &c.WebSocket.Compression.Level,
+		"WS_COMPRESSION_THRESHOLD":    &c.WebSocket.Compression.Threshold,
+		"WS_MAX_CONNECTIONS_PER_USER": &c.WebSocket.MaxConnectionsPerUser,
+	} {
+		if err := envInt(name, target); err != nil {
+			fail(name, err)
+		}
+	}
+	if err := envBool("WS_COMPRESSION_ENABLED", &c.WebSocket.Compression.Enabled); err != nil {
+		fail("WS_COMPRESSION_ENABLED", err)
+	}
+	if err := envLimits("WS_EVENT_SIZE_LIMITS", &c.WebSocket.EventSizeLimits); err != nil {
+		fail("WS_EVENT_SIZE_LIMITS", err)
+	}
+	envString("WS_TICKET_STORE", &c.WebSocket.TicketStore)
+	envString("WS_CLUSTER_NODE_ID", &c.WebSocket.ClusterNodeId)
+	envString("WS_SLOW_CONSUMER_POLICY", &c.WebSocket.SlowConsumerPolicy)
+	envString("WS_CONNECTION_LIMIT_STRATEGY", &c.WebSocket.ConnectionLimitStrategy)
+
+	envString("AUTH_PROVIDER", &c.Auth.Provider)
+	envString("AUTH_FIREBASE_CREDENTIALS", &c.Auth.FirebaseCredentials)
+	envString("AUTH_JWT_HS256_SECRET", &c.Auth.JWT.HS256Secret)
+	envString("AUTH_JWT_JWKS_FILE", &c.Auth.JWT.JWKSFile)
+	envString("AUTH_JWT_ISSUER", &c.Auth.JWT.Issuer)
+	envString("AUTH_JWT_AUDIENCE", &c.Auth.JWT.Audience)
+	if err := envBool("AUTH_ALLOW_FAKE", &c.Auth.AllowFake); err != nil {
+		fail("AUTH_ALLOW_FAKE", err)
+	}
+
+	for name, target := range map[string]*bool{
+		"FEATURE_RECEIPT_BATCHING":   &c.Features.ReceiptBatching,
+		"FEATURE_TOKEN_REVALIDATION": &c.Features.TokenRevalidation,
+		"FEATURE_ADMIN_ROUTES":       &c.Features.AdminRoutes,
+	} {
+		if err := envBool(name, target); err != nil {
+			fail(name, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment : %v", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func envString(name string, target *string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = value
+	}
+}
+
+// comma separated, empty items are dropped
+func envList(name string, target *[]string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*target = items
+}
+
+// comma separated name=value pairs, e.g. chat.message.incoming=16384,hello=2048
+func envLimits(name string, target *map[string]int64) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	limits := make(map[string]int64)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		key, rawLimit, found := strings.Cut(item, "=")
+		if !found {
+			return fmt.Errorf("expected name=value, got %v", item)
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(rawLimit), 10, 64)
+		if err != nil {
+			return err
+		}
+		limits[strings.TrimSpace(key)] = limit
+	}
+	*target = limits
+	return nil
+}
+
+func envBool(name string, target *bool) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
+
+func envInt(name string, target *int) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
+
+func envInt64(name string, target *int64) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
+
+func envDuration(name string, target *time.Duration) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
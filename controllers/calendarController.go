@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"g_chat/database"
+	"g_chat/middleware"
 	"g_chat/models"
+	ws "g_chat/wsConnections"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -44,7 +46,7 @@ func CreateNewCalendarEvent(ctx *gin.Context) {
 	}
 
 	// authorized
-	if calendarEvent.UserID != ctx.Keys["userId"] {
+	if calendarEvent.UserID != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -62,6 +64,8 @@ func CreateNewCalendarEvent(ctx *gin.Context) {
 		return
 	}
 
+	publishToLFG(createdCalendarEvent, "created")
+
 	ctx.JSON(http.StatusOK, createdCalendarEvent)
 }
 
@@ -74,7 +78,7 @@ func UpdateCalendarRequestStatus(ctx *gin.Context) {
 		return
 	}
 
-	if updateCalendarRequest.UpdaterId != ctx.Keys["userId"] {
+	if updateCalendarRequest.UpdaterId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -139,7 +143,7 @@ func DeleteExisitngCalendarEvent(ctx *gin.Context) {
 		return
 	}
 
-	if deleteCalendarEvent.UserId != ctx.Keys["userId"] {
+	if deleteCalendarEvent.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -162,7 +166,7 @@ func DeleteExisitngCalendarEvent(ctx *gin.Context) {
 		return
 	}
 
-	err = database.GetCalendarQueries().DeleteCalendarEvent(ctx, deleteCalendarEvent.EventId)
+	deletedEvent, err := database.GetCalendarQueries().DeleteCalendarEvent(ctx, deleteCalendarEvent.EventId)
 
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
@@ -171,6 +175,11 @@ func DeleteExisitngCalendarEvent(ctx *gin.Context) {
 		return
 	}
 
+	ws.GetConnectionManager().Publish(ws.Topic(ws.TopicEvent, deleteCalendarEvent.EventId), "deleted", gin.H{
+		"event_id": deleteCalendarEvent.EventId,
+	})
+	publishToLFG(deletedEvent, "deleted")
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "success",
 	})
@@ -187,7 +196,7 @@ func RequestJoinForCalendarEvent(ctx *gin.Context) {
 	}
 
 	// check if userId same as requestingUserId
-	if ctx.Keys["userId"] != calendarRequest.UserId {
+	if middleware.UserId(ctx) != calendarRequest.UserId {
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 			"message": "wrong time params",
 		})
@@ -267,7 +276,7 @@ func GetRecentEventsJoinedOrCreatedForUser(ctx *gin.Context) {
 		return
 	}
 
-	if ctx.Keys["userId"] != recentEventsRequestBody.UserId {
+	if middleware.UserId(ctx) != recentEventsRequestBody.UserId {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -303,7 +312,7 @@ func GetRecentEventsCreatedByUser(ctx *gin.Context) {
 		return
 	}
 
-	if ctx.Keys["userId"] != recentEventsRequestBody.UserId {
+	if middleware.UserId(ctx) != recentEventsRequestBody.UserId {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -342,7 +351,7 @@ func IsEventCoincidesWithAnotherJoinedEvent(ctx *gin.Context) {
 		return
 	}
 
-	if ctx.Keys["userId"] != eventCoincideRequest.UserId {
+	if middleware.UserId(ctx) != eventCoincideRequest.UserId {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -371,7 +380,7 @@ func GetParticipantsOfAnEvent(ctx *gin.Context) {
 		return
 	}
 
-	if getEventParticipants.UserId != ctx.Keys["userId"] {
+	if getEventParticipants.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -428,7 +437,7 @@ func GetAllActiveRequestsForEvent(ctx *gin.Context) {
 		return
 	}
 
-	if getActiveRequests.UserId != ctx.Keys["userId"] {
+	if getActiveRequests.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -479,7 +488,7 @@ func LeaveEventForAParticipant(ctx *gin.Context) {
 		return
 	}
 
-	if eventLeave.UserId != ctx.Keys["userId"] {
+	if eventLeave.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -525,7 +534,7 @@ func UpdateEventDetails(ctx *gin.Context) {
 		return
 	}
 
-	if updateCalendarEventDetails.UserId != ctx.Keys["userId"] {
+	if updateCalendarEventDetails.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -557,6 +566,9 @@ func UpdateEventDetails(ctx *gin.Context) {
 		return
 	}
 
+	ws.GetConnectionManager().Publish(ws.Topic(ws.TopicEvent, updateCalendarEventDetails.EventId), "updated", updatedEvent)
+	publishToLFG(updatedEvent, "updated")
+
 	ctx.JSON(http.StatusOK, updatedEvent)
 }
 
@@ -569,7 +581,7 @@ func RemoveCalendarParticipantByOrganizer(ctx *gin.Context) {
 		return
 	}
 
-	if removeCalendarParticipant.UserId != ctx.Keys["userId"] {
+	if removeCalendarParticipant.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
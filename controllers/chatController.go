@@ -2,8 +2,10 @@ package controllers
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"g_chat/database"
+	"g_chat/middleware"
 	"g_chat/models"
 	"log"
 	"net/http"
@@ -29,7 +31,7 @@ func GetMostRecentConversationsForUser(ctx *gin.Context) {
 		queryCount = 20
 	}
 
-	conversations, err := database.GetChatQueries().GetMostRecentConversationsForUser(ctx, ctx.Keys["userId"].(string), time, uint(queryCount))
+	conversations, err := database.GetChatQueries().GetMostRecentConversationsForUser(ctx, middleware.UserId(ctx), time, uint(queryCount))
 
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -60,7 +62,7 @@ func GetAllMessagesForConversation(ctx *gin.Context) {
 
 	convId := ctx.Query("conversationId")
 
-	val, err := IsUserPartOfConversation(ctx.Keys["userId"].(string), convId)
+	val, err := IsUserPartOfConversation(middleware.UserId(ctx), convId)
 
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -91,7 +93,7 @@ func GetAllMessagesForConversation(ctx *gin.Context) {
 func GetAllUsersInConversation(ctx *gin.Context) {
 	convId := ctx.Query("conversationId")
 
-	val, err := IsUserPartOfConversation(ctx.Keys["userId"].(string), convId)
+	val, err := IsUserPartOfConversation(middleware.UserId(ctx), convId)
 
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -205,7 +207,7 @@ func GetAllMessages(ctx *gin.Context) {
 		})
 	}
 
-	messages, err := database.GetChatQueries().GetAllMessagesAfterGivenTime(ctx, ctx.Keys["userId"].(string), lastTimeStamp, queryCount)
+	messages, err := database.GetChatQueries().GetAllMessagesAfterGivenTime(ctx, middleware.UserId(ctx), lastTimeStamp, queryCount)
 
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -235,7 +237,7 @@ func MarkMessagesAsRecievedByUser(ctx *gin.Context) {
 
 	// TODO : confirm user is the recipient of all these messages
 
-	if _, err := database.GetChatQueries().MarkMessagesAsRecievedByUser(ctx, messageIds, ctx.Keys["userId"].(string)); err != nil {
+	if _, err := database.GetChatQueries().MarkMessagesAsRecievedByUser(ctx, messageIds, middleware.UserId(ctx)); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error writing to DB",
 		})
@@ -258,7 +260,7 @@ func MarkMessageAsSeenByUser(ctx *gin.Context) {
 
 	// TODO : confirm user is the recipient of all these messages
 
-	if err := database.GetChatQueries().UpdateLastMessageSeenInConversationForUser(ctx, message.ConversationId, ctx.Keys["userId"].(string), message.SentAt); err != nil {
+	if err := database.GetChatQueries().UpdateLastMessageSeenInConversationForUser(ctx, message.ConversationId, middleware.UserId(ctx), message.SentAt); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error writing to DB",
 		})
@@ -270,6 +272,43 @@ func MarkMessageAsSeenByUser(ctx *gin.Context) {
 	})
 }
 
+// GetReceiptsForMessage returns who received and read a message, only the sender can see them
+func GetReceiptsForMessage(ctx *gin.Context) {
+	messageId := ctx.Param("id")
+
+	message, err := database.GetChatQueries().GetMessageByID(ctx.Request.Context(), messageId)
+	if errors.Is(err, sql.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "message not found",
+		})
+		return
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed getting message",
+		})
+		return
+	}
+
+	if message.SenderID != middleware.UserId(ctx) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "only the sender can see receipts",
+		})
+		return
+	}
+
+	receipts, err := database.GetChatQueries().GetReceiptsForMessage(ctx.Request.Context(), messageId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed getting receipts",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, receipts)
+}
+
 // func GetUnsentCalendarRequests(ctx *gin.Context) {
 // 	lastTimeStamp, queryCount, err := GetUnsentRequestsQueryParam(ctx)
 
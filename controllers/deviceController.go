@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"g_chat/database"
+	"g_chat/middleware"
+	"g_chat/models"
+	ws "g_chat/wsConnections"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterDevice(ctx *gin.Context) {
+	var newDevice models.NewDevice
+	if err := ctx.BindJSON(&newDevice); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "incorrect body params",
+		})
+		return
+	}
+
+	if newDevice.Name == "" || newDevice.Platform == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "name and platform are required",
+		})
+		return
+	}
+
+	device, err := database.GetDeviceQueries().RegisterDevice(ctx.Request.Context(), middleware.UserId(ctx), newDevice)
+	if err != nil {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "failed registering device",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, models.DeviceDetails{
+		ID:         device.ID,
+		Name:       device.Name,
+		Platform:   device.Platform,
+		CreatedAt:  device.CreatedAt,
+		LastSeenAt: device.LastSeenAt,
+	})
+}
+
+func GetDevicesOfUser(ctx *gin.Context) {
+	userId := middleware.UserId(ctx)
+
+	devices, err := database.GetDeviceQueries().GetDevicesOfUser(ctx.Request.Context(), userId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed getting devices",
+		})
+		return
+	}
+
+	connected := ws.GetConnectionManager().ConnectedDevices(userId)
+
+	response := make([]models.DeviceDetails, len(devices))
+	for i, device := range devices {
+		response[i] = models.DeviceDetails{
+			ID:         device.ID,
+			Name:       device.Name,
+			Platform:   device.Platform,
+			CreatedAt:  device.CreatedAt,
+			LastSeenAt: device.LastSeenAt,
+			Connected:  connected[device.ID],
+		}
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// RevokeDevice revokes the device and closes every socket it holds
+func RevokeDevice(ctx *gin.Context) {
+	userId := middleware.UserId(ctx)
+	deviceId := ctx.Param("id")
+
+	revoked, err := database.GetDeviceQueries().RevokeDevice(ctx.Request.Context(), userId, deviceId)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error revoking device",
+		})
+		return
+	}
+
+	if !revoked {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "device not found",
+		})
+		return
+	}
+
+	ws.GetConnectionManager().DisconnectDevice(userId, deviceId, "device revoked")
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "device revoked",
+	})
+}
+
+// validateDeviceForTicket is used by the connection manager before issuing a websocket ticket
+func validateDeviceForTicket(userId string, deviceId string) (bool, error) {
+	return database.GetDeviceQueries().TouchDevice(context.Background(), userId, deviceId)
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"g_chat/database"
+	ws "g_chat/wsConnections"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLiveness is the public liveness check, it only says the server is up and never exposes any details
+func GetLiveness(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// GetServerHealth is admin only, it reports the state of the DB notification listener, 503 while it is reconnecting
+func GetServerHealth(ctx *gin.Context) {
+	listenerHealth := database.GetListenerHealth()
+
+	status := http.StatusOK
+	if listenerHealth.Status != database.ListenerHealthy && listenerHealth.Status != database.ListenerStandby {
+		status = http.StatusServiceUnavailable
+	}
+
+	ctx.JSON(status, gin.H{
+		"listener": listenerHealth,
+	})
+}
+
+// GetSendQueueStats reports how full the websocket send queues are and how many events were dropped
+func GetSendQueueStats(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"send_queues": ws.GetConnectionManager().SendQueueStats(),
+	})
+}
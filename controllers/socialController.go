@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"g_chat/database"
+	"g_chat/middleware"
 	"g_chat/models"
 	"net/http"
 
@@ -26,7 +27,7 @@ func CreateNewSocialRequest(ctx *gin.Context) {
 	}
 
 	// userId check
-	if newSocialRequest.RequestorId != ctx.Keys["userId"] {
+	if newSocialRequest.RequestorId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -105,7 +106,7 @@ func UpdateSocialRequest(ctx *gin.Context) {
 	}
 
 	// userId check
-	if socialRequestUpdateEvent.UpdaterId != ctx.Keys["userId"] {
+	if socialRequestUpdateEvent.UpdaterId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -171,7 +172,7 @@ func UnfriendOrUnfollowUsers(ctx *gin.Context) {
 	}
 
 	// userId check
-	if disconnectSocialUser.User1Id != ctx.Keys["userId"] && disconnectSocialUser.User2Id != ctx.Keys["userId"] {
+	if disconnectSocialUser.User1Id != middleware.UserId(ctx) && disconnectSocialUser.User2Id != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -234,7 +235,7 @@ func GetAllFriends(ctx *gin.Context) {
 		return
 	}
 
-	if getAllSocialConnections.UserId != ctx.Keys["userId"] {
+	if getAllSocialConnections.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -267,7 +268,7 @@ func GetAllFollowers(ctx *gin.Context) {
 		return
 	}
 
-	if getAllSocialConnections.UserId != ctx.Keys["userId"] {
+	if getAllSocialConnections.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -300,7 +301,7 @@ func GetAllFollows(ctx *gin.Context) {
 		return
 	}
 
-	if getAllSocialConnections.UserId != ctx.Keys["userId"] {
+	if getAllSocialConnections.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -394,7 +395,7 @@ func CheckMutualFriend(ctx *gin.Context) {
 	var pairOfUsers models.PairUsers
 	ctx.Bind(&pairOfUsers)
 
-	if pairOfUsers.User1Id != ctx.Keys["userId"] {
+	if pairOfUsers.User1Id != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -420,7 +421,7 @@ func FUserAreFriends(ctx *gin.Context) {
 	var pairOfUsers models.PairUsers
 	ctx.Bind(&pairOfUsers)
 
-	if pairOfUsers.User1Id != ctx.Keys["userId"] {
+	if pairOfUsers.User1Id != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -446,7 +447,7 @@ func FUserFollowsOtherUser(ctx *gin.Context) {
 	var pairOfUsers models.PairUsers
 	ctx.Bind(&pairOfUsers)
 
-	if pairOfUsers.User1Id != ctx.Keys["userId"] {
+	if pairOfUsers.User1Id != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
@@ -474,7 +475,7 @@ func GetAllActiveSocialRequests(ctx *gin.Context) {
 		return
 	}
 
-	if activeSocialRequests.UserId != ctx.Keys["userId"] {
+	if activeSocialRequests.UserId != middleware.UserId(ctx) {
 		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized",
 		})
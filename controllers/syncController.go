@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"g_chat/database"
+	"g_chat/middleware"
+	"g_chat/models"
+	ws "g_chat/wsConnections"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SYNC_DEFAULT_QUERY_COUNT = 20
+	SYNC_MAX_QUERY_COUNT     = 50
+)
+
+func encodeSyncCursor(cursor models.SyncCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// an empty cursor means the device has never synced
+func decodeSyncCursor(cursor string) (models.SyncCursor, error) {
+	var syncCursor models.SyncCursor
+	if cursor == "" {
+		return syncCursor, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return syncCursor, errors.New("invalid cursor")
+	}
+
+	if err := json.Unmarshal(data, &syncCursor); err != nil {
+		return syncCursor, errors.New("invalid cursor")
+	}
+
+	return syncCursor, nil
+}
+
+// GetSyncChangesForUser collects everything the user missed after the cursor, every stream is returned in server order.
+// deviceId may be empty for clients which did not register a device
+func GetSyncChangesForUser(ctx context.Context, userId string, deviceId string, syncRequest models.SyncRequest) (models.SyncResponse, error) {
+	cursor, err := decodeSyncCursor(syncRequest.Cursor)
+	if err != nil {
+		return models.SyncResponse{}, err
+	}
+
+	queryCount := syncRequest.QueryCount
+	if queryCount == 0 {
+		queryCount = SYNC_DEFAULT_QUERY_COUNT
+	}
+	if queryCount > SYNC_MAX_QUERY_COUNT {
+		queryCount = SYNC_MAX_QUERY_COUNT
+	}
+
+	syncQueries := database.GetSyncQueries()
+	response := models.SyncResponse{}
+
+	// messages the outbox gave up on come first, then everything new
+	pendingMessages, err := syncQueries.GetPendingMessagesForUser(ctx, userId, deviceId, cursor.Pending, queryCount)
+	if err != nil {
+		return models.SyncResponse{}, err
+	}
+
+	newMessages, err := syncQueries.GetNewMessagesForUser(ctx, userId, cursor.Messages, queryCount)
+	if err != nil {
+		return models.SyncResponse{}, err
+	}
+
+	// every stream is ordered by time then id, the cursor moves to the last row returned
+	messages := make([]database.Message, 0, len(pendingMessages)+len(newMessages))
+	for _, pending := range pendingMessages {
+		messages = append(messages, pending.Message)
+		cursor.Pending = models.SyncPosition{Time: pending.UndeliveredAt, Id: pending.ID}
+	}
+
+	for _, message := range append(messages, newMessages...) {
+		response.Messages = append(response.Messages, models.OutgoingChatPayload{
+			ID:                message.ID,
+			MessageBody:       message.Body,
+			Sender:            message.SenderID,
+			SenderId:          message.SenderID,
+			ConversationId:    message.ConversationID,
+			SentAt:            message.SentAt,
+			ServerRecieveTime: message.CreatedAt,
+			ReceiverId:        userId,
+		})
+	}
+	for _, message := range newMessages {
+		cursor.Messages = models.SyncPosition{Time: message.CreatedAt, Id: message.ID}
+	}
+
+	receipts, err := syncQueries.GetReceiptUpdatesForSender(ctx, userId, cursor.Receipts, queryCount)
+	if err != nil {
+		return models.SyncResponse{}, err
+	}
+
+	for _, message := range receipts {
+		response.Receipts = append(response.Receipts, models.SyncReceipt{
+			MessageId:      message.ID,
+			ConversationId: message.ConversationID,
+			DeliveredCount: message.DeliveredCount,
+			SeenCount:      message.SeenCount,
+			SentToCount:    message.SentToCount,
+			Time:           message.ReceiptUpdatedAt,
+		})
+		cursor.Receipts = models.SyncPosition{Time: message.ReceiptUpdatedAt, Id: message.ID}
+	}
+
+	socialRequests, err := syncQueries.GetSocialRequestChangesForUser(ctx, userId, cursor.SocialRequests, queryCount)
+	if err != nil {
+		return models.SyncResponse{}, err
+	}
+
+	for _, request := range socialRequests {
+		changedAt := request.CreatedAt
+		if request.UpdatedAt.Valid {
+			changedAt = request.UpdatedAt.Int64
+		}
+		response.SocialRequests = append(response.SocialRequests, models.SyncSocialRequest{
+			ID:            request.ID,
+			RequestorId:   request.UserID,
+			TargetUserId:  request.TargetUserID,
+			RequestType:   request.RequestType,
+			Message:       request.RequestMessage,
+			RequestStatus: request.RequestStatus,
+			Time:          changedAt,
+		})
+		cursor.SocialRequests = models.SyncPosition{Time: changedAt, Id: request.ID}
+	}
+
+	calendarInvites, err := syncQueries.GetCalendarInviteChangesForUser(ctx, userId, cursor.CalendarInvites, queryCount)
+	if err != nil {
+		return models.SyncResponse{}, err
+	}
+
+	for _, invite := range calendarInvites {
+		changedAt := invite.CreatedAt
+		if invite.UpdatedAt.Valid {
+			changedAt = invite.UpdatedAt.Int64
+		}
+		response.CalendarInvites = append(response.CalendarInvites, models.SyncCalendarInvite{
+			ID:            invite.ID,
+			EventId:       invite.EventID,
+			InvitedUserId: invite.InvitedUserID,
+			Message:       invite.InviteMessage,
+			InviteStatus:  invite.InviteStatus,
+			Time:          changedAt,
+		})
+		cursor.CalendarInvites = models.SyncPosition{Time: changedAt, Id: invite.ID}
+	}
+
+	calendarRequests, err := syncQueries.GetCalendarRequestChangesForUser(ctx, userId, cursor.CalendarRequests, queryCount)
+	if err != nil {
+		return models.SyncResponse{}, err
+	}
+
+	for _, request := range calendarRequests {
+		changedAt := request.CreatedAt
+		if request.UpdatedAt.Valid {
+			changedAt = request.UpdatedAt.Int64
+		}
+		response.CalendarRequests = append(response.CalendarRequests, models.SyncCalendarRequest{
+			ID:            request.ID,
+			EventId:       request.EventID,
+			RequestorId:   request.RequestingUserID,
+			Message:       request.RequestMessage,
+			RequestStatus: request.RequestStatus,
+			Time:          changedAt,
+		})
+		cursor.CalendarRequests = models.SyncPosition{Time: changedAt, Id: request.ID}
+	}
+
+	response.HasMore = len(pendingMessages) == int(queryCount) ||
+		len(newMessages) == int(queryCount) ||
+		len(receipts) == int(queryCount) ||
+		len(socialRequests) == int(queryCount) ||
+		len(calendarInvites) == int(queryCount) ||
+		len(calendarRequests) == int(queryCount)
+	response.Cursor = encodeSyncCursor(cursor)
+
+	return response, nil
+}
+
+func SyncForUser(ctx *gin.Context) {
+	var queryCount uint64
+	if ctx.Query("queryCount") != "" {
+		var err error
+		queryCount, err = strconv.ParseUint(ctx.Query("queryCount"), 10, 32)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "insufficient or wrong params",
+			})
+			return
+		}
+	}
+
+	response, err := GetSyncChangesForUser(ctx.Request.Context(), middleware.UserId(ctx), ctx.Query("deviceId"), models.SyncRequest{
+		Cursor:     ctx.Query("cursor"),
+		QueryCount: uint(queryCount),
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Unknown error while retreiving data",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func handleIncomingSyncRequest(syncRequest models.SyncRequest, event ws.Event, client *ws.Client) error {
+	response, err := GetSyncChangesForUser(context.Background(), client.UserId, client.DeviceId, syncRequest)
+	if err != nil {
+		client.SendAckToClient(ws.Acknowledge{
+			ReceiverID: client.UserId,
+			EventType:  event.Type,
+			Status:     false,
+			Message:    "sync failed",
+			AckTime:    time.Now().UnixNano(),
+		}, event.Id)
+		return err
+	}
+
+	client.SendSyncResponseToClient(response, event.Id)
+
+	return nil
+}
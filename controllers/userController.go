@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"g_chat/database"
+	"g_chat/middleware"
 	"g_chat/models"
 	"net/http"
 
@@ -9,7 +10,7 @@ import (
 )
 
 func GetCurrentUserDetails(ctx *gin.Context) {
-	user, err := database.GetUserQueries().GetUserFromId(ctx.Request.Context(), ctx.Keys["userId"].(string))
+	user, err := database.GetUserQueries().GetUserFromId(ctx.Request.Context(), middleware.UserId(ctx))
 
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -42,7 +43,7 @@ func UpdateUser(ctx *gin.Context) {
 		return
 	}
 
-	if err := database.GetUserQueries().UpdateUser(ctx.Request.Context(), userContent, ctx.Keys["userId"].(string)); err != nil {
+	if err := database.GetUserQueries().UpdateUser(ctx.Request.Context(), userContent, middleware.UserId(ctx)); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "failed creating user",
 		})
@@ -55,7 +56,7 @@ func UpdateUser(ctx *gin.Context) {
 }
 
 func DeleteUser(ctx *gin.Context) {
-	if err := database.GetUserQueries().DeleteUser(ctx.Request.Context(), ctx.Keys["userId"].(string)); err != nil {
+	if err := database.GetUserQueries().DeleteUser(ctx.Request.Context(), middleware.UserId(ctx)); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": "error deleting user",
 		})
@@ -93,3 +94,33 @@ func CreateUser(ctx *gin.Context) {
 		"message": "created user",
 	})
 }
+
+func GetUserSettings(ctx *gin.Context) {
+	settings, err := database.GetUserQueries().GetUserSettings(ctx.Request.Context(), middleware.UserId(ctx))
+
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed getting settings",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, settings)
+}
+
+func UpdateUserSettings(ctx *gin.Context) {
+	var settings models.UserSettings
+	if err := ctx.BindJSON(&settings); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "incorrect body params",
+		})
+		return
+	}
+
+	if err := database.GetUserQueries().UpdateUserSettings(ctx.Request.Context(), middleware.UserId(ctx), settings); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "failed updating settings",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, settings)
+}
@@ -4,216 +4,76 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"g_chat/auth"
 	"g_chat/database"
 	"g_chat/models"
 	ws "g_chat/wsConnections"
 	"log"
-	"time"
 )
 
-// TODO validate all input data and auth check for evry handler by using sender_id == client.userId
-
-func handleIncomingChatMessage(event ws.Event, client *ws.Client) error {
-	var incomingChatPayload models.IncomingChatPayload
-	var ackData ws.Acknowledge
-
-	if err := json.Unmarshal(event.Payload, &incomingChatPayload); err != nil {
-		log.Printf("incorrect payload of incoming chat message %v", err)
-		ackData = ws.Acknowledge{
-			EventType: event.Type,
-			Status:    false,
-			Message:   "Failed parsing the chat message",
-			AckTime:   time.Now().UnixNano(),
-		}
-		client.SendAckToClient(ackData, event.Id)
-
-		return err
-	}
-
-	if client.UserId != incomingChatPayload.SenderId {
-		log.Print("WTF !!!")
-		client.ConnectionManager.RemoveClient(client)
-		return errors.New("unauthorized, disconnecting client")
-	}
+// payloads are decoded, validated and checked against the socket's user by ws.Decode, handlers only
+// return errors and ws.Acknowledged answers the client
 
+func handleIncomingChatMessage(incomingChatPayload models.IncomingChatPayload, event ws.Event, client *ws.Client) error {
 	if incomingChatPayload.IsGroup {
-		isUserPartOfConv, err := IsUserPartOfConversation(incomingChatPayload.ConversationId, client.UserId)
-
+		isUserPartOfConv, err := IsUserPartOfConversation(client.UserId, incomingChatPayload.ConversationId)
 		if err != nil {
-			ackData = ws.Acknowledge{
-				EventType: event.Type,
-				Status:    false,
-				Message:   "cannot confirm authorization",
-				AckTime:   time.Now().UnixNano(),
-			}
-			client.SendAckToClient(ackData, event.Id)
-
-			return err
+			return ws.Reject("cannot confirm authorization", err)
 		}
 
 		if !isUserPartOfConv {
-			ackData = ws.Acknowledge{
-				EventType: event.Type,
-				Status:    false,
-				Message:   "user not part of conversation",
-				AckTime:   time.Now().UnixNano(),
-			}
-			client.SendAckToClient(ackData, event.Id)
-
-			return errors.New("user not part of conversation")
+			return ws.Reject("user not part of conversation")
 		}
 	}
 
 	// Write to DB
-	_, err := database.GetChatQueries().WriteIncomingMessageWS(context.Background(), incomingChatPayload) // TODO : this function should be moved to chat controller
-	if err != nil {
-		ackData = ws.Acknowledge{
-			EventType: event.Type,
-			Status:    false,
-			Message:   "Failed inserting in DB",
-			AckTime:   time.Now().UnixNano(),
-		}
-		client.SendAckToClient(ackData, event.Id)
-		return err
-	}
-
-	ackData = ws.Acknowledge{
-		EventType: event.Type,
-		Status:    true,
-		Message:   "Success",
-		AckTime:   time.Now().UnixNano(),
+	if _, err := database.GetChatQueries().WriteIncomingMessageWS(context.Background(), incomingChatPayload); err != nil { // TODO : this function should be moved to chat controller
+		return ws.Reject("Failed inserting in DB", err)
 	}
-	client.SendAckToClient(ackData, event.Id)
 
 	return nil
 }
 
-func handleDeliveredUpdateForMessage(event ws.Event, client *ws.Client) error {
-	var incomingDeliveredUpdate ws.IncomingDeliveredUpdate
-	if err := json.Unmarshal(event.Payload, &incomingDeliveredUpdate); err != nil {
-		log.Printf("Error Unmarshalling acknowledge data %v", err)
-		client.SendAckToClient(ws.Acknowledge{
-			ReceiverID: client.UserId,
-			EventType:  event.Type,
-			Status:     false,
-			Message:    "error unmarshalling data",
-			AckTime:    time.Now().UnixNano(),
-		}, event.Id)
-		return err
-	}
-
-	if incomingDeliveredUpdate.SenderId != client.UserId {
-		log.Printf("WTF !!")
-		client.SendAckToClient(ws.Acknowledge{
-			ReceiverID: client.UserId,
-			EventType:  event.Type,
-			Status:     false,
-			Message:    "unauthorized, disconnecting",
-			AckTime:    time.Now().UnixNano(),
-		}, event.Id)
-		client.ConnectionManager.RemoveClient(client)
-		return errors.New("unauthorized disconnecting client")
-	}
-
-	deletedRows, err := markMessageAsRecievedByUserWS(incomingDeliveredUpdate)
+func handleDeliveredUpdateForMessage(incomingDeliveredUpdate ws.IncomingDeliveredUpdate, event ws.Event, client *ws.Client) error {
+	// the message counts as delivered to the user on the first device
+	deliveredRows, err := markMessageAsRecievedByUserWS(incomingDeliveredUpdate)
 	if err != nil {
-		client.SendAckToClient(ws.Acknowledge{
-			ReceiverID: client.UserId,
-			EventType:  event.Type,
-			Status:     false,
-			Message:    "DB error",
-			AckTime:    time.Now().UnixNano(),
-		}, event.Id)
-		return err
+		return ws.Reject("DB error", err)
 	}
 
-	if len(deletedRows) == 0 {
-		client.SendAckToClient(ws.Acknowledge{
-			ReceiverID: client.UserId,
-			EventType:  event.Type,
-			Status:     false,
-			Message:    "message already marked as delivered",
-			AckTime:    time.Now().UnixNano(),
-		}, event.Id)
-		return nil
+	// delivery is tracked for every device, only recorded when the user is a receiver of the message
+	var deviceRows int64
+	if client.DeviceId != "" {
+		deviceRows, err = database.GetDeviceQueries().MarkMessagesAsDeliveredToDevice(context.Background(), []string{incomingDeliveredUpdate.MessageId}, client.UserId, client.DeviceId)
+		if err != nil {
+			return ws.Reject("DB error", err)
+		}
 	}
 
-	client.SendAckToClient(ws.Acknowledge{
-		EventType: event.Type,
-		Status:    true,
-		Message:   "success",
-		AckTime:   time.Now().UnixNano(),
-	}, event.Id)
+	if len(deliveredRows) == 0 && deviceRows == 0 {
+		return ws.Reject("message already marked as delivered")
+	}
 
 	return nil
 }
 
-func handleIncomingReadUpdate(event ws.Event, client *ws.Client) error {
+func handleIncomingReadUpdate(incomingReadUpdate ws.IncomingReadUpdate, event ws.Event, client *ws.Client) error {
 	ctx := context.Background()
-	var incomingReadUpdate ws.IncomingReadUpdate
-	if err := json.Unmarshal(event.Payload, &incomingReadUpdate); err != nil {
-		log.Printf("Error Unmarshalling read update data %v", err)
-		client.SendAckToClient(ws.Acknowledge{
-			ReceiverID: client.UserId,
-			EventType:  event.Type,
-			Status:     false,
-			Message:    "error unmarshalling data",
-			AckTime:    time.Now().UnixNano(),
-		}, event.Id)
-		return err
-	}
-
-	if client.UserId != incomingReadUpdate.SenderId {
-		log.Printf("unauthorized, closing connection")
-		client.SendAckToClient(ws.Acknowledge{
-			ReceiverID: client.UserId,
-			EventType:  event.Type,
-			Status:     false,
-			Message:    "unauthorized, disconnecting",
-			AckTime:    time.Now().UnixNano(),
-		}, event.Id)
-		client.ConnectionManager.RemoveClient(client)
-		return errors.New("unauthorized, closing connection")
-	}
 
 	// check user is part of conversation
 	val, err := database.GetChatQueries().IsUserPartOfConversation(ctx, client.UserId, incomingReadUpdate.ConversationId)
-
 	if err != nil {
-		return err
+		return ws.Reject("cannot confirm authorization", err)
 	}
 
 	if !val {
-		log.Printf("unauthorized, doesn't belong to conversation")
-		client.SendAckToClient(ws.Acknowledge{
-			ReceiverID: client.UserId,
-			EventType:  event.Type,
-			Status:     false,
-			Message:    "unauthorized, disconnecting",
-			AckTime:    time.Now().UnixNano(),
-		}, event.Id)
-		client.ConnectionManager.RemoveClient(client)
-		return errors.New("unauthorized, doesn't belong to conversation")
+		return ws.RejectAndDisconnect("unauthorized, disconnecting", errors.New("doesn't belong to conversation"))
 	}
 
 	if err := database.GetChatQueries().UpdateLastMessageSeenInConversationForUser(ctx, incomingReadUpdate.ConversationId, incomingReadUpdate.SenderId, incomingReadUpdate.Time); err != nil {
-		client.SendAckToClient(ws.Acknowledge{
-			ReceiverID: client.UserId,
-			EventType:  event.Type,
-			Status:     false,
-			Message:    "DB error",
-			AckTime:    time.Now().UnixNano(),
-		}, event.Id)
-		return err
+		return ws.Reject("DB error", err)
 	}
 
-	client.SendAckToClient(ws.Acknowledge{
-		EventType: event.Type,
-		Status:    true,
-		Message:   "success",
-		AckTime:   time.Now().UnixNano(),
-	}, event.Id)
-
 	return nil
 }
 
@@ -226,31 +86,74 @@ func markMessageAsRecievedByUserWS(incomingDeliveredUpdate ws.IncomingDeliveredU
 	return deletedRows, nil
 }
 
-func getMessageFromId(msgId string, receiverId string) (models.OutgoingChatPayload, error) {
-	message, err := database.GetChatQueries().GetMessageByIdWS(context.Background(), msgId)
+// handleUndeliveredEvent is called by the outbox once a tracked event runs out of retries
+func handleUndeliveredEvent(event ws.Event, client *ws.Client) error {
+	// only chat messages are persisted per receiver, read/delivered updates are rebuilt from the message on sync
+	if event.Type != ws.EventOutgoingChatMessage {
+		log.Printf("dropping undelivered event %v of type %v for user %v", event.Id, event.Type, client.UserId)
+		return nil
+	}
 
-	if err != nil {
-		log.Printf("Error retreving message %v", err)
-		return models.OutgoingChatPayload{}, err
+	var outgoingChatPayload models.OutgoingChatPayload
+	if err := json.Unmarshal(event.Payload, &outgoingChatPayload); err != nil {
+		log.Printf("error unmarshalling undelivered chat message %v", err)
+		return err
 	}
 
-	return models.OutgoingChatPayload{
-		ID:                message.ID,
-		MessageBody:       message.Body,
-		Sender:            message.SenderID,
-		ConversationId:    message.ConversationID,
-		SentAt:            message.SentAt,
-		ServerRecieveTime: message.CreatedAt,
-		ReceiverId:        receiverId,
-	}, nil
+	return database.GetChatQueries().MarkMessageAsUndeliveredForUser(context.Background(), outgoingChatPayload.ID, client.UserId)
+}
+
+const (
+	WS_EVENTS_PER_SECOND = 20
+	WS_EVENT_BURST       = 50
+)
+
+var incomingPayloadSchemas = map[ws.EventType]ws.PayloadSchema{
+	ws.EventIncomingChatMessage: {
+		Required: []string{"sender_id", "message_body"},
+		Properties: map[string]ws.FieldType{
+			"id":              ws.FieldString,
+			"message_body":    ws.FieldString,
+			"sender_id":       ws.FieldString,
+			"conversation_id": ws.FieldString,
+			"is_group":        ws.FieldBoolean,
+			"receiver_id":     ws.FieldString,
+			"sent_at":         ws.FieldInteger,
+		},
+	},
+	ws.EventIncomingDeliveredUpdate: {
+		Required: []string{"message_id", "receiver_id"},
+		Properties: map[string]ws.FieldType{
+			"message_id":  ws.FieldString,
+			"receiver_id": ws.FieldString,
+			"time":        ws.FieldInteger,
+		},
+	},
+	ws.EventIncomingReadUpdate: {
+		Required: []string{"sender_id", "conversation_id", "time"},
+		Properties: map[string]ws.FieldType{
+			"id":              ws.FieldString,
+			"sender_id":       ws.FieldString,
+			"conversation_id": ws.FieldString,
+			"time":            ws.FieldInteger,
+		},
+	},
+	ws.EventIncomingSyncRequest: {
+		Properties: map[string]ws.FieldType{
+			"cursor":      ws.FieldString,
+			"query_count": ws.FieldInteger,
+		},
+	},
 }
 
 func RegisterWSHandlers() {
 	var handlers = make(map[ws.EventType]ws.EventHandler)
 
-	handlers[ws.EventIncomingDeliveredUpdate] = handleDeliveredUpdateForMessage
-	handlers[ws.EventIncomingChatMessage] = handleIncomingChatMessage
-	handlers[ws.EventIncomingReadUpdate] = handleIncomingReadUpdate
+	handlers[ws.EventIncomingDeliveredUpdate] = ws.Chain(ws.Decode(handleDeliveredUpdateForMessage), ws.Acknowledged())
+	handlers[ws.EventIncomingChatMessage] = ws.Chain(ws.Decode(handleIncomingChatMessage), ws.Acknowledged())
+	handlers[ws.EventIncomingReadUpdate] = ws.Chain(ws.Decode(handleIncomingReadUpdate), ws.Acknowledged())
+	// the sync response answers the request, failures are acknowledged
+	handlers[ws.EventIncomingSyncRequest] = ws.Decode(handleIncomingSyncRequest)
 
 	// handlers[ws.EventIncomingSocialRequest] = handleIncomingSocialRequest
 	// handlers[ws.EventIncomingSocialRequestStatusChange] = handleIncomingSocialRequestStatusChange
@@ -258,5 +161,18 @@ func RegisterWSHandlers() {
 	// handlers[ws.EventIncomingCalendarRequest] = handleIncomingCalendarRequest
 	// handlers[ws.EventIncomingCalendarRequestStatusChange] = handleIncomingCalendarRequestStatusChange
 
+	ws.GetConnectionManager().Use(
+		ws.Recover(),
+		ws.Logger(),
+		ws.RateLimit(WS_EVENTS_PER_SECOND, WS_EVENT_BURST),
+	)
 	ws.GetConnectionManager().SetupIncomingEventHandlers(handlers)
+	ws.GetConnectionManager().SetupPayloadSchemas(incomingPayloadSchemas)
+	ws.GetConnectionManager().SetupUndeliveredEventHandler(handleUndeliveredEvent)
+	RegisterRPCMethods()
+	ws.GetConnectionManager().SetupTopicAuthorizer(authorizeTopic)
+	ws.GetConnectionManager().SetupDeviceValidator(validateDeviceForTicket)
+	ws.GetConnectionManager().SetupTokenAuthenticator(socketTokenAuthenticator{
+		authenticator: auth.GetAuthenticator(),
+	})
 }
@@ -1,95 +1,99 @@
 package controllers
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"g_chat/database"
+	"g_chat/models"
 	ws "g_chat/wsConnections"
 	"log"
-	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
 // TODO : can seen_count, delivered_count be > sent_to_count.
 
-func chatWrittenHandler(channel string, payload string) error {
-	if channel != "chat_written" {
-		log.Printf("wrong channel")
-		return errors.New("wrong handler channel")
+func outgoingChatPayloadFromMessage(message database.Message, receiverId string) models.OutgoingChatPayload {
+	return models.OutgoingChatPayload{
+		ID:                message.ID,
+		MessageBody:       message.Body,
+		Sender:            message.SenderID,
+		ConversationId:    message.ConversationID,
+		SentAt:            message.SentAt,
+		ServerRecieveTime: message.CreatedAt,
+		ReceiverId:        receiverId,
 	}
+}
 
-	var msgUser database.Messageusermap
+func chatWrittenHandler(notifications []database.Notification) error {
+	for _, notification := range notifications {
+		receiverId, ok := notification.Envelope.Ids["receiver_id"]
+		if !ok {
+			log.Printf("chat_written notification without receiver_id for message %v", notification.Message.ID)
+			continue
+		}
 
-	if err := json.Unmarshal([]byte(payload), &msgUser); err != nil {
-		log.Printf("error unmarshalling payload, err : %v", err)
-		return err
-	}
+		ws.GetConnectionManager().PerformSendMessageToUserWS(outgoingChatPayloadFromMessage(notification.Message, receiverId))
 
-	message, err := getMessageFromId(msgUser.MessageID, msgUser.ReceiverID)
-
-	if err != nil {
-		log.Print("error getting message from DB : err %V", err)
-		return err
+		// the sender has a row of their own, publishing for it reaches the conversation topic once per message
+		if receiverId == notification.Message.SenderID {
+			publishToConversation(notification.Message, "message", outgoingChatPayloadFromMessage(notification.Message, ""))
+		}
 	}
 
-	ws.GetConnectionManager().PerformSendMessageToUserWS(message)
-
 	return nil
 }
 
-func chatReceivedHandler(channel string, payload string) error {
-	if channel != "chat_received" {
-		log.Printf("wrong channel")
-		return errors.New("wrong handler channel")
+func chatReceivedHandler(notifications []database.Notification) error {
+	for _, notification := range notifications {
+		message := notification.Message
+		ws.GetConnectionManager().QueueDeliveredUpdateWS(message.SenderID, message.ConversationID, message.ID, message.SentAt)
+		publishToConversation(message, "delivered", messageReceiptTopicData(message))
 	}
 
-	var message database.Message
-
-	if err := json.Unmarshal([]byte(payload), &message); err != nil {
-		log.Printf("error unmarshalling payload, err : %v", err)
-		return err
-	}
+	return nil
+}
 
-	deliveryUpdate := ws.OutgoingDeliveredUpdate{
-		MessageId:  message.ID,
-		ReceiverID: message.SenderID,
-		Time:       time.Now().UnixNano(),
+func chatReadHandler(notifications []database.Notification) error {
+	for _, notification := range notifications {
+		message := notification.Message
+		ws.GetConnectionManager().QueueReadUpdateWS(message.SenderID, message.ConversationID, message.ID, message.SentAt)
+		publishToConversation(message, "read", messageReceiptTopicData(message))
 	}
 
-	ws.GetConnectionManager().PerformOutgoingDeliveredUpdateWS(deliveryUpdate)
-
 	return nil
 }
 
-func chatReadHandler(channel string, payload string) error {
-	if channel != "chat_received" {
-		log.Printf("wrong channel")
-		return errors.New("wrong handler channel")
-	}
-
-	var message database.Message
+// publishToConversation sends the change to the sockets subscribed to the message's conversation, the
+// notifications are handled by the single listening instance so every change is published once
+func publishToConversation(message database.Message, kind string, data any) {
+	ws.GetConnectionManager().Publish(ws.Topic(ws.TopicConversation, message.ConversationID), kind, data)
+}
 
-	if err := json.Unmarshal([]byte(payload), &message); err != nil {
-		log.Printf("error unmarshalling payload, err : %v", err)
-		return err
-	}
+// publishToLFG sends a created, updated or deleted calendar event to the LFG feed of its game
+func publishToLFG(event database.Calendarevent, kind string) {
+	ws.GetConnectionManager().Publish(ws.Topic(ws.TopicLFG, event.GameID), kind, event)
+}
 
-	readUpdate := ws.OutgoingReadUpdate{
-		MessageId:  message.ID,
-		ReceiverID: message.SenderID,
-		Time:       time.Now().UnixNano(),
+// messageReceiptTopicData is published once a message was delivered to or read by every participant
+func messageReceiptTopicData(message database.Message) gin.H {
+	return gin.H{
+		"message_id":      message.ID,
+		"conversation_id": message.ConversationID,
+		"sender_id":       message.SenderID,
+		"sent_at":         message.SentAt,
 	}
-
-	ws.GetConnectionManager().PerformOutgoingReadUpdateWS(readUpdate)
-
-	return nil
 }
 
 func RegisterDBNotifyHandlers() {
-	var dbNotifyHandler = make(map[string]func(channel string, payload string) error)
+	registry := database.GetNotificationRegistry()
 
-	dbNotifyHandler["chat_written"] = chatWrittenHandler
-	dbNotifyHandler["chat_received"] = chatReceivedHandler
-	dbNotifyHandler["chat_read"] = chatReadHandler
+	registry.Register("chat_written", chatWrittenHandler)
+	registry.Register("chat_received", chatReceivedHandler)
+	registry.Register("chat_read", chatReadHandler)
+
+	ws.GetConnectionManager().SetupReceiptRangeResolver(resolveReceiptRange)
+}
 
-	ws.GetConnectionManager().SetupOutgoingEventHandlers(dbNotifyHandler)
+func resolveReceiptRange(conversationId string, senderId string, fromSentAt int64, toSentAt int64) ([]string, error) {
+	return database.GetChatQueries().GetSenderMessageIdsInRange(context.Background(), conversationId, senderId, fromSentAt, toSentAt)
 }
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"g_chat/database"
+	"g_chat/models"
+	ws "g_chat/wsConnections"
+)
+
+// same page limit as the REST endpoints
+const RPC_MAX_QUERY_COUNT = 20
+
+type conversationPageParams struct {
+	ConversationId string `json:"conversation_id"`
+	LastTimestamp  int64  `json:"last_timestamp"`
+	QueryCount     uint   `json:"query_count"`
+}
+
+func (params *conversationPageParams) Validate() error {
+	if params.QueryCount == 0 {
+		return errors.New("query_count is required")
+	}
+	params.QueryCount = min(params.QueryCount, RPC_MAX_QUERY_COUNT)
+	return nil
+}
+
+type conversationParams struct {
+	ConversationId string `json:"conversation_id"`
+}
+
+func (params *conversationParams) Validate() error {
+	if params.ConversationId == "" {
+		return errors.New("conversation_id is required")
+	}
+	return nil
+}
+
+type friendsPageParams struct {
+	Time     int64 `json:"time"`
+	RowCount uint  `json:"row_count"`
+}
+
+func (params *friendsPageParams) Validate() error {
+	if params.RowCount == 0 {
+		return errors.New("row_count is required")
+	}
+	params.RowCount = min(params.RowCount, RPC_MAX_QUERY_COUNT)
+	return nil
+}
+
+// rpcRequireMember fails unless the user of the socket is part of the conversation
+func rpcRequireMember(ctx context.Context, userId string, conversationId string) error {
+	isMember, err := database.GetChatQueries().IsUserPartOfConversation(ctx, userId, conversationId)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ws.NewRPCError(ws.RPCForbidden, "user not part of conversation")
+	}
+	return nil
+}
+
+func rpcRecentConversations(ctx context.Context, params conversationPageParams, client *ws.Client) ([]database.Conversation, error) {
+	return database.GetChatQueries().GetMostRecentConversationsForUser(ctx, client.UserId, params.LastTimestamp, params.QueryCount)
+}
+
+func rpcConversationMessages(ctx context.Context, params conversationPageParams, client *ws.Client) ([]database.Message, error) {
+	if params.ConversationId == "" {
+		return nil, ws.NewRPCError(ws.RPCInvalidRequest, "conversation_id is required")
+	}
+	if err := rpcRequireMember(ctx, client.UserId, params.ConversationId); err != nil {
+		return nil, err
+	}
+	return database.GetChatQueries().GetAllMessagesForConversation(ctx, params.ConversationId, params.LastTimestamp, params.QueryCount)
+}
+
+func rpcConversationUsers(ctx context.Context, params conversationParams, client *ws.Client) ([]string, error) {
+	if err := rpcRequireMember(ctx, client.UserId, params.ConversationId); err != nil {
+		return nil, err
+	}
+	return database.GetChatQueries().GetAllUsersInConversation(ctx, params.ConversationId)
+}
+
+func rpcFriends(ctx context.Context, params friendsPageParams, client *ws.Client) ([]models.SocialUser, error) {
+	return database.GetSocialQueries().GetFriends(ctx, client.UserId, params.Time, params.RowCount)
+}
+
+func RegisterRPCMethods() {
+	ws.GetConnectionManager().SetupRPCMethods(map[string]ws.RPCHandler{
+		"conversations.recent":   ws.RPCMethod(rpcRecentConversations),
+		"conversations.messages": ws.RPCMethod(rpcConversationMessages),
+		"conversations.users":    ws.RPCMethod(rpcConversationUsers),
+		"friends.list":           ws.RPCMethod(rpcFriends),
+	})
+}
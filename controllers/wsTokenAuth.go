@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"context"
+	"g_chat/auth"
+	ws "g_chat/wsConnections"
+	"time"
+)
+
+// socketTokenAuthenticator lets open sockets refresh and revalidate their tokens with the configured authenticator
+type socketTokenAuthenticator struct {
+	authenticator auth.Authenticator
+}
+
+func (socketAuth socketTokenAuthenticator) Verify(ctx context.Context, token string) (ws.TokenInfo, error) {
+	identity, err := socketAuth.authenticator.Verify(ctx, token)
+	if err != nil {
+		return ws.TokenInfo{}, err
+	}
+
+	return ws.TokenInfo{
+		UserId:    identity.UserId,
+		IssuedAt:  identity.IssuedAt,
+		ExpiresAt: identity.ExpiresAt,
+	}, nil
+}
+
+func (socketAuth socketTokenAuthenticator) Revalidate(ctx context.Context, userId string, issuedAt time.Time) (bool, error) {
+	return socketAuth.authenticator.Revalidate(ctx, userId, issuedAt)
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"context"
+	"g_chat/database"
+	ws "g_chat/wsConnections"
+)
+
+// authorizeTopic decides who may subscribe : conversations and events to their members, presence to the
+// user and their friends and a game's LFG feed to users who can see the game
+func authorizeTopic(userId string, topic string) (bool, error) {
+	kind, id, err := ws.SplitTopic(topic)
+	if err != nil {
+		return false, nil
+	}
+
+	ctx := context.Background()
+	switch kind {
+	case ws.TopicConversation:
+		return database.GetChatQueries().IsUserPartOfConversation(ctx, userId, id)
+
+	case ws.TopicEvent:
+		isOrganizer, err := database.GetCalendarQueries().IsUserOrganizerOfEvent(ctx, userId, id)
+		if err != nil || isOrganizer {
+			return isOrganizer, err
+		}
+		return database.GetCalendarQueries().AreUserAlreadyAParticipantOfCalendarEvent(ctx, []string{userId}, id)
+
+	case ws.TopicPresence:
+		if id == userId {
+			return true, nil
+		}
+		return database.GetSocialQueries().FAreUserFriends(ctx, userId, id)
+
+	case ws.TopicLFG:
+		return database.GetCalendarQueries().IsGameVisibleToUser(ctx, userId, id)
+	}
+	return false, nil
+}
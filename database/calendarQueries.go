@@ -114,12 +114,25 @@ func (db *CalendarQueries) IsUserOrganizerOfEvent(ctx context.Context, userId st
 	return val, nil
 }
 
-func (db *CalendarQueries) DeleteCalendarEvent(ctx context.Context, eventId string) error {
-	if _, err := db.Queries.organizerRequestToDeleteEvent(ctx, eventId); err != nil {
-		return err
+// IsGameVisibleToUser returns true if the game has an upcoming event or the user organized or joined one of
+// its events, there is no games table so a game is only known through its calendar events
+func (db *CalendarQueries) IsGameVisibleToUser(ctx context.Context, userId string, gameId string) (bool, error) {
+	val, err := db.Queries.fIsGameVisibleToUser(ctx, fIsGameVisibleToUserParams{
+		UserID: userId,
+		GameID: gameId,
+		ToTime: time.Now().UnixNano(),
+	})
+
+	if err != nil {
+		return false, err
 	}
 
-	return nil
+	return val, nil
+}
+
+// DeleteCalendarEvent returns the deleted event
+func (db *CalendarQueries) DeleteCalendarEvent(ctx context.Context, eventId string) (Calendarevent, error) {
+	return db.Queries.organizerRequestToDeleteEvent(ctx, eventId)
 }
 
 func (db *CalendarQueries) IsCalendarEventExistsInFuture(ctx context.Context, eventId string) (bool, error) {
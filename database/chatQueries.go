@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type ChatQueries struct {
@@ -236,7 +237,7 @@ func (db *ChatQueries) WriteIncomingMessages(ctx context.Context, incomingChatPa
 	return nil
 }
 
-// For a list of messages it removes the message from the UnsentMessages table
+// For a list of messages it marks them as delivered to the user, messages already delivered or sent by the user are skipped
 func (db *ChatQueries) MarkMessagesAsRecievedByUser(ctx context.Context, messageIds []string, userId string) ([]Messageusermap, error) {
 	tx, err := getDatabase().BeginTx(ctx, nil)
 	if err != nil {
@@ -247,23 +248,34 @@ func (db *ChatQueries) MarkMessagesAsRecievedByUser(ctx context.Context, message
 
 	qtx := db.Queries.WithTx(tx)
 
-	// TODO : this can cause error if multiple clients open at the same time. As messages will be deleted for another client which current client will also try to delete.
-	deletedRows, err := qtx.markMessageAsReceivedByUser(ctx, markMessageAsReceivedByUserParams{
+	deliveredRows, err := qtx.markMessageAsReceivedByUser(ctx, markMessageAsReceivedByUserParams{
 		Column1:    messageIds,
 		ReceiverID: userId,
+		DeliveredAt: sql.NullInt64{
+			Int64: time.Now().UnixNano(),
+			Valid: true,
+		},
 	})
 
 	if err != nil {
-		log.Printf("DB error : error deleting message : f(MarkMessagesAsRecievedByUser) : error : %v", err)
+		log.Printf("DB error : error marking message as delivered : f(MarkMessagesAsRecievedByUser) : error : %v", err)
 		return nil, err
 	}
 
-	// TODO: do this using trigger ie for every deleted message from messageusermap increment that messages count by 1
-	err = qtx.updateDeliveredCountForMessages(ctx, messageIds)
+	// only messages delivered by this call are counted so multiple clients of the user do not count twice
+	deliveredIds := make([]string, len(deliveredRows))
+	for i, row := range deliveredRows {
+		deliveredIds[i] = row.MessageID
+	}
 
-	if err != nil {
-		log.Printf("DB error : error updating delivery count in message : f(MarkMessagesAsRecievedByUser) : error : %v", err)
-		return nil, err
+	// TODO: do this using trigger ie for every delivered message from messageusermap increment that messages count by 1
+	if len(deliveredIds) > 0 {
+		err = qtx.updateDeliveredCountForMessages(ctx, pq.Array(deliveredIds))
+
+		if err != nil {
+			log.Printf("DB error : error updating delivery count in message : f(MarkMessagesAsRecievedByUser) : error : %v", err)
+			return nil, err
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -271,11 +283,69 @@ func (db *ChatQueries) MarkMessagesAsRecievedByUser(ctx context.Context, message
 		return nil, err
 	}
 
-	return deletedRows, nil
+	return deliveredRows, nil
+}
+
+// Marks a message as undelivered for the receiver so it is sent again when the user syncs
+func (db *ChatQueries) MarkMessageAsUndeliveredForUser(ctx context.Context, messageId string, userId string) error {
+	err := db.Queries.markMessageAsUndeliveredForUser(ctx, markMessageAsUndeliveredForUserParams{
+		MessageID:  messageId,
+		ReceiverID: userId,
+		UndeliveredAt: sql.NullInt64{
+			Int64: time.Now().UnixNano(),
+			Valid: true,
+		},
+	})
+
+	if err != nil {
+		log.Printf("DB error : error marking message as undelivered : f(MarkMessageAsUndeliveredForUser) : error : %v", err)
+		return err
+	}
+
+	return nil
 }
 
-// For a given message it updates the conversation participant table to mark last_message_seen_at column with the timestamp of the message
-func (db *ChatQueries) UpdateLastMessageSeenInConversationForUser(ctx context.Context, conversationId string, userId string, time int64) error {
+// Returns ids of the messages a sender wrote in the conversation between two send times, in send order
+func (db *ChatQueries) GetSenderMessageIdsInRange(ctx context.Context, conversationId string, senderId string, fromSentAt int64, toSentAt int64) ([]string, error) {
+	ids, err := db.Queries.getSenderMessageIdsInRange(ctx, getSenderMessageIdsInRangeParams{
+		ConversationID: conversationId,
+		SenderID:       senderId,
+		SentAt:         fromSentAt,
+		SentAt_2:       toSentAt,
+	})
+
+	if err != nil {
+		log.Printf("DB error : error getting messages of sender in range : f(GetSenderMessageIdsInRange) : error : %v", err)
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Returns the delivered/seen time of every receiver of the message, seen time is hidden for receivers who disabled read receipts
+func (db *ChatQueries) GetReceiptsForMessage(ctx context.Context, messageId string) ([]models.MessageReceipt, error) {
+	rows, err := db.Queries.getReceiptsForMessage(ctx, messageId)
+	if err != nil {
+		log.Printf("DB error : error getting receipts of message : f(GetReceiptsForMessage) : error : %v", err)
+		return nil, err
+	}
+
+	receipts := make([]models.MessageReceipt, len(rows))
+	for i, row := range rows {
+		receipts[i] = models.MessageReceipt{
+			ReceiverId:  row.ReceiverID,
+			DeliveredAt: row.DeliveredAt.Int64,
+			SeenAt:      row.SeenAt.Int64,
+		}
+	}
+
+	return receipts, nil
+}
+
+// For a given message it updates the conversation participant table to mark last_message_seen_at column with the timestamp of the message.
+// Seen is tracked per receiver in MessageUserMap, only messages of this conversation sent by others which the user had not seen yet
+// are counted so repeated or out of order reads never count a message twice.
+func (db *ChatQueries) UpdateLastMessageSeenInConversationForUser(ctx context.Context, conversationId string, userId string, lastSeenAt int64) error {
 	tx, err := getDatabase().BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("DB error : creating transaction failed : f(UpdateLastMessageSeenInConversationForUser) : error : %v", err)
@@ -285,7 +355,7 @@ func (db *ChatQueries) UpdateLastMessageSeenInConversationForUser(ctx context.Co
 
 	qtx := db.Queries.WithTx(tx)
 
-	// get last message seen at for conversation and user
+	// get last message seen at for conversation and user, fails if the user is not a participant
 	lastMessageSeenTime, err := qtx.getLastMessageSeenTimeByUserInConversation(ctx, getLastMessageSeenTimeByUserInConversationParams{
 		UserID:         userId,
 		ConversationID: conversationId,
@@ -296,35 +366,80 @@ func (db *ChatQueries) UpdateLastMessageSeenInConversationForUser(ctx context.Co
 		return err
 	}
 
-	// check if provided timestamp > current in DB
-	if lastMessageSeenTime > time {
-		log.Printf("incorrect params for time : f(UpdateLastMessageSeenInConversationForUser)")
-		return errors.New("wrong time provided")
-	}
+	// last message seen at only ever moves forward, an older read is still applied below as it is a no-op there
+	if lastSeenAt > lastMessageSeenTime {
+		err = qtx.updateLastMessageSeenAtInConversationParticipant(ctx, updateLastMessageSeenAtInConversationParticipantParams{
+			ConversationID:    conversationId,
+			UserID:            userId,
+			LastMessageSeenAt: lastSeenAt,
+		})
 
-	// update last message seen at
-	err = qtx.updateLastMessageSeenAtInConversationParticipant(ctx, updateLastMessageSeenAtInConversationParticipantParams{
-		ConversationID:    conversationId,
-		UserID:            userId,
-		LastMessageSeenAt: time,
-	})
+		if err != nil {
+			log.Printf("DB error : error updating last message seen at : f(UpdateLastMessageSeenInConversationForUser) : error -> %v", err)
+			return err
+		}
+	}
 
+	settings, err := userSettingsWithDefaults(ctx, qtx, userId)
 	if err != nil {
-		log.Printf("DB error : error updating last message seen at : f(UpdateLastMessageSeenInConversationForUser) : error -> %v", err)
+		log.Printf("DB error : error getting user settings : f(UpdateLastMessageSeenInConversationForUser) : error -> %v", err)
 		return err
 	}
 
-	// update seen count for all messages between timestamps
-	err = qtx.updateSeenCountForMessagesBetweenTimestamps(ctx, updateSeenCountForMessagesBetweenTimestampsParams{
-		CreatedAt:   lastMessageSeenTime,
-		CreatedAt_2: time,
+	// user does not send read receipts, only their own last seen time is kept
+	if !settings.ReadReceiptsEnabled {
+		if err := tx.Commit(); err != nil {
+			log.Printf("DB error : error committing transaction : f(UpdateLastMessageSeenInConversationForUser) : error -> %v", err)
+			return err
+		}
+		return nil
+	}
+
+	// mark per receiver seen time for every message of the conversation up to the given time, returns only newly seen messages.
+	// The given time is only the read up to point, seen (and delivered for messages read before their delivery ack) is now.
+	seenRows, err := qtx.markMessagesAsSeenByUser(ctx, markMessagesAsSeenByUserParams{
+		ReceiverID:     userId,
+		ConversationID: conversationId,
+		CreatedAt:      lastSeenAt,
+		SeenAt: sql.NullInt64{
+			Int64: time.Now().UnixNano(),
+			Valid: true,
+		},
 	})
 
 	if err != nil {
-		log.Printf("DB error : error updating seen count of messages : f(UpdateLastMessageSeenInConversationForUser) : error -> %v", err)
+		log.Printf("DB error : error marking messages as seen : f(UpdateLastMessageSeenInConversationForUser) : error -> %v", err)
 		return err
 	}
 
+	seenIds := make([]string, len(seenRows))
+	deliveredIds := make([]string, 0, len(seenRows))
+	for i, row := range seenRows {
+		seenIds[i] = row.MessageID
+		if row.WasUndelivered {
+			deliveredIds = append(deliveredIds, row.MessageID)
+		}
+	}
+
+	// a read message counts as delivered, the delivery ack skips it later so it is counted here
+	if len(deliveredIds) > 0 {
+		err = qtx.updateDeliveredCountForMessages(ctx, pq.Array(deliveredIds))
+
+		if err != nil {
+			log.Printf("DB error : error updating delivery count in message : f(UpdateLastMessageSeenInConversationForUser) : error -> %v", err)
+			return err
+		}
+	}
+
+	if len(seenIds) > 0 {
+		err = qtx.updateSeenCountForMessages(ctx, pq.Array(seenIds))
+
+		if err != nil {
+			log.Printf("DB error : error updating seen count of messages : f(UpdateLastMessageSeenInConversationForUser) : error -> %v", err)
+			return err
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Printf("DB error : error committing transaction : f(UpdateLastMessageSeenInConversationForUser) : error -> %v", err)
 		return err
@@ -361,23 +476,37 @@ func (db *ChatQueries) GetAllUsersInConversationWS(ctx context.Context, conversa
 	return ids, nil
 }
 
-// Gets most recent conversation for a user after the given timestamp.
+// Gets most recent conversation for a user before the given timestamp. only numRows are returned
 func (db *ChatQueries) GetMostRecentConversationsForUser(ctx context.Context, userId string, timestamp int64, numRows uint) ([]Conversation, error) {
-	db.Queries.getMostRecentConversationsForUser(ctx, getMostRecentConversationsForUserParams{
+	conversations, err := db.Queries.getMostRecentConversationsForUser(ctx, getMostRecentConversationsForUserParams{
 		UserID:        userId,
 		LastMessageAt: timestamp,
+		Limit:         int32(numRows),
 	})
-	return nil, nil
+	if err != nil {
+		log.Printf("DB error : error getting recent conversations : f(GetMostRecentConversationsForUser) : error : %v", err)
+		return nil, err
+	}
+	return conversations, nil
 }
 
 // Gets all Messages after a given TIME for a given conversation. only numRows are returned
-func (db *ChatQueries) GetAllMessagesForConversation(ctx context.Context, conversationId string, time int64, numRows uint) ([]Conversation, error) {
-	db.Queries.getAllMessagesForConversation(ctx, getAllMessagesForConversationParams{
+func (db *ChatQueries) GetAllMessagesForConversation(ctx context.Context, conversationId string, time int64, numRows uint) ([]Message, error) {
+	rows, err := db.Queries.getAllMessagesForConversation(ctx, getAllMessagesForConversationParams{
 		ConversationID: conversationId,
 		CreatedAt:      time,
 		Limit:          int32(numRows),
 	})
-	return nil, nil
+	if err != nil {
+		log.Printf("DB error : error getting messages of conversation : f(GetAllMessagesForConversation) : error : %v", err)
+		return nil, err
+	}
+
+	messages := make([]Message, len(rows))
+	for i, row := range rows {
+		messages[i] = Message(row)
+	}
+	return messages, nil
 }
 
 // Get all messages for a conversation after a timestamp
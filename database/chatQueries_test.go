@@ -0,0 +1,190 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// lastMessageSeenAt reads the stored last seen time of the user in the conversation
+func lastMessageSeenAt(t *testing.T, conversationId string, userId string) int64 {
+	t.Helper()
+	seenAt, err := getQueries().getLastMessageSeenTimeByUserInConversation(context.Background(), getLastMessageSeenTimeByUserInConversationParams{
+		UserID:         userId,
+		ConversationID: conversationId,
+	})
+	if err != nil {
+		t.Fatalf("getting last message seen at: %v", err)
+	}
+	return seenAt
+}
+
+// messageCounts reads the delivered and seen counters of a message
+func messageCounts(t *testing.T, messageId string) (delivered int32, seen int32) {
+	t.Helper()
+	message, err := GetChatQueries().GetMessageByID(context.Background(), messageId)
+	if err != nil {
+		t.Fatalf("getting message: %v", err)
+	}
+	return message.DeliveredCount, message.SeenCount
+}
+
+func TestLastMessageSeenOnlyMovesForward(t *testing.T) {
+	setupTestDatabase(t)
+	createTestConversation(t, "conversation", "sender", "receiver")
+
+	tests := []struct {
+		name string
+		read int64
+		want int64
+	}{
+		{name: "first read", read: 200, want: 200},
+		{name: "older read is ignored", read: 100, want: 200},
+		{name: "same read is ignored", read: 200, want: 200},
+		{name: "newer read moves forward", read: 300, want: 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := GetChatQueries().UpdateLastMessageSeenInConversationForUser(context.Background(), "conversation", "receiver", tt.read)
+			if err != nil {
+				t.Fatalf("updating last message seen: %v", err)
+			}
+
+			if got := lastMessageSeenAt(t, "conversation", "receiver"); got != tt.want {
+				t.Errorf("last message seen at = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeenCountsEachReceiverOnce(t *testing.T) {
+	setupTestDatabase(t)
+	createTestConversation(t, "conversation", "sender", "receiver-a", "receiver-b")
+	createTestConversation(t, "other", "other-sender", "other-receiver")
+
+	older := createTestMessages(t, "conversation", "sender", 100, 1)[0]
+	newer := createTestMessages(t, "conversation", "sender", 200, 1)[0]
+	other := createTestMessages(t, "other", "other-sender", 100, 1)[0]
+
+	reads := []struct {
+		userId string
+		time   int64
+	}{
+		// the sender reading their own conversation is not a read of their messages
+		{userId: "sender", time: 200},
+		{userId: "receiver-a", time: 100},
+		// reading again and reading further only count the newly seen message
+		{userId: "receiver-a", time: 100},
+		{userId: "receiver-a", time: 200},
+		// an older read after a newer one counts nothing
+		{userId: "receiver-a", time: 150},
+		{userId: "receiver-b", time: 200},
+	}
+	for _, read := range reads {
+		err := GetChatQueries().UpdateLastMessageSeenInConversationForUser(context.Background(), "conversation", read.userId, read.time)
+		if err != nil {
+			t.Fatalf("updating last message seen of %v: %v", read.userId, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		messageId string
+		wantSeen  int32
+	}{
+		{name: "older message", messageId: older, wantSeen: 3},
+		{name: "newer message", messageId: newer, wantSeen: 3},
+		{name: "other conversation", messageId: other, wantSeen: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, seen := messageCounts(t, tt.messageId); seen != tt.wantSeen {
+				t.Errorf("seen count = %v, want %v", seen, tt.wantSeen)
+			}
+		})
+	}
+}
+
+func TestDeliveredCountSkipsSender(t *testing.T) {
+	setupTestDatabase(t)
+	createTestConversation(t, "conversation", "sender", "receiver")
+	messageId := createTestMessages(t, "conversation", "sender", 100, 1)[0]
+
+	tests := []struct {
+		name          string
+		userId        string
+		wantMarked    int
+		wantDelivered int32
+	}{
+		{name: "sender", userId: "sender", wantMarked: 0, wantDelivered: 1},
+		{name: "receiver", userId: "receiver", wantMarked: 1, wantDelivered: 2},
+		{name: "receiver again", userId: "receiver", wantMarked: 0, wantDelivered: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			marked, err := GetChatQueries().MarkMessagesAsRecievedByUser(context.Background(), []string{messageId}, tt.userId)
+			if err != nil {
+				t.Fatalf("marking message as received: %v", err)
+			}
+
+			if len(marked) != tt.wantMarked {
+				t.Errorf("marked %v messages, want %v", len(marked), tt.wantMarked)
+			}
+			if delivered, _ := messageCounts(t, messageId); delivered != tt.wantDelivered {
+				t.Errorf("delivered count = %v, want %v", delivered, tt.wantDelivered)
+			}
+		})
+	}
+}
+
+func TestReadWithoutDeliveryCountsAsDelivered(t *testing.T) {
+	setupTestDatabase(t)
+	createTestConversation(t, "conversation", "sender", "receiver-a", "receiver-b")
+	messageId := createTestMessages(t, "conversation", "sender", 100, 1)[0]
+	readStart := time.Now().UnixNano()
+
+	steps := []struct {
+		name          string
+		userId        string
+		read          bool
+		wantDelivered int32
+	}{
+		// receiver-a reads before their client acknowledged the delivery
+		{name: "read without delivery", userId: "receiver-a", read: true, wantDelivered: 2},
+		{name: "late delivery ack", userId: "receiver-a", wantDelivered: 2},
+		{name: "delivery then read", userId: "receiver-b", wantDelivered: 3},
+		{name: "read after delivery", userId: "receiver-b", read: true, wantDelivered: 3},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			var err error
+			if step.read {
+				err = GetChatQueries().UpdateLastMessageSeenInConversationForUser(context.Background(), "conversation", step.userId, 100)
+			} else {
+				_, err = GetChatQueries().MarkMessagesAsRecievedByUser(context.Background(), []string{messageId}, step.userId)
+			}
+			if err != nil {
+				t.Fatalf("updating receipt: %v", err)
+			}
+
+			if delivered, _ := messageCounts(t, messageId); delivered != step.wantDelivered {
+				t.Errorf("delivered count = %v, want %v", delivered, step.wantDelivered)
+			}
+		})
+	}
+
+	receipts, err := GetChatQueries().GetReceiptsForMessage(context.Background(), messageId)
+	if err != nil {
+		t.Fatalf("getting receipts: %v", err)
+	}
+	for _, receipt := range receipts {
+		// receipts keep the time of the read, not the read up to time sent by the client
+		if receipt.DeliveredAt < readStart || receipt.SeenAt < readStart {
+			t.Errorf("receipt of %v = %+v, want times after %v", receipt.ReceiverId, receipt, readStart)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	ws "g_chat/wsConnections"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// only one instance in the cluster consumes the chat notification channels, the others wait on this lock
+const LISTENER_LOCK_KEY = 727274
+
+const clusterChannelPrefix = "ws_node_"
+
+// pg_notify rejects payloads of 8000 bytes or more, larger messages are stored in ClusterMessages and the
+// notification only carries their id after clusterMessageRefPrefix
+const CLUSTER_NOTIFY_MAX_PAYLOAD = 7900
+
+const clusterMessageRefPrefix = "ref:"
+
+// stored messages are read as soon as the notification arrives, older rows are removed when the next one is stored
+const CLUSTER_MESSAGE_TTL = time.Minute
+
+// PostgresBroker uses a LISTEN/NOTIFY channel per node to pass messages between instances
+type PostgresBroker struct{}
+
+func (broker *PostgresBroker) Publish(ctx context.Context, nodeId string, payload []byte) error {
+	notification := string(payload)
+
+	if len(payload) >= CLUSTER_NOTIFY_MAX_PAYLOAD {
+		id := uuid.NewString()
+		now := time.Now()
+
+		err := getQueries().createClusterMessage(ctx, createClusterMessageParams{
+			ID:        id,
+			Payload:   notification,
+			CreatedAt: now.UnixNano(),
+		})
+		if err != nil {
+			log.Printf("DB error : storing cluster message failed : f(Publish) : error -> %v", err)
+			return err
+		}
+
+		if err := getQueries().deleteExpiredClusterMessages(ctx, now.Add(-CLUSTER_MESSAGE_TTL).UnixNano()); err != nil {
+			log.Printf("DB error : removing expired cluster messages failed : f(Publish) : error -> %v", err)
+		}
+
+		notification = clusterMessageRefPrefix + id
+	}
+
+	_, err := pool.Exec(ctx, "SELECT pg_notify($1, $2)", clusterChannelPrefix+nodeId, notification)
+	return err
+}
+
+// loadClusterPayload returns the message a notification refers to, small messages are sent inline
+func loadClusterPayload(ctx context.Context, notification string) ([]byte, error) {
+	id, stored := strings.CutPrefix(notification, clusterMessageRefPrefix)
+	if !stored {
+		return []byte(notification), nil
+	}
+
+	payload, err := getQueries().getClusterMessage(ctx, id)
+	if err != nil {
+		log.Printf("DB error : loading cluster message %v failed : f(loadClusterPayload) : error -> %v", id, err)
+		return nil, err
+	}
+	return []byte(payload), nil
+}
+
+func (broker *PostgresBroker) Subscribe(ctx context.Context, nodeId string, handler func(payload []byte)) error {
+	channel := pgx.Identifier{clusterChannelPrefix + nodeId}.Sanitize()
+
+	go func() {
+		for {
+			if err := subscribeToChannel(ctx, channel, handler); err != nil {
+				log.Printf("error on cluster channel %v : error - %v", channel, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+
+	return nil
+}
+
+func subscribeToChannel(ctx context.Context, channel string, handler func(payload []byte)) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer releaseListenConn(conn)
+
+	if _, err := conn.Exec(ctx, "listen "+channel); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		payload, err := loadClusterPayload(ctx, notification.Payload)
+		if err != nil {
+			continue
+		}
+		handler(payload)
+	}
+}
+
+// PostgresPresence stores which node holds sockets of a user in the WSPresence table
+type PostgresPresence struct{}
+
+func (presence *PostgresPresence) Register(ctx context.Context, userId string, nodeId string) error {
+	return getQueries().registerPresence(ctx, registerPresenceParams{
+		UserID:      userId,
+		NodeID:      nodeId,
+		ConnectedAt: time.Now().UnixNano(),
+	})
+}
+
+func (presence *PostgresPresence) Unregister(ctx context.Context, userId string, nodeId string) error {
+	return getQueries().unregisterPresence(ctx, unregisterPresenceParams{
+		UserID: userId,
+		NodeID: nodeId,
+	})
+}
+
+func (presence *PostgresPresence) NodesForUser(ctx context.Context, userId string) ([]string, error) {
+	return getQueries().getNodesForUser(ctx, userId)
+}
+
+// EnableCluster switches the connection manager to cluster mode backed by Postgres, presence left over
+// from a previous run of the same node is cleared first
+func EnableCluster(connectionManager *ws.ConnectionManager, nodeId string) error {
+	if err := createPool(); err != nil {
+		return err
+	}
+
+	if err := getQueries().clearPresenceForNode(context.Background(), nodeId); err != nil {
+		log.Printf("DB error : clearing stale presence failed : f(EnableCluster) : error -> %v", err)
+		return err
+	}
+
+	// cluster subscriptions stop with the connection manager
+	return connectionManager.EnableCluster(connectionManager.Context(), nodeId, &PostgresBroker{}, &PostgresPresence{})
+}
+
+// waitForListenerLock blocks until this instance becomes the one consuming chat notifications
+func waitForListenerLock(ctx context.Context, conn *pgx.Conn) error {
+	for {
+		var locked bool
+		if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", LISTENER_LOCK_KEY).Scan(&locked); err != nil {
+			return err
+		}
+
+		if locked {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
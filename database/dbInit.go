@@ -2,9 +2,7 @@ package database
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"os"
 
 	"database/sql"
 	ws "g_chat/wsConnections"
@@ -21,11 +19,17 @@ var (
 	myDatabase *DB
 	queries    *Queries
 	pool       *pgxpool.Pool
+	// stops the notification listener, see Shutdown
+	stopListener    context.CancelFunc
+	listenerStopped chan struct{}
 )
 
-var dsn string = "host=localhost user=postgres password=*Fu1ab%1 dbname=hog-gg port=5432 sslmode=disable"
+// set from the configuration by CreateDatabaseInstance, also used for the pgx pool
+var dsn string
+
+func CreateDatabaseInstance(databaseDSN string) error {
+	dsn = databaseDSN
 
-func CreateDatabaseInstance() error {
 	myDb, err := sql.Open("postgres", dsn)
 
 	defer func() {
@@ -53,15 +57,64 @@ func CreateDatabaseInstance() error {
 }
 
 func InitializeListener(connectionManager *ws.ConnectionManager) error {
+	if err := createPool(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopListener = cancel
+	listenerStopped = make(chan struct{})
+
+	go func() {
+		defer close(listenerStopped)
+		superviseListener(ctx, connectionManager)
+	}()
+
+	return nil
+}
+
+// Shutdown stops the notification listener, letting a batch being dispatched finish, then closes the
+// pgx pool and the database. The pools are closed even when ctx expires before the listener stopped.
+func Shutdown(ctx context.Context) error {
+	var err error
+
+	if stopListener != nil {
+		stopListener()
+
+		select {
+		case <-listenerStopped:
+		case <-ctx.Done():
+			err = ctx.Err()
+			log.Printf("notification listener did not stop in time : f(Shutdown) : error -> %v", err)
+		}
+	}
+
+	if pool != nil {
+		pool.Close()
+	}
+
+	if myDatabase != nil {
+		if closeErr := myDatabase.Close(); closeErr != nil {
+			log.Printf("DB error : closing database failed : f(Shutdown) : error -> %v", closeErr)
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func createPool() error {
+	if pool != nil {
+		return nil
+	}
+
 	newPool, err := pgxpool.New(context.Background(), dsn)
 	if err != nil {
-		log.Printf("connection error : cannot connect to DB : f(initializeListener) : error -> %v", err)
+		log.Printf("connection error : cannot connect to DB : f(createPool) : error -> %v", err)
 		return err
 	}
 	pool = newPool
 
-	go listen(connectionManager)
-
 	return nil
 }
 
@@ -80,55 +133,3 @@ func getQueries() *Queries {
 func (db *DB) MigrateDatabase() error {
 	return nil
 }
-
-func listen(connectionManager *ws.ConnectionManager) {
-	// get a connection for notification
-	conn, err := pool.Acquire(context.Background())
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error acquiring connection:", err)
-		os.Exit(1)
-	}
-	defer conn.Release()
-
-	// register chat_written channel
-	_, err = conn.Exec(context.Background(), "listen chat_written")
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error listening to chat_written channel:", err)
-		os.Exit(1)
-	}
-
-	// register chat_received channel
-	_, err = conn.Exec(context.Background(), "listen chat_received")
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error listening to chat_received channel:", err)
-		os.Exit(1)
-	}
-
-	// register chat_read channel
-	_, err = conn.Exec(context.Background(), "listen chat_read")
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error listening to chat_read channel:", err)
-		os.Exit(1)
-	}
-
-	// handle notifications
-	for {
-		notification, err := conn.Conn().WaitForNotification(context.Background())
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error waiting for notification:", err)
-			os.Exit(1)
-		}
-
-		_, ok := connectionManager.OutgoingHandlers[notification.Channel]
-
-		if !ok {
-			log.Printf("channel not registered")
-		} else {
-			err := connectionManager.OutgoingHandlers[notification.Channel](notification.Channel, notification.Payload)
-
-			if err != nil {
-				log.Printf("error doing %v operation with payload %v", notification.Channel, notification.Payload)
-			}
-		}
-	}
-}
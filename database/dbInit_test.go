@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// database tests need a disposable postgres and are skipped unless TEST_DATABASE_DSN is set, every test
+// starts from empty tables
+func setupTestDatabase(t *testing.T) {
+	t.Helper()
+
+	testDSN := os.Getenv("TEST_DATABASE_DSN")
+	if testDSN == "" {
+		t.Skip("TEST_DATABASE_DSN not set")
+	}
+
+	if getDatabase() == nil {
+		if err := CreateDatabaseInstance(testDSN); err != nil {
+			t.Fatalf("connecting to test database: %v", err)
+		}
+
+		schema, err := os.ReadFile("../schema.sql")
+		if err != nil {
+			t.Fatalf("reading schema: %v", err)
+		}
+		if _, err := getDatabase().Exec(string(schema)); err != nil {
+			t.Fatalf("applying schema: %v", err)
+		}
+	}
+
+	_, err := getDatabase().Exec(`TRUNCATE Users, Conversations, Messages, MessageUserMap, ConversationParticipants,
+		UserSettings, ListenerState, ClusterMessages CASCADE`)
+	if err != nil {
+		t.Fatalf("truncating tables: %v", err)
+	}
+}
+
+// createTestConversation creates the users and a group conversation holding all of them
+func createTestConversation(t *testing.T, conversationId string, userIds ...string) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().UnixNano()
+
+	_, err := getQueries().createConversation(ctx, createConversationParams{
+		ID:        conversationId,
+		IsGroup:   true,
+		OwnerID:   sql.NullString{String: userIds[0], Valid: true},
+		Name:      sql.NullString{String: conversationId, Valid: true},
+		CreatedAt: now,
+	})
+	if err != nil {
+		t.Fatalf("creating conversation: %v", err)
+	}
+
+	for i, userId := range userIds {
+		err := getQueries().createUser(ctx, createUserParams{
+			ID:        userId,
+			Name:      userId,
+			Username:  userId,
+			Email:     userId + "@example.com",
+			CreatedAt: now,
+		})
+		if err != nil {
+			t.Fatalf("creating user %v: %v", userId, err)
+		}
+
+		err = getQueries().createConversationParticipant(ctx, createConversationParticipantParams{
+			ConversationID: conversationId,
+			UserID:         userId,
+			IsOwner:        i == 0,
+			JoinedAt:       now,
+		})
+		if err != nil {
+			t.Fatalf("adding participant %v: %v", userId, err)
+		}
+	}
+}
+
+// createTestMessages inserts count messages from the sender created at the given time along with a
+// MessageUserMap row for every participant, the same rows WriteIncomingMessageWS writes
+func createTestMessages(t *testing.T, conversationId string, senderId string, createdAt int64, count int) []string {
+	t.Helper()
+	ctx := context.Background()
+
+	participants, err := getQueries().getAllUsersInConversation(ctx, conversationId)
+	if err != nil {
+		t.Fatalf("getting participants: %v", err)
+	}
+
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%v-%v-%05d", conversationId, createdAt, i)
+
+		_, err := getQueries().createMessage(ctx, createMessageParams{
+			ID:             ids[i],
+			Body:           "body",
+			ConversationID: conversationId,
+			SenderID:       senderId,
+			DeliveredCount: 1,
+			SeenCount:      1,
+			SentToCount:    int32(len(participants)),
+			SentAt:         createdAt,
+			CreatedAt:      createdAt,
+		})
+		if err != nil {
+			t.Fatalf("creating message: %v", err)
+		}
+
+		for _, participant := range participants {
+			err := getQueries().createMessageUserMap(ctx, createMessageUserMapParams{
+				MessageID:  ids[i],
+				ReceiverID: participant.UserID,
+			})
+			if err != nil {
+				t.Fatalf("creating message user map: %v", err)
+			}
+		}
+	}
+	return ids
+}
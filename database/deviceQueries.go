@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"g_chat/models"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DeviceQueries struct {
+	*Queries
+}
+
+func GetDeviceQueries() *DeviceQueries {
+	queries := getQueries()
+	return &DeviceQueries{queries}
+}
+
+// Registers a new device for the user or updates name/platform of an existing one. A device id owned
+// by another user or already revoked cannot be registered again.
+func (db *DeviceQueries) RegisterDevice(ctx context.Context, userId string, newDevice models.NewDevice) (Device, error) {
+	deviceId := newDevice.ID
+	if deviceId == "" {
+		deviceId = uuid.NewString()
+	}
+
+	device, err := db.Queries.registerDevice(ctx, registerDeviceParams{
+		ID:        deviceId,
+		UserID:    userId,
+		Name:      newDevice.Name,
+		Platform:  newDevice.Platform,
+		CreatedAt: time.Now().UnixNano(),
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("device id already taken or revoked : f(RegisterDevice)")
+		return Device{}, errors.New("device id not available")
+	}
+
+	if err != nil {
+		log.Printf("DB error : error registering device : f(RegisterDevice) : error : %v", err)
+		return Device{}, err
+	}
+
+	return device, nil
+}
+
+// Gets all devices of the user which are not revoked
+func (db *DeviceQueries) GetDevicesOfUser(ctx context.Context, userId string) ([]Device, error) {
+	devices, err := db.Queries.getDevicesOfUser(ctx, userId)
+	if err != nil {
+		log.Printf("DB error : error getting devices : f(GetDevicesOfUser) : error : %v", err)
+		return nil, err
+	}
+	return devices, nil
+}
+
+// Updates last seen of the device, returns false if the device does not belong to the user or is revoked
+func (db *DeviceQueries) TouchDevice(ctx context.Context, userId string, deviceId string) (bool, error) {
+	rows, err := db.Queries.touchDevice(ctx, touchDeviceParams{
+		ID:         deviceId,
+		UserID:     userId,
+		LastSeenAt: time.Now().UnixNano(),
+	})
+
+	if err != nil {
+		log.Printf("DB error : error updating device last seen : f(TouchDevice) : error : %v", err)
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// Revokes a device of the user, returns false if no such active device exists
+func (db *DeviceQueries) RevokeDevice(ctx context.Context, userId string, deviceId string) (bool, error) {
+	rows, err := db.Queries.revokeDevice(ctx, revokeDeviceParams{
+		ID:     deviceId,
+		UserID: userId,
+		RevokedAt: sql.NullInt64{
+			Int64: time.Now().UnixNano(),
+			Valid: true,
+		},
+	})
+
+	if err != nil {
+		log.Printf("DB error : error revoking device : f(RevokeDevice) : error : %v", err)
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// Records delivery of messages to a single device of the receiver, messages the user is not a receiver of
+// are ignored. Returns the number of newly recorded deliveries
+func (db *DeviceQueries) MarkMessagesAsDeliveredToDevice(ctx context.Context, messageIds []string, userId string, deviceId string) (int64, error) {
+	rows, err := db.Queries.markMessagesAsDeliveredToDevice(ctx, markMessagesAsDeliveredToDeviceParams{
+		Column1:     messageIds,
+		DeviceID:    deviceId,
+		DeliveredAt: time.Now().UnixNano(),
+		ReceiverID:  userId,
+	})
+
+	if err != nil {
+		log.Printf("DB error : error marking messages delivered to device : f(MarkMessagesAsDeliveredToDevice) : error : %v", err)
+		return 0, err
+	}
+
+	return rows, nil
+}
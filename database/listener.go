@@ -0,0 +1,379 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	ws "g_chat/wsConnections"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	LISTENER_MIN_BACKOFF      = time.Second
+	LISTENER_MAX_BACKOFF      = 30 * time.Second
+	LISTENER_PING_INTERVAL    = 30 * time.Second
+	LISTENER_REPLAY_MARGIN    = 5 * time.Second
+	LISTENER_REPLAY_PAGE_SIZE = 1000
+	LISTENER_BATCH_WINDOW     = 10 * time.Millisecond
+	// how often the last alive time is written to ListenerState
+	LISTENER_STATE_INTERVAL = 5 * time.Second
+)
+
+type ListenerStatus string
+
+const (
+	ListenerStarting     ListenerStatus = "starting"
+	ListenerHealthy      ListenerStatus = "healthy"
+	ListenerReconnecting ListenerStatus = "reconnecting"
+	ListenerStandby      ListenerStatus = "standby" // cluster mode, another node holds the listener lock
+)
+
+type ListenerHealth struct {
+	Status             ListenerStatus `json:"status"`
+	Channels           []string       `json:"channels"`
+	ConnectedSince     int64          `json:"connected_since"`
+	LastNotificationAt int64          `json:"last_notification_at"`
+	LastAliveAt        int64          `json:"last_alive_at"`
+	Reconnects         uint           `json:"reconnects"`
+	LastError          string         `json:"last_error,omitempty"`
+}
+
+var (
+	listenerHealth = ListenerHealth{Status: ListenerStarting}
+	healthLock     sync.RWMutex
+)
+
+// GetListenerHealth returns a snapshot of the notification listener state
+func GetListenerHealth() ListenerHealth {
+	healthLock.RLock()
+	defer healthLock.RUnlock()
+
+	health := listenerHealth
+	health.Channels = append([]string(nil), listenerHealth.Channels...)
+	return health
+}
+
+func updateListenerHealth(update func(health *ListenerHealth)) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	update(&listenerHealth)
+}
+
+// superviseListener keeps a listener running, reconnecting with backoff on any error. Whenever a listener
+// starts, the changes written since any instance's listener was last alive are replayed through the same handlers.
+func superviseListener(ctx context.Context, connectionManager *ws.ConnectionManager) {
+	backoff := LISTENER_MIN_BACKOFF
+
+	for {
+		connectedAt := time.Now()
+		err := listen(ctx, connectionManager)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("notification listener stopped, reconnecting in %v : error - %v", backoff, err)
+		updateListenerHealth(func(health *ListenerHealth) {
+			health.Status = ListenerReconnecting
+			health.Reconnects++
+			if err != nil {
+				health.LastError = err.Error()
+			}
+		})
+
+		// a connection which stayed up for a while resets the backoff
+		if time.Since(connectedAt) > LISTENER_MAX_BACKOFF {
+			backoff = LISTENER_MIN_BACKOFF
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, LISTENER_MAX_BACKOFF)
+	}
+}
+
+// listen runs a single listener connection until it fails
+func listen(ctx context.Context, connectionManager *ws.ConnectionManager) error {
+	// get a connection for notification
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer releaseListenConn(conn)
+
+	// in cluster mode a single instance consumes notifications and forwards events to the owning instances
+	if connectionManager.IsClusterEnabled() {
+		updateListenerHealth(func(health *ListenerHealth) {
+			health.Status = ListenerStandby
+		})
+
+		if err := waitForListenerLock(ctx, conn.Conn()); err != nil {
+			return err
+		}
+		log.Printf("node %v acquired the notification listener lock", connectionManager.NodeId)
+	}
+
+	channels := notificationRegistry.Channels()
+	if len(channels) == 0 {
+		return errors.New("no notification handlers registered")
+	}
+
+	for _, channel := range channels {
+		if _, err := conn.Exec(ctx, "listen "+channel); err != nil {
+			return err
+		}
+	}
+
+	connectedSince := time.Now()
+	updateListenerHealth(func(health *ListenerHealth) {
+		health.Status = ListenerHealthy
+		health.Channels = channels
+		health.ConnectedSince = connectedSince.UnixNano()
+		health.LastError = ""
+	})
+
+	// LISTEN is in place, now catch up on whatever was written while no listener was running. The shared
+	// last alive time only moves once the replay is done, a failed replay is retried on reconnect
+	if gapStart := replayStart(ctx); !gapStart.IsZero() {
+		if err := replayMissedNotifications(ctx, gapStart); err != nil {
+			return err
+		}
+	}
+	markListenerAlive(ctx, connectedSince)
+
+	// handle notifications
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, LISTENER_PING_INTERVAL)
+		notification, err := conn.Conn().WaitForNotification(waitCtx)
+		cancel()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// no notification within the interval, make sure the connection is still alive
+			if errors.Is(err, context.DeadlineExceeded) {
+				pingedAt := time.Now()
+				if err := conn.Ping(ctx); err != nil {
+					return err
+				}
+				markListenerAlive(ctx, pingedAt)
+				continue
+			}
+			return err
+		}
+
+		receivedAt := time.Now()
+		updateListenerHealth(func(health *ListenerHealth) {
+			health.LastNotificationAt = receivedAt.UnixNano()
+		})
+
+		batch, err := collectBatch(ctx, conn.Conn(), rawNotification{
+			channel: notification.Channel,
+			payload: notification.Payload,
+		})
+
+		// notifications already received are delivered even when the listener is being stopped
+		dispatchNotifications(context.WithoutCancel(ctx), batch)
+
+		if err != nil {
+			return err
+		}
+		markListenerAlive(ctx, receivedAt)
+	}
+}
+
+// collectBatch keeps reading notifications which arrive within the batch window so rows are hydrated together
+func collectBatch(ctx context.Context, conn *pgx.Conn, first rawNotification) ([]rawNotification, error) {
+	batch := []rawNotification{first}
+
+	for len(batch) < NOTIFICATION_BATCH_SIZE {
+		waitCtx, cancel := context.WithTimeout(ctx, LISTENER_BATCH_WINDOW)
+		notification, err := conn.WaitForNotification(waitCtx)
+		cancel()
+
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				return batch, nil
+			}
+			return batch, err
+		}
+
+		batch = append(batch, rawNotification{
+			channel: notification.Channel,
+			payload: notification.Payload,
+		})
+	}
+
+	return batch, nil
+}
+
+// only written by the listener goroutine, see markListenerAlive
+var lastPersistedAliveAt time.Time
+
+// markListenerAlive records that every notification sent before at was handled. It is written to
+// ListenerState at most every LISTENER_STATE_INTERVAL so other instances know where to replay from.
+func markListenerAlive(ctx context.Context, at time.Time) {
+	updateListenerHealth(func(health *ListenerHealth) {
+		health.LastAliveAt = at.UnixNano()
+	})
+
+	if at.Sub(lastPersistedAliveAt) < LISTENER_STATE_INTERVAL {
+		return
+	}
+
+	if err := getQueries().updateListenerLastAliveAt(ctx, at.UnixNano()); err != nil {
+		log.Printf("DB error : storing listener last alive time failed : f(markListenerAlive) : error -> %v", err)
+		return
+	}
+	lastPersistedAliveAt = at
+}
+
+// replayStart is the time from which a listener which just started has to replay, zero if no listener
+// ever ran. It comes from ListenerState so an instance taking over the listener lock replays the gap of
+// the instance which held it before.
+func replayStart(ctx context.Context) time.Time {
+	lastAliveAt, err := getQueries().getListenerLastAliveAt(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}
+	}
+
+	if err != nil {
+		log.Printf("DB error : reading listener last alive time failed : f(replayStart) : error -> %v", err)
+		// fall back to what this instance knows
+		lastAliveAt = GetListenerHealth().LastAliveAt
+	}
+
+	if lastAliveAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, lastAliveAt).Add(-LISTENER_REPLAY_MARGIN)
+}
+
+// replayPosition is the sort key of the last replayed row, pages continue after it
+type replayPosition struct {
+	time       int64
+	id         string
+	receiverId string
+}
+
+type channelReplay struct {
+	channel string
+	// returns the payloads of the next page and the position of its last row
+	page func(ctx context.Context, after replayPosition) ([]string, replayPosition, error)
+}
+
+// messages must reach clients before their receipts, chat_written is replayed first
+var channelReplays = []channelReplay{
+	{
+		channel: "chat_written",
+		page: func(ctx context.Context, after replayPosition) ([]string, replayPosition, error) {
+			rows, err := getQueries().getMessageUserMapChangesAfter(ctx, getMessageUserMapChangesAfterParams{
+				AfterTime:       after.time,
+				AfterMessageID:  after.id,
+				AfterReceiverID: after.receiverId,
+				Limit:           LISTENER_REPLAY_PAGE_SIZE,
+			})
+			payloads := make([]string, len(rows))
+			for i, row := range rows {
+				payloads[i] = row.Payload
+				after = replayPosition{time: row.CreatedAt, id: row.MessageID, receiverId: row.ReceiverID}
+			}
+			return payloads, after, err
+		},
+	},
+	{
+		channel: "chat_received",
+		page: func(ctx context.Context, after replayPosition) ([]string, replayPosition, error) {
+			rows, err := getQueries().getDeliveredMessageChangesAfter(ctx, getDeliveredMessageChangesAfterParams{
+				AfterTime: after.time,
+				AfterID:   after.id,
+				Limit:     LISTENER_REPLAY_PAGE_SIZE,
+			})
+			payloads := make([]string, len(rows))
+			for i, row := range rows {
+				payloads[i] = row.Payload
+				after = replayPosition{time: row.ReceiptUpdatedAt, id: row.ID}
+			}
+			return payloads, after, err
+		},
+	},
+	{
+		channel: "chat_read",
+		page: func(ctx context.Context, after replayPosition) ([]string, replayPosition, error) {
+			rows, err := getQueries().getSeenMessageChangesAfter(ctx, getSeenMessageChangesAfterParams{
+				AfterTime: after.time,
+				AfterID:   after.id,
+				Limit:     LISTENER_REPLAY_PAGE_SIZE,
+			})
+			payloads := make([]string, len(rows))
+			for i, row := range rows {
+				payloads[i] = row.Payload
+				after = replayPosition{time: row.ReceiptUpdatedAt, id: row.ID}
+			}
+			return payloads, after, err
+		},
+	},
+}
+
+// replayMissedNotifications rebuilds the notification payloads written after gapStart and dispatches
+// them page by page until every registered channel is caught up
+func replayMissedNotifications(ctx context.Context, gapStart time.Time) error {
+	log.Printf("replaying notifications missed since %v", gapStart)
+
+	channels := notificationRegistry.Channels()
+	for _, replay := range channelReplays {
+		if !slices.Contains(channels, replay.channel) {
+			continue
+		}
+
+		after := replayPosition{time: gapStart.UnixNano()}
+		for {
+			payloads, last, err := replay.page(ctx, after)
+			if err != nil {
+				log.Printf("DB error : replaying %v failed : f(replayMissedNotifications) : error -> %v", replay.channel, err)
+				return err
+			}
+
+			for batchStart := 0; batchStart < len(payloads); batchStart += NOTIFICATION_BATCH_SIZE {
+				batchPayloads := payloads[batchStart:min(batchStart+NOTIFICATION_BATCH_SIZE, len(payloads))]
+				batch := make([]rawNotification, len(batchPayloads))
+				for i, payload := range batchPayloads {
+					batch[i] = rawNotification{
+						channel: replay.channel,
+						payload: payload,
+					}
+				}
+				dispatchNotifications(ctx, batch)
+			}
+
+			if len(payloads) < LISTENER_REPLAY_PAGE_SIZE {
+				break
+			}
+			after = last
+		}
+	}
+
+	return nil
+}
+
+// releaseListenConn closes the connection instead of returning it to the pool so LISTEN
+// registrations and advisory locks do not leak to other users of the pool
+func releaseListenConn(conn *pgxpool.Conn) {
+	if err := conn.Conn().Close(context.Background()); err != nil {
+		log.Printf("error closing listener connection %v", err)
+	}
+	conn.Release()
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordReplays registers handlers for the replayed channels which record every notification in order
+func recordReplays(t *testing.T) func() []Notification {
+	t.Helper()
+	var lock sync.Mutex
+	var received []Notification
+
+	for _, replay := range channelReplays {
+		GetNotificationRegistry().Register(replay.channel, func(notifications []Notification) error {
+			lock.Lock()
+			defer lock.Unlock()
+			received = append(received, notifications...)
+			return nil
+		})
+	}
+
+	return func() []Notification {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]Notification(nil), received...)
+	}
+}
+
+func TestReplayPagesPastPageSize(t *testing.T) {
+	setupTestDatabase(t)
+	received := recordReplays(t)
+
+	createTestConversation(t, "conversation", "sender", "receiver-a", "receiver-b")
+
+	// every message shares one created_at so pages have to continue by id
+	createdAt := time.Now().UnixNano()
+	ids := createTestMessages(t, "conversation", "sender", createdAt, LISTENER_REPLAY_PAGE_SIZE/3+10)
+
+	if err := replayMissedNotifications(context.Background(), time.Unix(0, createdAt-1)); err != nil {
+		t.Fatalf("replaying: %v", err)
+	}
+
+	replayed := make(map[[2]string]int)
+	for _, notification := range received() {
+		if notification.Channel != "chat_written" {
+			continue
+		}
+		replayed[[2]string{notification.Envelope.Ids["message_id"], notification.Envelope.Ids["receiver_id"]}]++
+	}
+
+	for _, id := range ids {
+		for _, receiver := range []string{"sender", "receiver-a", "receiver-b"} {
+			if count := replayed[[2]string{id, receiver}]; count != 1 {
+				t.Fatalf("message %v for %v replayed %v times", id, receiver, count)
+			}
+		}
+	}
+}
+
+func TestReplayMessagesBeforeReceipts(t *testing.T) {
+	setupTestDatabase(t)
+	received := recordReplays(t)
+	ctx := context.Background()
+
+	createTestConversation(t, "conversation", "sender", "receiver")
+	gapStart := time.Now()
+	ids := createTestMessages(t, "conversation", "sender", gapStart.UnixNano()+1, 3)
+
+	if err := GetChatQueries().UpdateLastMessageSeenInConversationForUser(ctx, "conversation", "receiver", gapStart.UnixNano()+1); err != nil {
+		t.Fatalf("reading conversation: %v", err)
+	}
+
+	if err := replayMissedNotifications(ctx, gapStart); err != nil {
+		t.Fatalf("replaying: %v", err)
+	}
+
+	notifications := received()
+	lastWritten, firstRead, reads := -1, -1, 0
+	for i, notification := range notifications {
+		switch notification.Channel {
+		case "chat_written":
+			lastWritten = i
+		case "chat_read":
+			reads++
+			if firstRead < 0 {
+				firstRead = i
+			}
+		}
+	}
+
+	if reads != len(ids) {
+		t.Fatalf("expected %v read notifications, got %v", len(ids), reads)
+	}
+	if firstRead < lastWritten {
+		t.Fatalf("read receipt replayed at %v before message at %v", firstRead, lastWritten)
+	}
+}
+
+func TestReplayStartIsShared(t *testing.T) {
+	setupTestDatabase(t)
+	ctx := context.Background()
+
+	if start := replayStart(ctx); !start.IsZero() {
+		t.Fatalf("expected no replay before any listener ran, got %v", start)
+	}
+
+	// what one instance records is what another instance replays from
+	aliveAt := time.Now()
+	lastPersistedAliveAt = time.Time{}
+	markListenerAlive(ctx, aliveAt)
+
+	if start := replayStart(ctx); !start.Equal(aliveAt.Add(-LISTENER_REPLAY_MARGIN)) {
+		t.Fatalf("expected replay from %v, got %v", aliveAt.Add(-LISTENER_REPLAY_MARGIN), start)
+	}
+
+	// an instance with an older view never moves the shared time back
+	lastPersistedAliveAt = time.Time{}
+	markListenerAlive(ctx, aliveAt.Add(-time.Minute))
+
+	if start := replayStart(ctx); !start.Equal(aliveAt.Add(-LISTENER_REPLAY_MARGIN)) {
+		t.Fatalf("shared last alive time moved back to %v", start)
+	}
+}
@@ -49,6 +49,12 @@ type Calendareventrequest struct {
 	UpdatedAt        sql.NullInt64
 }
 
+type Clustermessage struct {
+	ID        string
+	Payload   string
+	CreatedAt int64
+}
+
 type Conversation struct {
 	ID            string
 	IsGroup       bool
@@ -71,6 +77,16 @@ type Conversationparticipant struct {
 	DeletedAt         sql.NullInt64
 }
 
+type Device struct {
+	ID         string
+	UserID     string
+	Name       string
+	Platform   string
+	CreatedAt  int64
+	LastSeenAt int64
+	RevokedAt  sql.NullInt64
+}
+
 type Follow struct {
 	FollowerID string
 	FollowedID string
@@ -83,21 +99,36 @@ type Friend struct {
 	CreatedAt int64
 }
 
+type Listenerstate struct {
+	ID          int32
+	LastAliveAt int64
+}
+
 type Message struct {
-	ID             string
-	Body           string
-	ConversationID string
-	SenderID       string
-	DeliveredCount int32
-	SeenCount      int32
-	SentToCount    int32
-	SentAt         int64
-	CreatedAt      int64
+	ID               string
+	Body             string
+	ConversationID   string
+	SenderID         string
+	DeliveredCount   int32
+	SeenCount        int32
+	SentToCount      int32
+	SentAt           int64
+	CreatedAt        int64
+	ReceiptUpdatedAt int64
+}
+
+type Messagedevicedelivery struct {
+	MessageID   string
+	DeviceID    string
+	DeliveredAt int64
 }
 
 type Messageusermap struct {
-	MessageID  string
-	ReceiverID string
+	MessageID     string
+	ReceiverID    string
+	UndeliveredAt sql.NullInt64
+	DeliveredAt   sql.NullInt64
+	SeenAt        sql.NullInt64
 }
 
 type Socialrequest struct {
@@ -122,3 +153,27 @@ type User struct {
 	UpdatedAt   sql.NullInt64
 	DeletedAt   sql.NullInt64
 }
+
+type Usersetting struct {
+	UserID              string
+	ReadReceiptsEnabled bool
+	UpdatedAt           int64
+}
+
+type Wspresence struct {
+	UserID      string
+	NodeID      string
+	ConnectedAt int64
+}
+
+type Wsticket struct {
+	Ticket         string
+	UserID         string
+	DeviceID       string
+	ClientIp       string
+	UserAgent      string
+	TokenIssuedAt  int64
+	TokenExpiresAt int64
+	CreatedAt      int64
+	ExpiresAt      int64
+}
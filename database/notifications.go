@@ -0,0 +1,186 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+)
+
+const (
+	NOTIFICATION_ENVELOPE_VERSION = 1
+	NOTIFICATION_BATCH_SIZE       = 100
+)
+
+// NotificationEnvelope is the pg_notify payload sent by triggers, it only identifies the changed row
+type NotificationEnvelope struct {
+	Table   string            `json:"table"`
+	Op      string            `json:"op"`
+	Ids     map[string]string `json:"ids"`
+	Version int               `json:"version"`
+}
+
+// Notification is an envelope along with the row it points to. Message is hydrated for the
+// messages and messageusermap tables.
+type Notification struct {
+	Channel  string
+	Envelope NotificationEnvelope
+	Message  Message
+}
+
+type NotificationHandler func(notifications []Notification) error
+
+// NotificationRegistry maps pg_notify channels to the handler receiving their hydrated notifications
+type NotificationRegistry struct {
+	handlers map[string]NotificationHandler
+	sync.RWMutex
+}
+
+var notificationRegistry = &NotificationRegistry{
+	handlers: make(map[string]NotificationHandler),
+}
+
+func GetNotificationRegistry() *NotificationRegistry {
+	return notificationRegistry
+}
+
+// Register adds a handler for a channel, the listener issues LISTEN for every registered channel
+func (registry *NotificationRegistry) Register(channel string, handler NotificationHandler) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.handlers[channel] = handler
+}
+
+func (registry *NotificationRegistry) Channels() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	channels := make([]string, 0, len(registry.handlers))
+	for channel := range registry.handlers {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func (registry *NotificationRegistry) handler(channel string) (NotificationHandler, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	handler, ok := registry.handlers[channel]
+	return handler, ok
+}
+
+// rowMessageId returns the id of the message a notification points to
+func rowMessageId(envelope NotificationEnvelope) (string, bool) {
+	switch envelope.Table {
+	case "messages":
+		id, ok := envelope.Ids["id"]
+		return id, ok
+	case "messageusermap":
+		id, ok := envelope.Ids["message_id"]
+		return id, ok
+	}
+	return "", false
+}
+
+// hydrateNotifications reads every message referenced by the batch with a single query, notifications
+// whose row no longer exists are dropped
+func hydrateNotifications(ctx context.Context, notifications []Notification) ([]Notification, error) {
+	var messageIds []string
+	seen := make(map[string]bool)
+	for _, notification := range notifications {
+		if id, ok := rowMessageId(notification.Envelope); ok && !seen[id] {
+			seen[id] = true
+			messageIds = append(messageIds, id)
+		}
+	}
+
+	if len(messageIds) == 0 {
+		return notifications, nil
+	}
+
+	messages, err := getQueries().getMessagesByIds(ctx, messageIds)
+	if err != nil {
+		return nil, err
+	}
+
+	messageMap := make(map[string]Message, len(messages))
+	for _, message := range messages {
+		messageMap[message.ID] = message
+	}
+
+	hydrated := make([]Notification, 0, len(notifications))
+	for _, notification := range notifications {
+		id, ok := rowMessageId(notification.Envelope)
+		if !ok {
+			hydrated = append(hydrated, notification)
+			continue
+		}
+
+		message, ok := messageMap[id]
+		if !ok {
+			log.Printf("message %v of %v notification no longer exists", id, notification.Channel)
+			continue
+		}
+		notification.Message = message
+		hydrated = append(hydrated, notification)
+	}
+
+	return hydrated, nil
+}
+
+// dispatchNotifications parses, hydrates and hands a batch of raw notifications to the registered
+// handlers, every handler is called once per batch with its notifications in arrival order
+func dispatchNotifications(ctx context.Context, rawNotifications []rawNotification) {
+	var notifications []Notification
+	for _, raw := range rawNotifications {
+		var envelope NotificationEnvelope
+		if err := json.Unmarshal([]byte(raw.payload), &envelope); err != nil {
+			log.Printf("error unmarshalling %v notification envelope %v : error - %v", raw.channel, raw.payload, err)
+			continue
+		}
+
+		if envelope.Version != NOTIFICATION_ENVELOPE_VERSION {
+			log.Printf("unsupported %v notification envelope version %v", raw.channel, envelope.Version)
+			continue
+		}
+
+		notifications = append(notifications, Notification{
+			Channel:  raw.channel,
+			Envelope: envelope,
+		})
+	}
+
+	notifications, err := hydrateNotifications(ctx, notifications)
+	if err != nil {
+		log.Printf("DB error : hydrating notifications failed : f(dispatchNotifications) : error -> %v", err)
+		return
+	}
+
+	var channels []string
+	byChannel := make(map[string][]Notification)
+	for _, notification := range notifications {
+		if _, ok := byChannel[notification.Channel]; !ok {
+			channels = append(channels, notification.Channel)
+		}
+		byChannel[notification.Channel] = append(byChannel[notification.Channel], notification)
+	}
+
+	for _, channel := range channels {
+		handler, ok := notificationRegistry.handler(channel)
+		if !ok {
+			log.Printf("channel not registered %v", channel)
+			continue
+		}
+
+		if err := handler(byChannel[channel]); err != nil {
+			log.Printf("error handling %v notifications : error - %v", channel, err)
+		}
+	}
+}
+
+type rawNotification struct {
+	channel string
+	payload string
+}
@@ -36,6 +36,60 @@ func (q *Queries) activeSocialRequestExists(ctx context.Context, arg activeSocia
 	return exists, err
 }
 
+const clearPresenceForNode = `-- name: clearPresenceForNode :exec
+DELETE FROM WSPresence
+WHERE node_id = $1
+`
+
+func (q *Queries) clearPresenceForNode(ctx context.Context, nodeID string) error {
+	_, err := q.db.ExecContext(ctx, clearPresenceForNode, nodeID)
+	return err
+}
+
+const consumeWSTicket = `-- name: consumeWSTicket :one
+DELETE FROM WSTickets
+WHERE ticket = $1 AND expires_at > $2
+RETURNING ticket, user_id, device_id, client_ip, user_agent, token_issued_at, token_expires_at, created_at, expires_at
+`
+
+type consumeWSTicketParams struct {
+	Ticket    string
+	ExpiresAt int64
+}
+
+func (q *Queries) consumeWSTicket(ctx context.Context, arg consumeWSTicketParams) (Wsticket, error) {
+	row := q.db.QueryRowContext(ctx, consumeWSTicket, arg.Ticket, arg.ExpiresAt)
+	var i Wsticket
+	err := row.Scan(
+		&i.Ticket,
+		&i.UserID,
+		&i.DeviceID,
+		&i.ClientIp,
+		&i.UserAgent,
+		&i.TokenIssuedAt,
+		&i.TokenExpiresAt,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const createClusterMessage = `-- name: createClusterMessage :exec
+INSERT INTO ClusterMessages (id, payload, created_at)
+VALUES ($1, $2, $3)
+`
+
+type createClusterMessageParams struct {
+	ID        string
+	Payload   string
+	CreatedAt int64
+}
+
+func (q *Queries) createClusterMessage(ctx context.Context, arg createClusterMessageParams) error {
+	_, err := q.db.ExecContext(ctx, createClusterMessage, arg.ID, arg.Payload, arg.CreatedAt)
+	return err
+}
+
 const createConversation = `-- name: createConversation :one
 INSERT INTO Conversations (id, is_group, owner_id, name, description, image_url, created_at)
 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, is_group, owner_id, name, description, image_url, created_at, updated_at, deleted_at, last_message_at
@@ -103,19 +157,20 @@ func (q *Queries) createConversationParticipant(ctx context.Context, arg createC
 
 const createMessage = `-- name: createMessage :one
 INSERT INTO Messages (id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at, receipt_updated_at
 `
 
 type createMessageParams struct {
-	ID             string
-	Body           string
-	ConversationID string
-	SenderID       string
-	DeliveredCount int32
-	SeenCount      int32
-	SentToCount    int32
-	SentAt         int64
-	CreatedAt      int64
+	ID               string
+	Body             string
+	ConversationID   string
+	SenderID         string
+	DeliveredCount   int32
+	SeenCount        int32
+	SentToCount      int32
+	SentAt           int64
+	CreatedAt        int64
+	ReceiptUpdatedAt int64
 }
 
 func (q *Queries) createMessage(ctx context.Context, arg createMessageParams) (Message, error) {
@@ -141,6 +196,7 @@ func (q *Queries) createMessage(ctx context.Context, arg createMessageParams) (M
 		&i.SentToCount,
 		&i.SentAt,
 		&i.CreatedAt,
+		&i.ReceiptUpdatedAt,
 	)
 	return i, err
 }
@@ -366,6 +422,38 @@ func (q *Queries) createUser(ctx context.Context, arg createUserParams) error {
 	return err
 }
 
+const createWSTicket = `-- name: createWSTicket :exec
+INSERT INTO WSTickets (ticket, user_id, device_id, client_ip, user_agent, token_issued_at, token_expires_at, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+
+type createWSTicketParams struct {
+	Ticket         string
+	UserID         string
+	DeviceID       string
+	ClientIp       string
+	UserAgent      string
+	TokenIssuedAt  int64
+	TokenExpiresAt int64
+	CreatedAt      int64
+	ExpiresAt      int64
+}
+
+func (q *Queries) createWSTicket(ctx context.Context, arg createWSTicketParams) error {
+	_, err := q.db.ExecContext(ctx, createWSTicket,
+		arg.Ticket,
+		arg.UserID,
+		arg.DeviceID,
+		arg.ClientIp,
+		arg.UserAgent,
+		arg.TokenIssuedAt,
+		arg.TokenExpiresAt,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const deleteCalendarInvite = `-- name: deleteCalendarInvite :exec
 DELETE FROM CalendarEventInvites
 WHERE id = $1
@@ -376,6 +464,26 @@ func (q *Queries) deleteCalendarInvite(ctx context.Context, id string) error {
 	return err
 }
 
+const deleteExpiredClusterMessages = `-- name: deleteExpiredClusterMessages :exec
+DELETE FROM ClusterMessages
+WHERE created_at < $1
+`
+
+func (q *Queries) deleteExpiredClusterMessages(ctx context.Context, createdAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredClusterMessages, createdAt)
+	return err
+}
+
+const deleteExpiredWSTickets = `-- name: deleteExpiredWSTickets :exec
+DELETE FROM WSTickets
+WHERE expires_at <= $1
+`
+
+func (q *Queries) deleteExpiredWSTickets(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredWSTickets, expiresAt)
+	return err
+}
+
 const fAreUsersFriends = `-- name: fAreUsersFriends :one
 SELECT EXISTS(
   SELECT 1
@@ -396,6 +504,29 @@ func (q *Queries) fAreUsersFriends(ctx context.Context, arg fAreUsersFriendsPara
 	return exists, err
 }
 
+const fIsGameVisibleToUser = `-- name: fIsGameVisibleToUser :one
+SELECT EXISTS (
+  SELECT 1
+  FROM CalendarEvents ce
+  LEFT JOIN CalendarEventParticipants cep ON cep.event_id = ce.id AND cep.user_id = $1
+  WHERE ce.game_id = $2
+    AND (ce.to_time > $3 OR ce.user_id = $1 OR cep.user_id IS NOT NULL)
+)
+`
+
+type fIsGameVisibleToUserParams struct {
+	UserID string
+	GameID string
+	ToTime int64
+}
+
+func (q *Queries) fIsGameVisibleToUser(ctx context.Context, arg fIsGameVisibleToUserParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, fIsGameVisibleToUser, arg.UserID, arg.GameID, arg.ToTime)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const fIsSocialRequestActive = `-- name: fIsSocialRequestActive :one
 SELECT EXISTS(
   SELECT 1
@@ -718,13 +849,13 @@ func (q *Queries) getAllCalendarRequestForEvent(ctx context.Context, arg getAllC
 
 const getAllMessagesAfterGivenTime = `-- name: getAllMessagesAfterGivenTime :many
 WITH ranked_messages AS (
-  SELECT m.id, m.body, m.conversation_id, m.sender_id, m.delivered_count, m.seen_count, m.sent_to_count, m.sent_at, m.created_at
+  SELECT m.id, m.body, m.conversation_id, m.sender_id, m.delivered_count, m.seen_count, m.sent_to_count, m.sent_at, m.created_at, m.receipt_updated_at
   FROM Messages m
   INNER JOIN ConversationParticipants cp ON m.conversation_id = cp.conversation_id
   WHERE cp.user_id = $1 AND m.created_at > $2
   ORDER BY m.created_at ASC
 )
-SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at
+SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at, receipt_updated_at
 FROM ranked_messages
 LIMIT $3
 `
@@ -736,15 +867,16 @@ type getAllMessagesAfterGivenTimeParams struct {
 }
 
 type getAllMessagesAfterGivenTimeRow struct {
-	ID             string
-	Body           string
-	ConversationID string
-	SenderID       string
-	DeliveredCount int32
-	SeenCount      int32
-	SentToCount    int32
-	SentAt         int64
-	CreatedAt      int64
+	ID               string
+	Body             string
+	ConversationID   string
+	SenderID         string
+	DeliveredCount   int32
+	SeenCount        int32
+	SentToCount      int32
+	SentAt           int64
+	CreatedAt        int64
+	ReceiptUpdatedAt int64
 }
 
 func (q *Queries) getAllMessagesAfterGivenTime(ctx context.Context, arg getAllMessagesAfterGivenTimeParams) ([]getAllMessagesAfterGivenTimeRow, error) {
@@ -766,6 +898,7 @@ func (q *Queries) getAllMessagesAfterGivenTime(ctx context.Context, arg getAllMe
 			&i.SentToCount,
 			&i.SentAt,
 			&i.CreatedAt,
+			&i.ReceiptUpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -782,12 +915,12 @@ func (q *Queries) getAllMessagesAfterGivenTime(ctx context.Context, arg getAllMe
 
 const getAllMessagesForConversation = `-- name: getAllMessagesForConversation :many
 WITH ranked_messages AS (
-  SELECT m.id, m.body, m.conversation_id, m.sender_id, m.delivered_count, m.seen_count, m.sent_to_count, m.sent_at, m.created_at
+  SELECT m.id, m.body, m.conversation_id, m.sender_id, m.delivered_count, m.seen_count, m.sent_to_count, m.sent_at, m.created_at, m.receipt_updated_at
   FROM Messages m
   WHERE m.conversation_id = $1 AND m.created_at > $2
   ORDER BY m.created_at ASC
 )
-SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at
+SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at, receipt_updated_at
 FROM ranked_messages
 LIMIT $3
 `
@@ -799,15 +932,16 @@ type getAllMessagesForConversationParams struct {
 }
 
 type getAllMessagesForConversationRow struct {
-	ID             string
-	Body           string
-	ConversationID string
-	SenderID       string
-	DeliveredCount int32
-	SeenCount      int32
-	SentToCount    int32
-	SentAt         int64
-	CreatedAt      int64
+	ID               string
+	Body             string
+	ConversationID   string
+	SenderID         string
+	DeliveredCount   int32
+	SeenCount        int32
+	SentToCount      int32
+	SentAt           int64
+	CreatedAt        int64
+	ReceiptUpdatedAt int64
 }
 
 func (q *Queries) getAllMessagesForConversation(ctx context.Context, arg getAllMessagesForConversationParams) ([]getAllMessagesForConversationRow, error) {
@@ -829,6 +963,7 @@ func (q *Queries) getAllMessagesForConversation(ctx context.Context, arg getAllM
 			&i.SentToCount,
 			&i.SentAt,
 			&i.CreatedAt,
+			&i.ReceiptUpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -949,6 +1084,124 @@ func (q *Queries) getCalendarEventForId(ctx context.Context, id string) (Calenda
 	return i, err
 }
 
+const getCalendarInviteChangesForUser = `-- name: getCalendarInviteChangesForUser :many
+SELECT id, event_id, invited_user_id, invite_message, invite_status, created_at, updated_at
+FROM CalendarEventInvites
+WHERE invited_user_id = $1
+  AND (COALESCE(updated_at, created_at), id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY COALESCE(updated_at, created_at) ASC, id ASC
+LIMIT $4
+`
+
+type getCalendarInviteChangesForUserParams struct {
+	InvitedUserID string
+	AfterTime     int64
+	AfterID       string
+	Limit         int32
+}
+
+func (q *Queries) getCalendarInviteChangesForUser(ctx context.Context, arg getCalendarInviteChangesForUserParams) ([]Calendareventinvite, error) {
+	rows, err := q.db.QueryContext(ctx, getCalendarInviteChangesForUser,
+		arg.InvitedUserID,
+		arg.AfterTime,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Calendareventinvite
+	for rows.Next() {
+		var i Calendareventinvite
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventID,
+			&i.InvitedUserID,
+			&i.InviteMessage,
+			&i.InviteStatus,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCalendarRequestChangesForUser = `-- name: getCalendarRequestChangesForUser :many
+SELECT cer.id, cer.event_id, cer.requesting_user_id, cer.request_message, cer.request_status, cer.created_at, cer.updated_at
+FROM CalendarEventRequests cer
+WHERE (cer.requesting_user_id = $1 OR cer.event_id IN (
+    SELECT ce.id FROM CalendarEvents ce WHERE ce.user_id = $1
+  ))
+  AND (COALESCE(cer.updated_at, cer.created_at), cer.id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY COALESCE(cer.updated_at, cer.created_at) ASC, cer.id ASC
+LIMIT $4
+`
+
+type getCalendarRequestChangesForUserParams struct {
+	RequestingUserID string
+	AfterTime        int64
+	AfterID          string
+	Limit            int32
+}
+
+func (q *Queries) getCalendarRequestChangesForUser(ctx context.Context, arg getCalendarRequestChangesForUserParams) ([]Calendareventrequest, error) {
+	rows, err := q.db.QueryContext(ctx, getCalendarRequestChangesForUser,
+		arg.RequestingUserID,
+		arg.AfterTime,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Calendareventrequest
+	for rows.Next() {
+		var i Calendareventrequest
+		if err := rows.Scan(
+			&i.ID,
+			&i.EventID,
+			&i.RequestingUserID,
+			&i.RequestMessage,
+			&i.RequestStatus,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getClusterMessage = `-- name: getClusterMessage :one
+SELECT payload FROM ClusterMessages
+WHERE id = $1
+`
+
+func (q *Queries) getClusterMessage(ctx context.Context, id string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getClusterMessage, id)
+	var payload string
+	err := row.Scan(&payload)
+	return payload, err
+}
+
 const getConversationByID = `-- name: getConversationByID :one
 SELECT id, is_group, owner_id, name, description, image_url, created_at, updated_at, deleted_at, last_message_at FROM Conversations WHERE id = $1
 `
@@ -971,6 +1224,91 @@ func (q *Queries) getConversationByID(ctx context.Context, id string) (Conversat
 	return i, err
 }
 
+const getDeliveredMessageChangesAfter = `-- name: getDeliveredMessageChangesAfter :many
+SELECT json_build_object(
+  'table', 'messages',
+  'op', 'UPDATE',
+  'ids', json_build_object('id', m.id),
+  'version', 1
+)::text AS payload, m.receipt_updated_at, m.id
+FROM Messages m
+WHERE (m.receipt_updated_at, m.id) > ($1::BIGINT, $2::VARCHAR) AND m.delivered_count = m.sent_to_count
+ORDER BY m.receipt_updated_at ASC, m.id ASC
+LIMIT $3
+`
+
+type getDeliveredMessageChangesAfterParams struct {
+	AfterTime int64
+	AfterID   string
+	Limit     int32
+}
+
+type getDeliveredMessageChangesAfterRow struct {
+	Payload          string
+	ReceiptUpdatedAt int64
+	ID               string
+}
+
+func (q *Queries) getDeliveredMessageChangesAfter(ctx context.Context, arg getDeliveredMessageChangesAfterParams) ([]getDeliveredMessageChangesAfterRow, error) {
+	rows, err := q.db.QueryContext(ctx, getDeliveredMessageChangesAfter, arg.AfterTime, arg.AfterID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []getDeliveredMessageChangesAfterRow
+	for rows.Next() {
+		var i getDeliveredMessageChangesAfterRow
+		if err := rows.Scan(&i.Payload, &i.ReceiptUpdatedAt, &i.ID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDevicesOfUser = `-- name: getDevicesOfUser :many
+SELECT id, user_id, name, platform, created_at, last_seen_at, revoked_at FROM Devices
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY last_seen_at DESC
+`
+
+func (q *Queries) getDevicesOfUser(ctx context.Context, userID string) ([]Device, error) {
+	rows, err := q.db.QueryContext(ctx, getDevicesOfUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Device
+	for rows.Next() {
+		var i Device
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Platform,
+			&i.CreatedAt,
+			&i.LastSeenAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getFollowersOfUser = `-- name: getFollowersOfUser :many
 SELECT u.id, u.name, u.image_url, f.created_at
 FROM Follows f
@@ -1089,8 +1427,20 @@ func (q *Queries) getLastMessageSeenTimeByUserInConversation(ctx context.Context
 	return last_message_seen_at, err
 }
 
+const getListenerLastAliveAt = `-- name: getListenerLastAliveAt :one
+SELECT last_alive_at FROM ListenerState
+WHERE id = 1
+`
+
+func (q *Queries) getListenerLastAliveAt(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getListenerLastAliveAt)
+	var last_alive_at int64
+	err := row.Scan(&last_alive_at)
+	return last_alive_at, err
+}
+
 const getMessageByID = `-- name: getMessageByID :one
-SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at FROM Messages WHERE id = $1
+SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at, receipt_updated_at FROM Messages WHERE id = $1
 `
 
 func (q *Queries) getMessageByID(ctx context.Context, id string) (Message, error) {
@@ -1106,41 +1456,142 @@ func (q *Queries) getMessageByID(ctx context.Context, id string) (Message, error
 		&i.SentToCount,
 		&i.SentAt,
 		&i.CreatedAt,
+		&i.ReceiptUpdatedAt,
 	)
 	return i, err
 }
 
-const getMostRecentConversationsForUser = `-- name: getMostRecentConversationsForUser :many
-SELECT c.id, c.is_group, c.owner_id, c.name, c.description, c.image_url, c.created_at, c.updated_at, c.deleted_at, c.last_message_at
-FROM Conversations c
-INNER JOIN ConversationParticipants cp ON c.id = cp.conversation_id
-WHERE cp.user_id = $1 AND c.last_message_at < $2
-ORDER BY c.last_message_at DESC
-LIMIT $3
+const getMessageUserMapChangesAfter = `-- name: getMessageUserMapChangesAfter :many
+SELECT json_build_object(
+  'table', 'messageusermap',
+  'op', 'INSERT',
+  'ids', json_build_object('message_id', mum.message_id, 'receiver_id', mum.receiver_id),
+  'version', 1
+)::text AS payload, m.created_at, mum.message_id, mum.receiver_id
+FROM MessageUserMap mum
+INNER JOIN Messages m ON m.id = mum.message_id
+WHERE (m.created_at, mum.message_id, mum.receiver_id) > ($1::BIGINT, $2::VARCHAR, $3::VARCHAR)
+ORDER BY m.created_at ASC, mum.message_id ASC, mum.receiver_id ASC
+LIMIT $4
 `
 
-type getMostRecentConversationsForUserParams struct {
-	UserID        string
-	LastMessageAt int64
-	Limit         int32
+type getMessageUserMapChangesAfterParams struct {
+	AfterTime       int64
+	AfterMessageID  string
+	AfterReceiverID string
+	Limit           int32
 }
 
-func (q *Queries) getMostRecentConversationsForUser(ctx context.Context, arg getMostRecentConversationsForUserParams) ([]Conversation, error) {
-	rows, err := q.db.QueryContext(ctx, getMostRecentConversationsForUser, arg.UserID, arg.LastMessageAt, arg.Limit)
+type getMessageUserMapChangesAfterRow struct {
+	Payload    string
+	CreatedAt  int64
+	MessageID  string
+	ReceiverID string
+}
+
+func (q *Queries) getMessageUserMapChangesAfter(ctx context.Context, arg getMessageUserMapChangesAfterParams) ([]getMessageUserMapChangesAfterRow, error) {
+	rows, err := q.db.QueryContext(ctx, getMessageUserMapChangesAfter,
+		arg.AfterTime,
+		arg.AfterMessageID,
+		arg.AfterReceiverID,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Conversation
+	var items []getMessageUserMapChangesAfterRow
 	for rows.Next() {
-		var i Conversation
+		var i getMessageUserMapChangesAfterRow
 		if err := rows.Scan(
-			&i.ID,
-			&i.IsGroup,
-			&i.OwnerID,
-			&i.Name,
-			&i.Description,
-			&i.ImageUrl,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.MessageID,
+			&i.ReceiverID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMessagesByIds = `-- name: getMessagesByIds :many
+SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at, receipt_updated_at FROM Messages
+WHERE id = ANY($1::VARCHAR[])
+`
+
+func (q *Queries) getMessagesByIds(ctx context.Context, dollar_1 []string) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, getMessagesByIds, pq.Array(dollar_1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.Body,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.DeliveredCount,
+			&i.SeenCount,
+			&i.SentToCount,
+			&i.SentAt,
+			&i.CreatedAt,
+			&i.ReceiptUpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMostRecentConversationsForUser = `-- name: getMostRecentConversationsForUser :many
+SELECT c.id, c.is_group, c.owner_id, c.name, c.description, c.image_url, c.created_at, c.updated_at, c.deleted_at, c.last_message_at
+FROM Conversations c
+INNER JOIN ConversationParticipants cp ON c.id = cp.conversation_id
+WHERE cp.user_id = $1 AND c.last_message_at < $2
+ORDER BY c.last_message_at DESC
+LIMIT $3
+`
+
+type getMostRecentConversationsForUserParams struct {
+	UserID        string
+	LastMessageAt int64
+	Limit         int32
+}
+
+func (q *Queries) getMostRecentConversationsForUser(ctx context.Context, arg getMostRecentConversationsForUserParams) ([]Conversation, error) {
+	rows, err := q.db.QueryContext(ctx, getMostRecentConversationsForUser, arg.UserID, arg.LastMessageAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Conversation
+	for rows.Next() {
+		var i Conversation
+		if err := rows.Scan(
+			&i.ID,
+			&i.IsGroup,
+			&i.OwnerID,
+			&i.Name,
+			&i.Description,
+			&i.ImageUrl,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.DeletedAt,
@@ -1160,7 +1611,7 @@ func (q *Queries) getMostRecentConversationsForUser(ctx context.Context, arg get
 }
 
 const getMostRecentMessagesForUser = `-- name: getMostRecentMessagesForUser :many
-SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at FROM Messages
+SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at, receipt_updated_at FROM Messages
 WHERE conversation_id IN (
   SELECT conversation_id
   FROM ConversationParticipants
@@ -1195,6 +1646,7 @@ func (q *Queries) getMostRecentMessagesForUser(ctx context.Context, arg getMostR
 			&i.SentToCount,
 			&i.SentAt,
 			&i.CreatedAt,
+			&i.ReceiptUpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -1210,7 +1662,7 @@ func (q *Queries) getMostRecentMessagesForUser(ctx context.Context, arg getMostR
 }
 
 const getMostRecentMessagesForUserInConversation = `-- name: getMostRecentMessagesForUserInConversation :many
-SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at FROM Messages
+SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at, receipt_updated_at FROM Messages
 WHERE conversation_id = $1 AND created_at < $2
 ORDER BY created_at DESC
 LIMIT $3
@@ -1241,6 +1693,7 @@ func (q *Queries) getMostRecentMessagesForUserInConversation(ctx context.Context
 			&i.SentToCount,
 			&i.SentAt,
 			&i.CreatedAt,
+			&i.ReceiptUpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -1316,6 +1769,89 @@ func (q *Queries) getMutualFriendsOfUser(ctx context.Context, arg getMutualFrien
 	return items, nil
 }
 
+const getNewMessagesForUser = `-- name: getNewMessagesForUser :many
+SELECT m.id, m.body, m.conversation_id, m.sender_id, m.delivered_count, m.seen_count, m.sent_to_count, m.sent_at, m.created_at, m.receipt_updated_at
+FROM Messages m
+INNER JOIN ConversationParticipants cp ON m.conversation_id = cp.conversation_id
+WHERE cp.user_id = $1 AND (m.created_at, m.id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY m.created_at ASC, m.id ASC
+LIMIT $4
+`
+
+type getNewMessagesForUserParams struct {
+	UserID    string
+	AfterTime int64
+	AfterID   string
+	Limit     int32
+}
+
+func (q *Queries) getNewMessagesForUser(ctx context.Context, arg getNewMessagesForUserParams) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, getNewMessagesForUser,
+		arg.UserID,
+		arg.AfterTime,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.Body,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.DeliveredCount,
+			&i.SeenCount,
+			&i.SentToCount,
+			&i.SentAt,
+			&i.CreatedAt,
+			&i.ReceiptUpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNodesForUser = `-- name: getNodesForUser :many
+SELECT node_id FROM WSPresence
+WHERE user_id = $1
+`
+
+func (q *Queries) getNodesForUser(ctx context.Context, userID string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getNodesForUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var node_id string
+		if err := rows.Scan(&node_id); err != nil {
+			return nil, err
+		}
+		items = append(items, node_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getNumberOfFollowersOfUser = `-- name: getNumberOfFollowersOfUser :one
 SELECT COUNT(*)
 FROM Follows
@@ -1355,6 +1891,175 @@ func (q *Queries) getNumberOfUsersFollowedByUser(ctx context.Context, followerID
 	return count, err
 }
 
+const getPendingMessagesForUser = `-- name: getPendingMessagesForUser :many
+SELECT m.id, m.body, m.conversation_id, m.sender_id, m.delivered_count, m.seen_count, m.sent_to_count, m.sent_at, m.created_at, m.receipt_updated_at, mum.undelivered_at
+FROM Messages m
+INNER JOIN MessageUserMap mum ON m.id = mum.message_id
+WHERE mum.receiver_id = $1
+  AND CASE WHEN $2::VARCHAR = '' THEN mum.delivered_at IS NULL
+    ELSE NOT EXISTS (
+      SELECT 1 FROM MessageDeviceDelivery mdd WHERE mdd.message_id = m.id AND mdd.device_id = $2
+    ) END
+  AND (mum.undelivered_at, m.id) > ($3::BIGINT, $4::VARCHAR)
+ORDER BY mum.undelivered_at ASC, m.id ASC
+LIMIT $5
+`
+
+type getPendingMessagesForUserParams struct {
+	ReceiverID string
+	DeviceID   string
+	AfterTime  int64
+	AfterID    string
+	Limit      int32
+}
+
+type getPendingMessagesForUserRow struct {
+	ID               string
+	Body             string
+	ConversationID   string
+	SenderID         string
+	DeliveredCount   int32
+	SeenCount        int32
+	SentToCount      int32
+	SentAt           int64
+	CreatedAt        int64
+	ReceiptUpdatedAt int64
+	UndeliveredAt    sql.NullInt64
+}
+
+func (q *Queries) getPendingMessagesForUser(ctx context.Context, arg getPendingMessagesForUserParams) ([]getPendingMessagesForUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, getPendingMessagesForUser,
+		arg.ReceiverID,
+		arg.DeviceID,
+		arg.AfterTime,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []getPendingMessagesForUserRow
+	for rows.Next() {
+		var i getPendingMessagesForUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Body,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.DeliveredCount,
+			&i.SeenCount,
+			&i.SentToCount,
+			&i.SentAt,
+			&i.CreatedAt,
+			&i.ReceiptUpdatedAt,
+			&i.UndeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReceiptUpdatesForSender = `-- name: getReceiptUpdatesForSender :many
+SELECT id, body, conversation_id, sender_id, delivered_count, seen_count, sent_to_count, sent_at, created_at, receipt_updated_at FROM Messages
+WHERE sender_id = $1 AND (receipt_updated_at, id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY receipt_updated_at ASC, id ASC
+LIMIT $4
+`
+
+type getReceiptUpdatesForSenderParams struct {
+	SenderID  string
+	AfterTime int64
+	AfterID   string
+	Limit     int32
+}
+
+func (q *Queries) getReceiptUpdatesForSender(ctx context.Context, arg getReceiptUpdatesForSenderParams) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, getReceiptUpdatesForSender,
+		arg.SenderID,
+		arg.AfterTime,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Message
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.ID,
+			&i.Body,
+			&i.ConversationID,
+			&i.SenderID,
+			&i.DeliveredCount,
+			&i.SeenCount,
+			&i.SentToCount,
+			&i.SentAt,
+			&i.CreatedAt,
+			&i.ReceiptUpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReceiptsForMessage = `-- name: getReceiptsForMessage :many
+SELECT mum.receiver_id, mum.delivered_at,
+  CASE WHEN COALESCE(us.read_receipts_enabled, TRUE) THEN mum.seen_at END AS seen_at
+FROM MessageUserMap mum
+INNER JOIN Messages m ON m.id = mum.message_id
+LEFT JOIN UserSettings us ON us.user_id = mum.receiver_id
+WHERE mum.message_id = $1 AND mum.receiver_id <> m.sender_id
+ORDER BY mum.receiver_id ASC
+`
+
+type getReceiptsForMessageRow struct {
+	ReceiverID  string
+	DeliveredAt sql.NullInt64
+	SeenAt      sql.NullInt64
+}
+
+func (q *Queries) getReceiptsForMessage(ctx context.Context, messageID string) ([]getReceiptsForMessageRow, error) {
+	rows, err := q.db.QueryContext(ctx, getReceiptsForMessage, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []getReceiptsForMessageRow
+	for rows.Next() {
+		var i getReceiptsForMessageRow
+		if err := rows.Scan(&i.ReceiverID, &i.DeliveredAt, &i.SeenAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getScheduledEventsCreatedByUser = `-- name: getScheduledEventsCreatedByUser :many
 SELECT ce.id, ce.user_id, ce.event_title, ce.event_description, ce.from_time, ce.to_time, ce.is_recurring, ce.game_id, ce.created_at, ce.updated_at, ce.deleted_at
 FROM CalendarEvents ce
@@ -1466,6 +2171,148 @@ func (q *Queries) getScheduledEventsForUser(ctx context.Context, arg getSchedule
 	return items, nil
 }
 
+const getSeenMessageChangesAfter = `-- name: getSeenMessageChangesAfter :many
+SELECT json_build_object(
+  'table', 'messages',
+  'op', 'UPDATE',
+  'ids', json_build_object('id', m.id),
+  'version', 1
+)::text AS payload, m.receipt_updated_at, m.id
+FROM Messages m
+WHERE (m.receipt_updated_at, m.id) > ($1::BIGINT, $2::VARCHAR) AND m.seen_count = m.sent_to_count
+ORDER BY m.receipt_updated_at ASC, m.id ASC
+LIMIT $3
+`
+
+type getSeenMessageChangesAfterParams struct {
+	AfterTime int64
+	AfterID   string
+	Limit     int32
+}
+
+type getSeenMessageChangesAfterRow struct {
+	Payload          string
+	ReceiptUpdatedAt int64
+	ID               string
+}
+
+func (q *Queries) getSeenMessageChangesAfter(ctx context.Context, arg getSeenMessageChangesAfterParams) ([]getSeenMessageChangesAfterRow, error) {
+	rows, err := q.db.QueryContext(ctx, getSeenMessageChangesAfter, arg.AfterTime, arg.AfterID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []getSeenMessageChangesAfterRow
+	for rows.Next() {
+		var i getSeenMessageChangesAfterRow
+		if err := rows.Scan(&i.Payload, &i.ReceiptUpdatedAt, &i.ID); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSenderMessageIdsInRange = `-- name: getSenderMessageIdsInRange :many
+SELECT id FROM Messages
+WHERE conversation_id = $1 AND sender_id = $2 AND sent_at BETWEEN $3 AND $4
+ORDER BY sent_at ASC, id ASC
+`
+
+type getSenderMessageIdsInRangeParams struct {
+	ConversationID string
+	SenderID       string
+	SentAt         int64
+	SentAt_2       int64
+}
+
+func (q *Queries) getSenderMessageIdsInRange(ctx context.Context, arg getSenderMessageIdsInRangeParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getSenderMessageIdsInRange,
+		arg.ConversationID,
+		arg.SenderID,
+		arg.SentAt,
+		arg.SentAt_2,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSocialRequestChangesForUser = `-- name: getSocialRequestChangesForUser :many
+SELECT id, user_id, target_user_id, request_type, request_message, request_status, created_at, updated_at
+FROM SocialRequests
+WHERE (user_id = $1 OR target_user_id = $1)
+  AND (COALESCE(updated_at, created_at), id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY COALESCE(updated_at, created_at) ASC, id ASC
+LIMIT $4
+`
+
+type getSocialRequestChangesForUserParams struct {
+	UserID    string
+	AfterTime int64
+	AfterID   string
+	Limit     int32
+}
+
+func (q *Queries) getSocialRequestChangesForUser(ctx context.Context, arg getSocialRequestChangesForUserParams) ([]Socialrequest, error) {
+	rows, err := q.db.QueryContext(ctx, getSocialRequestChangesForUser,
+		arg.UserID,
+		arg.AfterTime,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Socialrequest
+	for rows.Next() {
+		var i Socialrequest
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.TargetUserID,
+			&i.RequestType,
+			&i.RequestMessage,
+			&i.RequestStatus,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUserByID = `-- name: getUserByID :one
 SELECT id, name, username, email, description, image_url, created_at, updated_at, deleted_at FROM Users WHERE id = $1
 `
@@ -1487,6 +2334,18 @@ func (q *Queries) getUserByID(ctx context.Context, id string) (User, error) {
 	return i, err
 }
 
+const getUserSettings = `-- name: getUserSettings :one
+SELECT user_id, read_receipts_enabled, updated_at FROM UserSettings
+WHERE user_id = $1
+`
+
+func (q *Queries) getUserSettings(ctx context.Context, userID string) (Usersetting, error) {
+	row := q.db.QueryRowContext(ctx, getUserSettings, userID)
+	var i Usersetting
+	err := row.Scan(&i.UserID, &i.ReadReceiptsEnabled, &i.UpdatedAt)
+	return i, err
+}
+
 const getUsersFollowedByUser = `-- name: getUsersFollowedByUser :many
 SELECT u.id, u.name, u.image_url, f.created_at
 FROM Follows f
@@ -1633,21 +2492,25 @@ func (q *Queries) makeUsersFriends(ctx context.Context, arg makeUsersFriendsPara
 }
 
 const markMessageAsReceivedByUser = `-- name: markMessageAsReceivedByUser :many
-DELETE FROM MessageUserMap
-USING (
-  SELECT id FROM UNNEST($1::VARCHAR[]) AS id
-) AS message_ids
-WHERE message_id = message_ids.id
-  AND receiver_id = $2 RETURNING message_id, receiver_id
+UPDATE MessageUserMap mum
+SET delivered_at = $3
+FROM Messages m
+WHERE m.id = mum.message_id
+  AND mum.message_id = ANY($1::VARCHAR[])
+  AND mum.receiver_id = $2
+  AND m.sender_id <> $2
+  AND mum.delivered_at IS NULL
+RETURNING mum.message_id, mum.receiver_id, mum.undelivered_at, mum.delivered_at, mum.seen_at
 `
 
 type markMessageAsReceivedByUserParams struct {
-	Column1    []string
-	ReceiverID string
+	Column1     []string
+	ReceiverID  string
+	DeliveredAt sql.NullInt64
 }
 
 func (q *Queries) markMessageAsReceivedByUser(ctx context.Context, arg markMessageAsReceivedByUserParams) ([]Messageusermap, error) {
-	rows, err := q.db.QueryContext(ctx, markMessageAsReceivedByUser, pq.Array(arg.Column1), arg.ReceiverID)
+	rows, err := q.db.QueryContext(ctx, markMessageAsReceivedByUser, pq.Array(arg.Column1), arg.ReceiverID, arg.DeliveredAt)
 	if err != nil {
 		return nil, err
 	}
@@ -1655,7 +2518,118 @@ func (q *Queries) markMessageAsReceivedByUser(ctx context.Context, arg markMessa
 	var items []Messageusermap
 	for rows.Next() {
 		var i Messageusermap
-		if err := rows.Scan(&i.MessageID, &i.ReceiverID); err != nil {
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.ReceiverID,
+			&i.UndeliveredAt,
+			&i.DeliveredAt,
+			&i.SeenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markMessageAsUndeliveredForUser = `-- name: markMessageAsUndeliveredForUser :exec
+UPDATE MessageUserMap
+SET undelivered_at = $3
+WHERE message_id = $1 AND receiver_id = $2
+`
+
+type markMessageAsUndeliveredForUserParams struct {
+	MessageID     string
+	ReceiverID    string
+	UndeliveredAt sql.NullInt64
+}
+
+func (q *Queries) markMessageAsUndeliveredForUser(ctx context.Context, arg markMessageAsUndeliveredForUserParams) error {
+	_, err := q.db.ExecContext(ctx, markMessageAsUndeliveredForUser, arg.MessageID, arg.ReceiverID, arg.UndeliveredAt)
+	return err
+}
+
+const markMessagesAsDeliveredToDevice = `-- name: markMessagesAsDeliveredToDevice :execrows
+INSERT INTO MessageDeviceDelivery (message_id, device_id, delivered_at)
+SELECT mum.message_id, $2, $3
+FROM MessageUserMap mum
+WHERE mum.message_id = ANY($1::VARCHAR[]) AND mum.receiver_id = $4
+ON CONFLICT (message_id, device_id) DO NOTHING
+`
+
+type markMessagesAsDeliveredToDeviceParams struct {
+	Column1     []string
+	DeviceID    string
+	DeliveredAt int64
+	ReceiverID  string
+}
+
+func (q *Queries) markMessagesAsDeliveredToDevice(ctx context.Context, arg markMessagesAsDeliveredToDeviceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markMessagesAsDeliveredToDevice,
+		pq.Array(arg.Column1),
+		arg.DeviceID,
+		arg.DeliveredAt,
+		arg.ReceiverID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const markMessagesAsSeenByUser = `-- name: markMessagesAsSeenByUser :many
+WITH unseen AS (
+  SELECT mum.message_id, mum.delivered_at IS NULL AS was_undelivered
+  FROM MessageUserMap mum
+  INNER JOIN Messages m ON m.id = mum.message_id
+  WHERE mum.receiver_id = $1
+    AND m.conversation_id = $2
+    AND m.created_at <= $3
+    AND m.sender_id <> $1
+    AND mum.seen_at IS NULL
+  FOR UPDATE OF mum
+)
+UPDATE MessageUserMap mum
+SET seen_at = $4, delivered_at = COALESCE(mum.delivered_at, $4)
+FROM unseen
+WHERE mum.message_id = unseen.message_id
+  AND mum.receiver_id = $1
+RETURNING mum.message_id, unseen.was_undelivered
+`
+
+type markMessagesAsSeenByUserParams struct {
+	ReceiverID     string
+	ConversationID string
+	CreatedAt      int64
+	SeenAt         sql.NullInt64
+}
+
+type markMessagesAsSeenByUserRow struct {
+	MessageID      string
+	WasUndelivered bool
+}
+
+func (q *Queries) markMessagesAsSeenByUser(ctx context.Context, arg markMessagesAsSeenByUserParams) ([]markMessagesAsSeenByUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, markMessagesAsSeenByUser,
+		arg.ReceiverID,
+		arg.ConversationID,
+		arg.CreatedAt,
+		arg.SeenAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []markMessagesAsSeenByUserRow
+	for rows.Next() {
+		var i markMessagesAsSeenByUserRow
+		if err := rows.Scan(&i.MessageID, &i.WasUndelivered); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -1694,6 +2668,101 @@ func (q *Queries) organizerRequestToDeleteEvent(ctx context.Context, id string)
 	return i, err
 }
 
+const registerDevice = `-- name: registerDevice :one
+INSERT INTO Devices (id, user_id, name, platform, created_at, last_seen_at)
+VALUES ($1, $2, $3, $4, $5, $5)
+ON CONFLICT (id) DO UPDATE
+SET name = EXCLUDED.name, platform = EXCLUDED.platform, last_seen_at = EXCLUDED.last_seen_at
+WHERE Devices.user_id = EXCLUDED.user_id AND Devices.revoked_at IS NULL
+RETURNING id, user_id, name, platform, created_at, last_seen_at, revoked_at
+`
+
+type registerDeviceParams struct {
+	ID        string
+	UserID    string
+	Name      string
+	Platform  string
+	CreatedAt int64
+}
+
+func (q *Queries) registerDevice(ctx context.Context, arg registerDeviceParams) (Device, error) {
+	row := q.db.QueryRowContext(ctx, registerDevice,
+		arg.ID,
+		arg.UserID,
+		arg.Name,
+		arg.Platform,
+		arg.CreatedAt,
+	)
+	var i Device
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.Platform,
+		&i.CreatedAt,
+		&i.LastSeenAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const registerPresence = `-- name: registerPresence :exec
+INSERT INTO WSPresence (user_id, node_id, connected_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, node_id) DO UPDATE SET connected_at = EXCLUDED.connected_at
+`
+
+type registerPresenceParams struct {
+	UserID      string
+	NodeID      string
+	ConnectedAt int64
+}
+
+func (q *Queries) registerPresence(ctx context.Context, arg registerPresenceParams) error {
+	_, err := q.db.ExecContext(ctx, registerPresence, arg.UserID, arg.NodeID, arg.ConnectedAt)
+	return err
+}
+
+const revokeDevice = `-- name: revokeDevice :execrows
+UPDATE Devices
+SET revoked_at = $3
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type revokeDeviceParams struct {
+	ID        string
+	UserID    string
+	RevokedAt sql.NullInt64
+}
+
+func (q *Queries) revokeDevice(ctx context.Context, arg revokeDeviceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeDevice, arg.ID, arg.UserID, arg.RevokedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const touchDevice = `-- name: touchDevice :execrows
+UPDATE Devices
+SET last_seen_at = $3
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type touchDeviceParams struct {
+	ID         string
+	UserID     string
+	LastSeenAt int64
+}
+
+func (q *Queries) touchDevice(ctx context.Context, arg touchDeviceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, touchDevice, arg.ID, arg.UserID, arg.LastSeenAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const unfriendUsers = `-- name: unfriendUsers :exec
 DELETE FROM Friends
 WHERE (user1_id = $1 AND user2_id = $2) OR (user1_id = $2 AND user2_id = $1)
@@ -1709,6 +2778,21 @@ func (q *Queries) unfriendUsers(ctx context.Context, arg unfriendUsersParams) er
 	return err
 }
 
+const unregisterPresence = `-- name: unregisterPresence :exec
+DELETE FROM WSPresence
+WHERE user_id = $1 AND node_id = $2
+`
+
+type unregisterPresenceParams struct {
+	UserID string
+	NodeID string
+}
+
+func (q *Queries) unregisterPresence(ctx context.Context, arg unregisterPresenceParams) error {
+	_, err := q.db.ExecContext(ctx, unregisterPresence, arg.UserID, arg.NodeID)
+	return err
+}
+
 const updateCalendarEventDetails = `-- name: updateCalendarEventDetails :one
 UPDATE CalendarEvents
 SET 
@@ -1829,35 +2913,25 @@ func (q *Queries) updateLastMessageSeenAtInConversationParticipant(ctx context.C
 	return err
 }
 
-const updateSeenCountForMessages = `-- name: updateSeenCountForMessages :exec
-UPDATE Messages
-SET seen_count = seen_count + 1
-WHERE id IN (SELECT UNNEST($1))
+const updateListenerLastAliveAt = `-- name: updateListenerLastAliveAt :exec
+INSERT INTO ListenerState (id, last_alive_at)
+VALUES (1, $1)
+ON CONFLICT (id) DO UPDATE SET last_alive_at = GREATEST(ListenerState.last_alive_at, EXCLUDED.last_alive_at)
 `
 
-func (q *Queries) updateSeenCountForMessages(ctx context.Context, unnest interface{}) error {
-	_, err := q.db.ExecContext(ctx, updateSeenCountForMessages, unnest)
+func (q *Queries) updateListenerLastAliveAt(ctx context.Context, lastAliveAt int64) error {
+	_, err := q.db.ExecContext(ctx, updateListenerLastAliveAt, lastAliveAt)
 	return err
 }
 
-const updateSeenCountForMessagesBetweenTimestamps = `-- name: updateSeenCountForMessagesBetweenTimestamps :exec
+const updateSeenCountForMessages = `-- name: updateSeenCountForMessages :exec
 UPDATE Messages
 SET seen_count = seen_count + 1
-WHERE EXISTS (
-  SELECT 1
-  FROM Messages AS m2
-  WHERE m2.id = Messages.id
-    AND m2.created_at > $1 AND m2.created_at <= $2
-)
+WHERE id IN (SELECT UNNEST($1))
 `
 
-type updateSeenCountForMessagesBetweenTimestampsParams struct {
-	CreatedAt   int64
-	CreatedAt_2 int64
-}
-
-func (q *Queries) updateSeenCountForMessagesBetweenTimestamps(ctx context.Context, arg updateSeenCountForMessagesBetweenTimestampsParams) error {
-	_, err := q.db.ExecContext(ctx, updateSeenCountForMessagesBetweenTimestamps, arg.CreatedAt, arg.CreatedAt_2)
+func (q *Queries) updateSeenCountForMessages(ctx context.Context, unnest interface{}) error {
+	_, err := q.db.ExecContext(ctx, updateSeenCountForMessages, unnest)
 	return err
 }
 
@@ -1951,6 +3025,23 @@ func (q *Queries) updateUser(ctx context.Context, arg updateUserParams) error {
 	return err
 }
 
+const upsertUserSettings = `-- name: upsertUserSettings :exec
+INSERT INTO UserSettings (user_id, read_receipts_enabled, updated_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE SET read_receipts_enabled = EXCLUDED.read_receipts_enabled, updated_at = EXCLUDED.updated_at
+`
+
+type upsertUserSettingsParams struct {
+	UserID              string
+	ReadReceiptsEnabled bool
+	UpdatedAt           int64
+}
+
+func (q *Queries) upsertUserSettings(ctx context.Context, arg upsertUserSettingsParams) error {
+	_, err := q.db.ExecContext(ctx, upsertUserSettings, arg.UserID, arg.ReadReceiptsEnabled, arg.UpdatedAt)
+	return err
+}
+
 const userRequestToJoinEvent = `-- name: userRequestToJoinEvent :one
 INSERT INTO CalendarEventRequests (id, event_id, requesting_user_id, request_message, created_at)
 VALUES ($1, $2, $3, $4, $5)
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"g_chat/models"
+	"log"
+)
+
+type SyncQueries struct {
+	*Queries
+}
+
+func GetSyncQueries() *SyncQueries {
+	queries := getQueries()
+	return &SyncQueries{queries}
+}
+
+// PendingMessage is a message the outbox gave up on, UndeliveredAt is when it was marked undelivered
+type PendingMessage struct {
+	Message
+	UndeliveredAt int64
+}
+
+// Gets undelivered messages of the user which were marked undelivered after the given position, in the order they were marked.
+// With a device id, messages already delivered to that device are skipped instead of messages delivered to any device
+func (db *SyncQueries) GetPendingMessagesForUser(ctx context.Context, userId string, deviceId string, after models.SyncPosition, numRows uint) ([]PendingMessage, error) {
+	rows, err := db.Queries.getPendingMessagesForUser(ctx, getPendingMessagesForUserParams{
+		ReceiverID: userId,
+		DeviceID:   deviceId,
+		AfterTime:  after.Time,
+		AfterID:    after.Id,
+		Limit:      int32(numRows),
+	})
+
+	if err != nil {
+		log.Printf("DB error : error getting pending messages : f(GetPendingMessagesForUser) : error : %v", err)
+		return nil, err
+	}
+
+	messages := make([]PendingMessage, len(rows))
+	for i, row := range rows {
+		messages[i] = PendingMessage{
+			Message: Message{
+				ID:               row.ID,
+				Body:             row.Body,
+				ConversationID:   row.ConversationID,
+				SenderID:         row.SenderID,
+				DeliveredCount:   row.DeliveredCount,
+				SeenCount:        row.SeenCount,
+				SentToCount:      row.SentToCount,
+				SentAt:           row.SentAt,
+				CreatedAt:        row.CreatedAt,
+				ReceiptUpdatedAt: row.ReceiptUpdatedAt,
+			},
+			UndeliveredAt: row.UndeliveredAt.Int64,
+		}
+	}
+
+	return messages, nil
+}
+
+// Gets messages of the user's conversations created after the given position, in creation order
+func (db *SyncQueries) GetNewMessagesForUser(ctx context.Context, userId string, after models.SyncPosition, numRows uint) ([]Message, error) {
+	messages, err := db.Queries.getNewMessagesForUser(ctx, getNewMessagesForUserParams{
+		UserID:    userId,
+		AfterTime: after.Time,
+		AfterID:   after.Id,
+		Limit:     int32(numRows),
+	})
+
+	if err != nil {
+		log.Printf("DB error : error getting new messages : f(GetNewMessagesForUser) : error : %v", err)
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// Gets messages sent by the user whose delivered/seen counts changed after the given position
+func (db *SyncQueries) GetReceiptUpdatesForSender(ctx context.Context, userId string, after models.SyncPosition, numRows uint) ([]Message, error) {
+	messages, err := db.Queries.getReceiptUpdatesForSender(ctx, getReceiptUpdatesForSenderParams{
+		SenderID:  userId,
+		AfterTime: after.Time,
+		AfterID:   after.Id,
+		Limit:     int32(numRows),
+	})
+
+	if err != nil {
+		log.Printf("DB error : error getting receipt updates : f(GetReceiptUpdatesForSender) : error : %v", err)
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// Gets social requests sent or received by the user which were created or updated after the given position
+func (db *SyncQueries) GetSocialRequestChangesForUser(ctx context.Context, userId string, after models.SyncPosition, numRows uint) ([]Socialrequest, error) {
+	requests, err := db.Queries.getSocialRequestChangesForUser(ctx, getSocialRequestChangesForUserParams{
+		UserID:    userId,
+		AfterTime: after.Time,
+		AfterID:   after.Id,
+		Limit:     int32(numRows),
+	})
+
+	if err != nil {
+		log.Printf("DB error : error getting social request changes : f(GetSocialRequestChangesForUser) : error : %v", err)
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// Gets calendar invites for the user which were created or updated after the given position
+func (db *SyncQueries) GetCalendarInviteChangesForUser(ctx context.Context, userId string, after models.SyncPosition, numRows uint) ([]Calendareventinvite, error) {
+	invites, err := db.Queries.getCalendarInviteChangesForUser(ctx, getCalendarInviteChangesForUserParams{
+		InvitedUserID: userId,
+		AfterTime:     after.Time,
+		AfterID:       after.Id,
+		Limit:         int32(numRows),
+	})
+
+	if err != nil {
+		log.Printf("DB error : error getting calendar invite changes : f(GetCalendarInviteChangesForUser) : error : %v", err)
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// Gets calendar requests made by the user or made on events organized by the user, created or updated after the given position
+func (db *SyncQueries) GetCalendarRequestChangesForUser(ctx context.Context, userId string, after models.SyncPosition, numRows uint) ([]Calendareventrequest, error) {
+	requests, err := db.Queries.getCalendarRequestChangesForUser(ctx, getCalendarRequestChangesForUserParams{
+		RequestingUserID: userId,
+		AfterTime:        after.Time,
+		AfterID:          after.Id,
+		Limit:            int32(numRows),
+	})
+
+	if err != nil {
+		log.Printf("DB error : error getting calendar request changes : f(GetCalendarRequestChangesForUser) : error : %v", err)
+		return nil, err
+	}
+
+	return requests, nil
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	ws "g_chat/wsConnections"
+)
+
+// PostgresTicketStore keeps websocket tickets in the WSTickets table so any instance can accept them,
+// consuming deletes the row in the same statement which makes tickets single use
+type PostgresTicketStore struct{}
+
+func (store *PostgresTicketStore) Save(ctx context.Context, ticket ws.WSTicket) error {
+	params := createWSTicketParams{
+		Ticket:    ticket.Ticket,
+		UserID:    ticket.UserId,
+		DeviceID:  ticket.DeviceId,
+		ClientIp:  ticket.ClientIP,
+		UserAgent: ticket.UserAgent,
+		CreatedAt: ticket.CreatedAt.UnixNano(),
+		ExpiresAt: ticket.ExpiresAt.UnixNano(),
+	}
+	if !ticket.TokenIssuedAt.IsZero() {
+		params.TokenIssuedAt = ticket.TokenIssuedAt.Unix()
+	}
+	if !ticket.TokenExpiresAt.IsZero() {
+		params.TokenExpiresAt = ticket.TokenExpiresAt.Unix()
+	}
+
+	return getQueries().createWSTicket(ctx, params)
+}
+
+func (store *PostgresTicketStore) Consume(ctx context.Context, ticket string) (ws.WSTicket, bool, error) {
+	wsTicket, err := getQueries().consumeWSTicket(ctx, consumeWSTicketParams{
+		Ticket:    ticket,
+		ExpiresAt: time.Now().UnixNano(),
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ws.WSTicket{}, false, nil
+	}
+
+	if err != nil {
+		return ws.WSTicket{}, false, err
+	}
+
+	consumed := ws.WSTicket{
+		Ticket:    wsTicket.Ticket,
+		UserId:    wsTicket.UserID,
+		DeviceId:  wsTicket.DeviceID,
+		ClientIP:  wsTicket.ClientIp,
+		UserAgent: wsTicket.UserAgent,
+		CreatedAt: time.Unix(0, wsTicket.CreatedAt),
+		ExpiresAt: time.Unix(0, wsTicket.ExpiresAt),
+	}
+	// zero means the ticket was issued without token details
+	if wsTicket.TokenIssuedAt > 0 {
+		consumed.TokenIssuedAt = time.Unix(wsTicket.TokenIssuedAt, 0)
+	}
+	if wsTicket.TokenExpiresAt > 0 {
+		consumed.TokenExpiresAt = time.Unix(wsTicket.TokenExpiresAt, 0)
+	}
+
+	return consumed, true, nil
+}
+
+func (store *PostgresTicketStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	return getQueries().deleteExpiredWSTickets(ctx, now.UnixNano())
+}
@@ -2,6 +2,8 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"g_chat/models"
 	"log"
 	"time"
@@ -67,3 +69,43 @@ func (db *UserQueries) GetAllUsers(ctx context.Context) ([]User, error) {
 
 	return nil, nil
 }
+
+// Get settings of the user, defaults are returned when the user never changed them
+func (db *UserQueries) GetUserSettings(ctx context.Context, userId string) (models.UserSettings, error) {
+	settings, err := userSettingsWithDefaults(ctx, db.Queries, userId)
+	if err != nil {
+		log.Printf("DB error : error getting user settings : f(GetUserSettings) : error : %v", err)
+		return models.UserSettings{}, err
+	}
+	return settings, nil
+}
+
+// Update settings of the user
+func (db *UserQueries) UpdateUserSettings(ctx context.Context, userId string, settings models.UserSettings) error {
+	if err := db.Queries.upsertUserSettings(ctx, upsertUserSettingsParams{
+		UserID:              userId,
+		ReadReceiptsEnabled: settings.ReadReceiptsEnabled,
+		UpdatedAt:           time.Now().Unix(),
+	}); err != nil {
+		log.Printf("DB error : error updating user settings : f(UpdateUserSettings) : error : %v", err)
+		return err
+	}
+	return nil
+}
+
+func userSettingsWithDefaults(ctx context.Context, queries *Queries, userId string) (models.UserSettings, error) {
+	settings, err := queries.getUserSettings(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.UserSettings{
+			ReadReceiptsEnabled: true,
+		}, nil
+	}
+
+	if err != nil {
+		return models.UserSettings{}, err
+	}
+
+	return models.UserSettings{
+		ReadReceiptsEnabled: settings.ReadReceiptsEnabled,
+	}, nil
+}
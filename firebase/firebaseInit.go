@@ -9,18 +9,16 @@ import (
 	"google.golang.org/api/option"
 )
 
-const firebaseConfigFile = "./serviceAccount.json"
-
 var (
 	app        *firebase.App
 	authClient *auth.Client
 	ctx        = context.Background()
 )
 
-func CreateFirebaseApp() error {
+func CreateFirebaseApp(credentialsFile string) error {
 	if app == nil {
 		var err error
-		opt := option.WithCredentialsFile(firebaseConfigFile)
+		opt := option.WithCredentialsFile(credentialsFile)
 		app, err = firebase.NewApp(ctx, nil, opt)
 		if err != nil {
 			log.Fatalf("Error getting Firebase app %v", err)
@@ -2,74 +2,188 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"g_chat/auth"
+	"g_chat/config"
 	"g_chat/controllers"
 	"g_chat/database"
-	"g_chat/firebase"
+	"g_chat/middleware"
 	"g_chat/routes"
 	ws "g_chat/wsConnections"
 	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 )
 
 func init() {
-	err := godotenv.Load(".env")
+	if err := config.Load(); err != nil {
+		log.Fatalf("Error loading configuration: %s", err.Error())
+	}
+	cfg := config.Get()
+
+	policy, err := middleware.NewOriginPolicy(cfg.CORS.AllowedOrigins)
 	if err != nil {
-		log.Fatalf("Error loading .env file: %s", err.Error())
+		log.Fatalf("Error in allowed origins: %s", err.Error())
 	}
+	originPolicy = policy
 
-	if err := database.CreateDatabaseInstance(); err != nil {
+	if err := database.CreateDatabaseInstance(cfg.Database.DSN); err != nil {
 		log.Fatalf("error creating database instance %v", err)
 	}
 
-	err = firebase.CreateFirebaseApp()
-	if err != nil {
-		log.Fatalf("Failed Firebase connection %v", err)
+	// firebase is only initialised when it is the configured auth provider
+	if err := auth.CreateAuthenticator(cfg.Auth); err != nil {
+		log.Fatalf("Failed creating authenticator %v", err)
 	}
 
 	ws.CreateConnectionManager(context.Background())
+	ws.GetConnectionManager().Configure(websocketSettings(cfg))
+
+	// every instance needs a unique node id when running more than one server
+	if nodeId := cfg.WebSocket.ClusterNodeId; nodeId != "" {
+		if err := database.EnableCluster(ws.GetConnectionManager(), nodeId); err != nil {
+			log.Fatalf("error enabling cluster mode : error - %v", err)
+		}
+	}
+
+	configureTicketStore(cfg)
+
+	controllers.RegisterDBNotifyHandlers()
 
 	if err := database.InitializeListener(ws.GetConnectionManager()); err != nil {
 		log.Fatalf("error creating database notification on ws : error - %v", err)
 	}
 
-	fmt.Println("Initialized Firebase and Database and DB notifiers")
+	fmt.Println("Initialized Auth and Database and DB notifiers")
+}
+
+// originPolicy is shared by the CORS middleware and the websocket upgrader
+var originPolicy *middleware.OriginPolicy
+
+func websocketSettings(cfg *config.Config) ws.Settings {
+	settings := ws.DefaultSettings()
+	settings.PongWait = cfg.WebSocket.PongWait
+	settings.PingInterval = cfg.WebSocket.PingInterval
+	settings.ReadLimit = cfg.WebSocket.ReadLimit
+	for name, limit := range cfg.WebSocket.EventSizeLimits {
+		eventType, ok := ws.ParseEventType(name)
+		if !ok {
+			log.Fatalf("Error in websocket event size limits: unknown event %v", name)
+		}
+		settings.EventSizeLimits[eventType] = limit
+	}
+	settings.EnableCompression = cfg.WebSocket.Compression.Enabled
+	settings.CompressionLevel = cfg.WebSocket.Compression.Level
+	settings.CompressionThreshold = cfg.WebSocket.Compression.Threshold
+	settings.ReadBufferSize = cfg.WebSocket.ReadBufferSize
+	settings.WriteBufferSize = cfg.WebSocket.WriteBufferSize
+	settings.OriginPolicy = originPolicy
+	settings.SendQueueSize = cfg.WebSocket.SendQueueSize
+	settings.SlowConsumerPolicy = ws.SlowConsumerPolicy(cfg.WebSocket.SlowConsumerPolicy)
+	settings.SlowConsumerMaxDrops = cfg.WebSocket.SlowConsumerMaxDrops
+	settings.IncomingQueueSize = cfg.WebSocket.IncomingQueueSize
+	settings.HandlerWorkers = cfg.WebSocket.HandlerWorkers
+	settings.MaxConnectionsPerUser = cfg.WebSocket.MaxConnectionsPerUser
+	settings.ConnectionLimitStrategy = ws.ConnectionLimitStrategy(cfg.WebSocket.ConnectionLimitStrategy)
+
+	if !cfg.Features.ReceiptBatching {
+		settings.ReceiptCoalesceWindow = 0
+	}
+	if !cfg.Features.TokenRevalidation {
+		settings.TokenRevalidateInterval = 0
+	}
+	return settings
+}
+
+// tickets are kept in postgres when running more than one instance unless a store is configured
+func configureTicketStore(cfg *config.Config) {
+	storeKind := cfg.WebSocket.TicketStore
+	if storeKind == "" && ws.GetConnectionManager().IsClusterEnabled() {
+		storeKind = "postgres"
+	}
+
+	var store ws.TicketStore
+	switch storeKind {
+	case "postgres":
+		store = &database.PostgresTicketStore{}
+	default:
+		store = ws.NewMemoryTicketStore()
+	}
+
+	ws.GetConnectionManager().TicketManager.Configure(store, cfg.WebSocket.TicketTTL)
 }
 
 func main() {
+	cfg := config.Get()
+
 	server := gin.Default()
 
-	server.Use(CORSMiddleware())
+	server.Use(middleware.CORS(originPolicy, cfg.CORS.AllowCredentials, cfg.CORS.MaxAge))
+
+	server.GET("/health", controllers.GetLiveness)
 
 	userServer := server.Group("/api/v1/users")
 	wsGroup := server.Group("/api/v1/ws")
 	chatGroup := server.Group("/api/v1/chat")
+	syncGroup := server.Group("/api/v1/sync")
+	deviceGroup := server.Group("/api/v1/devices")
 
 	routes.CreateUserRoutes(userServer)
 	routes.CreateWSRoutes(wsGroup)
 	routes.CreateChatRoutes(chatGroup)
+	routes.CreateSyncRoutes(syncGroup)
+	routes.CreateDeviceRoutes(deviceGroup)
+
+	if cfg.Features.AdminRoutes {
+		adminGroup := server.Group("/api/v1/admin")
+		routes.CreateAdminRoutes(adminGroup)
+	}
 
 	controllers.RegisterWSHandlers()
 
-	server.Run()
+	httpServer := &http.Server{
+		Addr:    cfg.Server.Address,
+		Handler: server,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("error running http server %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	shutdown(httpServer, cfg)
 }
 
-// TODO Cors
+// shutdown tells sockets to reconnect, lets in flight requests finish and stops the listener and pools
+// within the configured timeout. Sockets go first since http.Server.Shutdown does not wait for hijacked
+// connections.
+func shutdown(httpServer *http.Server, cfg *config.Config) {
+	log.Printf("shutting down, waiting up to %v", cfg.Server.ShutdownTimeout)
 
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
+	if err := ws.GetConnectionManager().Shutdown(ctx, cfg.Server.RestartReconnectAfter); err != nil {
+		log.Printf("error closing websockets %v", err)
+	}
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("error shutting down http server %v", err)
+	}
 
-		c.Next()
+	if err := database.Shutdown(ctx); err != nil {
+		log.Printf("error shutting down database %v", err)
 	}
+
+	fmt.Println("Server stopped")
 }
@@ -1,18 +1,16 @@
 package middleware
 
 import (
-	"g_chat/firebase"
+	"g_chat/auth"
 	"net/http"
 	"strings"
 
-	"firebase.google.com/go/auth"
 	"github.com/gin-gonic/gin"
 )
 
+// ValidateUserToken verifies the bearer token and stores the caller as a Principal, see GetPrincipal
 func ValidateUserToken() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		var authClient *auth.Client = firebase.GetFirebaseAuthClient()
-
 		authHeader := strings.Split(ctx.Request.Header.Get("Authorization"), " ")
 
 		if len(authHeader) < 2 {
@@ -21,17 +19,21 @@ func ValidateUserToken() gin.HandlerFunc {
 			return
 		}
 
-		token, err := authClient.VerifyIDToken(ctx, authHeader[1])
+		identity, err := auth.GetAuthenticator().Verify(ctx, authHeader[1])
 		if err != nil {
 			ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized access token"})
 			ctx.Abort()
 			return
 		}
-		ctx.Set("userId", token.UID)
-		ctx.Next()
 
-		ctx.Set("userId", "token")
+		ctx.Set(principalKey, Principal{
+			UserId:    identity.UserId,
+			Email:     identity.Email,
+			Claims:    identity.Claims,
+			AuthTime:  identity.AuthTime,
+			IssuedAt:  identity.IssuedAt,
+			ExpiresAt: identity.ExpiresAt,
+		})
 		ctx.Next()
 	}
-
 }
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	corsAllowHeaders = "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With"
+	corsAllowMethods = "POST, OPTIONS, GET, PUT, PATCH, DELETE"
+)
+
+// CORS answers cross origin requests from origins allowed by the policy, preflight responses are cached
+// by the browser for maxAge
+func CORS(policy *OriginPolicy, allowCredentials bool, maxAge time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		header := ctx.Writer.Header()
+		// the response depends on the origin, caches must not share it between origins
+		header.Add("Vary", "Origin")
+
+		origin := ctx.Request.Header.Get("Origin")
+		allowed := policy.Allowed(origin)
+
+		if allowed {
+			if policy.AllowsAll() && !allowCredentials {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if allowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if ctx.Request.Method == http.MethodOptions && ctx.Request.Header.Get("Access-Control-Request-Method") != "" {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+
+			if !allowed {
+				ctx.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+
+			header.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			header.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			if maxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+			}
+			ctx.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		ctx.Next()
+	}
+}
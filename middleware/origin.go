@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides which browser origins may call the API and open websockets. Entries are exact
+// origins ("https://app.example.com"), wildcard subdomains ("https://*.example.com", which does not
+// match example.com itself) or "*" for every origin.
+type OriginPolicy struct {
+	allowAll  bool
+	exact     map[string]bool
+	wildcards []originPattern
+}
+
+type originPattern struct {
+	scheme string
+	// host suffix including the leading dot and the port if any, e.g. ".example.com:8443"
+	suffix string
+}
+
+func NewOriginPolicy(origins []string) (*OriginPolicy, error) {
+	policy := &OriginPolicy{
+		exact: make(map[string]bool),
+	}
+
+	for _, origin := range origins {
+		if origin == "*" {
+			policy.allowAll = true
+			continue
+		}
+
+		scheme, host, err := splitOrigin(origin)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Contains(host, "*") {
+			if !strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1 {
+				return nil, fmt.Errorf("invalid origin pattern %v, only a leading *. is supported", origin)
+			}
+			policy.wildcards = append(policy.wildcards, originPattern{
+				scheme: scheme,
+				suffix: host[1:],
+			})
+			continue
+		}
+
+		policy.exact[scheme+"://"+host] = true
+	}
+
+	return policy, nil
+}
+
+// AllowsAll is true when "*" is part of the allowed origins
+func (policy *OriginPolicy) AllowsAll() bool {
+	return policy.allowAll
+}
+
+func (policy *OriginPolicy) Allowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if policy.allowAll {
+		return true
+	}
+
+	scheme, host, err := splitOrigin(origin)
+	if err != nil {
+		return false
+	}
+
+	if policy.exact[scheme+"://"+host] {
+		return true
+	}
+
+	for _, pattern := range policy.wildcards {
+		if scheme == pattern.scheme && strings.HasSuffix(host, pattern.suffix) && len(host) > len(pattern.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitOrigin returns the lower cased scheme and host (with port) of an origin
+func splitOrigin(origin string) (string, string, error) {
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+		return "", "", fmt.Errorf("invalid origin %v, expected scheme://host[:port]", origin)
+	}
+	return strings.ToLower(parsed.Scheme), strings.ToLower(parsed.Host), nil
+}
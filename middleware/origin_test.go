@@ -0,0 +1,69 @@
+package middleware
+
+import "testing"
+
+func TestNewOriginPolicyRejectsInvalidOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		wantErr bool
+	}{
+		{name: "exact origin", origins: []string{"https://app.example.com"}},
+		{name: "origin with port", origins: []string{"http://localhost:3000"}},
+		{name: "wildcard subdomain", origins: []string{"https://*.example.com"}},
+		{name: "allow all", origins: []string{"*"}},
+		{name: "missing scheme", origins: []string{"app.example.com"}, wantErr: true},
+		{name: "with path", origins: []string{"https://app.example.com/path"}, wantErr: true},
+		{name: "wildcard in the middle", origins: []string{"https://app.*.example.com"}, wantErr: true},
+		{name: "two wildcards", origins: []string{"https://*.*.example.com"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewOriginPolicy(tt.origins)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOriginPolicyAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{name: "exact match", origins: []string{"https://app.example.com"}, origin: "https://app.example.com", want: true},
+		{name: "exact match ignores case", origins: []string{"https://app.example.com"}, origin: "HTTPS://App.Example.com", want: true},
+		{name: "trailing slash", origins: []string{"https://app.example.com"}, origin: "https://app.example.com/", want: true},
+		{name: "other scheme", origins: []string{"https://app.example.com"}, origin: "http://app.example.com", want: false},
+		{name: "other port", origins: []string{"http://localhost:3000"}, origin: "http://localhost:4000", want: false},
+		{name: "wildcard subdomain", origins: []string{"https://*.example.com"}, origin: "https://app.example.com", want: true},
+		{name: "wildcard nested subdomain", origins: []string{"https://*.example.com"}, origin: "https://a.b.example.com", want: true},
+		{name: "wildcard does not match the apex", origins: []string{"https://*.example.com"}, origin: "https://example.com", want: false},
+		{name: "wildcard does not match a lookalike", origins: []string{"https://*.example.com"}, origin: "https://evilexample.com", want: false},
+		{name: "wildcard does not match a suffix domain", origins: []string{"https://*.example.com"}, origin: "https://app.example.com.evil.com", want: false},
+		{name: "wildcard checks the scheme", origins: []string{"https://*.example.com"}, origin: "http://app.example.com", want: false},
+		{name: "wildcard with port", origins: []string{"https://*.example.com:8443"}, origin: "https://app.example.com:8443", want: true},
+		{name: "wildcard with other port", origins: []string{"https://*.example.com:8443"}, origin: "https://app.example.com", want: false},
+		{name: "allow all", origins: []string{"*"}, origin: "https://anything.test", want: true},
+		{name: "empty origin", origins: []string{"*"}, origin: "", want: false},
+		{name: "invalid origin", origins: []string{"https://app.example.com"}, origin: "not an origin", want: false},
+		{name: "no origins", origins: nil, origin: "https://app.example.com", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewOriginPolicy(tt.origins)
+			if err != nil {
+				t.Fatalf("creating policy: %v", err)
+			}
+
+			if got := policy.Allowed(tt.origin); got != tt.want {
+				t.Fatalf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
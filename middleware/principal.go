@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "principal"
+
+// Principal is the authenticated caller of a request, set by ValidateUserToken
+type Principal struct {
+	UserId    string
+	Email     string
+	Claims    map[string]any
+	AuthTime  time.Time
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// GetPrincipal returns the caller, false on routes without ValidateUserToken
+func GetPrincipal(ctx *gin.Context) (Principal, bool) {
+	value, ok := ctx.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	principal, ok := value.(Principal)
+	return principal, ok
+}
+
+// UserId returns the id of the caller, empty on routes without ValidateUserToken
+func UserId(ctx *gin.Context) string {
+	principal, _ := GetPrincipal(ctx)
+	return principal.UserId
+}
+
+// HasRole checks the role claim (a string) and the roles claim (a list)
+func (principal Principal) HasRole(role string) bool {
+	if value, ok := principal.Claims["role"].(string); ok && value == role {
+		return true
+	}
+
+	if values, ok := principal.Claims["roles"].([]any); ok {
+		for _, value := range values {
+			if value == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequireRole only lets callers with one of the roles through, must run after ValidateUserToken
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, ok := GetPrincipal(ctx)
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			return
+		}
+
+		for _, role := range roles {
+			if principal.HasRole(role) {
+				ctx.Next()
+				return
+			}
+		}
+
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Forbidden"})
+	}
+}
+
+// RequireClaim only lets callers whose claim equals value through, must run after ValidateUserToken
+func RequireClaim(name string, value any) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, ok := GetPrincipal(ctx)
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized"})
+			return
+		}
+
+		if !claimEquals(principal.Claims[name], value) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Forbidden"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// claimEquals compares a decoded claim with the expected value. Numbers decode as float64 (or json.Number)
+// so they are compared by value, lists and objects are compared deeply instead of with == which panics on them.
+func claimEquals(claim any, value any) bool {
+	switch expected := value.(type) {
+	case string:
+		actual, ok := claim.(string)
+		return ok && actual == expected
+	case bool:
+		actual, ok := claim.(bool)
+		return ok && actual == expected
+	}
+
+	if expected, ok := claimNumber(value); ok {
+		actual, ok := claimNumber(claim)
+		return ok && actual == expected
+	}
+
+	return reflect.DeepEqual(claim, value)
+}
+
+func claimNumber(value any) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case float32:
+		return float64(number), true
+	case int:
+		return float64(number), true
+	case int32:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case json.Number:
+		parsed, err := number.Float64()
+		return parsed, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireClaim(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		claims     map[string]any
+		value      any
+		noAuth     bool
+		wantStatus int
+	}{
+		{name: "matching string", claims: map[string]any{"tier": "pro"}, value: "pro", wantStatus: http.StatusOK},
+		{name: "other string", claims: map[string]any{"tier": "free"}, value: "pro", wantStatus: http.StatusForbidden},
+		{name: "missing claim", claims: map[string]any{}, value: "pro", wantStatus: http.StatusForbidden},
+		{name: "matching bool", claims: map[string]any{"tier": true}, value: true, wantStatus: http.StatusOK},
+		{name: "bool against string", claims: map[string]any{"tier": "true"}, value: true, wantStatus: http.StatusForbidden},
+		{name: "decoded number against int", claims: map[string]any{"tier": float64(2)}, value: 2, wantStatus: http.StatusOK},
+		{name: "json number", claims: map[string]any{"tier": json.Number("2")}, value: 2, wantStatus: http.StatusOK},
+		{name: "other number", claims: map[string]any{"tier": float64(3)}, value: 2, wantStatus: http.StatusForbidden},
+		{name: "list claim against string", claims: map[string]any{"tier": []any{"pro"}}, value: "pro", wantStatus: http.StatusForbidden},
+		{name: "list claim against list", claims: map[string]any{"tier": []any{"pro"}}, value: []any{"pro"}, wantStatus: http.StatusOK},
+		{name: "object claim against object", claims: map[string]any{"tier": map[string]any{"a": "b"}}, value: map[string]any{"a": "c"}, wantStatus: http.StatusForbidden},
+		{name: "no principal", noAuth: true, value: "pro", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/", func(ctx *gin.Context) {
+				if !tt.noAuth {
+					ctx.Set(principalKey, Principal{UserId: "user", Claims: tt.claims})
+				}
+			}, RequireClaim("tier", tt.value), func(ctx *gin.Context) {
+				ctx.Status(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v", recorder.Code, tt.wantStatus)
+			}
+		})
+	}
+}
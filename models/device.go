@@ -0,0 +1,16 @@
+package models
+
+type NewDevice struct {
+	ID       string `json:"id"` // optional, generated by server if empty
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+}
+
+type DeviceDetails struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Platform   string `json:"platform"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	Connected  bool   `json:"connected"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "errors"
+
 // Non DB models
 type IncomingChatPayload struct {
 	ID             string `json:"id"` // for inconimg message - a temp guid to be used in frontend for mapping
@@ -11,6 +13,23 @@ type IncomingChatPayload struct {
 	SentAt         int64  `json:"sent_at"`
 }
 
+func (payload *IncomingChatPayload) Sender() string {
+	return payload.SenderId
+}
+
+func (payload *IncomingChatPayload) Validate() error {
+	if payload.MessageBody == "" {
+		return errors.New("message_body is required")
+	}
+	if payload.IsGroup && payload.ConversationId == "" {
+		return errors.New("conversation_id is required for group messages")
+	}
+	if !payload.IsGroup && payload.ReceiverId == "" {
+		return errors.New("receiver_id is required")
+	}
+	return nil
+}
+
 type OutgoingChatPayload struct {
 	ID                string `json:"id"` // for inconimg message - a temp guid to be used in frontend for mapping
 	MessageBody       string `json:"message_body"`
@@ -22,3 +41,10 @@ type OutgoingChatPayload struct {
 	SenderId          string `json:"sender_id"`
 	ServerRecieveTime int64  `json:"server_recieve_time"`
 }
+
+// MessageReceipt is the delivered/seen time of a message for one receiver, zero when not delivered/seen yet
+type MessageReceipt struct {
+	ReceiverId  string `json:"receiver_id"`
+	DeliveredAt int64  `json:"delivered_at"`
+	SeenAt      int64  `json:"seen_at"`
+}
@@ -0,0 +1,74 @@
+package models
+
+// Non DB models
+
+// SyncPosition is the server time and id of the last row returned from a stream, the id orders rows
+// sharing a time so none are skipped at a page boundary
+type SyncPosition struct {
+	Time int64  `json:"t"`
+	Id   string `json:"id,omitempty"`
+}
+
+// SyncCursor holds the last seen position for every synced stream, it is sent to clients as an opaque string
+type SyncCursor struct {
+	Messages SyncPosition `json:"m"`
+	// position in undelivered time, see MarkMessageAsUndeliveredForUser
+	Pending          SyncPosition `json:"p"`
+	Receipts         SyncPosition `json:"r"`
+	SocialRequests   SyncPosition `json:"s"`
+	CalendarInvites  SyncPosition `json:"ci"`
+	CalendarRequests SyncPosition `json:"cr"`
+}
+
+type SyncRequest struct {
+	Cursor     string `json:"cursor"`
+	QueryCount uint   `json:"query_count"`
+}
+
+type SyncReceipt struct {
+	MessageId      string `json:"message_id"`
+	ConversationId string `json:"conversation_id"`
+	DeliveredCount int32  `json:"delivered_count"`
+	SeenCount      int32  `json:"seen_count"`
+	SentToCount    int32  `json:"sent_to_count"`
+	Time           int64  `json:"time"`
+}
+
+type SyncSocialRequest struct {
+	ID            string `json:"id"`
+	RequestorId   string `json:"requestor_id"`
+	TargetUserId  string `json:"target_user_id"`
+	RequestType   string `json:"request_type"`
+	Message       string `json:"message"`
+	RequestStatus string `json:"request_status"`
+	Time          int64  `json:"time"`
+}
+
+type SyncCalendarInvite struct {
+	ID            string `json:"id"`
+	EventId       string `json:"event_id"`
+	InvitedUserId string `json:"invited_user_id"`
+	Message       string `json:"message"`
+	InviteStatus  string `json:"invite_status"`
+	Time          int64  `json:"time"`
+}
+
+type SyncCalendarRequest struct {
+	ID            string `json:"id"`
+	EventId       string `json:"event_id"`
+	RequestorId   string `json:"requestor_id"`
+	Message       string `json:"message"`
+	RequestStatus string `json:"request_status"`
+	Time          int64  `json:"time"`
+}
+
+// SyncResponse carries every stream in server order, HasMore is set when any stream hit the query count
+type SyncResponse struct {
+	Messages         []OutgoingChatPayload `json:"messages"`
+	Receipts         []SyncReceipt         `json:"receipts"`
+	SocialRequests   []SyncSocialRequest   `json:"social_requests"`
+	CalendarInvites  []SyncCalendarInvite  `json:"calendar_invites"`
+	CalendarRequests []SyncCalendarRequest `json:"calendar_requests"`
+	Cursor           string                `json:"cursor"`
+	HasMore          bool                  `json:"has_more"`
+}
@@ -14,3 +14,7 @@ type NewUser struct {
 	Description string `json:"description"`
 	Username    string `json:"username"`
 }
+
+type UserSettings struct {
+	ReadReceiptsEnabled bool `json:"read_receipts_enabled"`
+}
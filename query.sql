@@ -133,12 +133,39 @@ LIMIT $3;
 
 
 -- name: markMessageAsReceivedByUser :many
-DELETE FROM MessageUserMap
-USING (
-  SELECT * FROM UNNEST($1::VARCHAR[]) AS id
-) AS message_ids
-WHERE message_id = message_ids.id
-  AND receiver_id = $2 RETURNING *;
+UPDATE MessageUserMap mum
+SET delivered_at = $3
+FROM Messages m
+WHERE m.id = mum.message_id
+  AND mum.message_id = ANY($1::VARCHAR[])
+  AND mum.receiver_id = $2
+  AND m.sender_id <> $2
+  AND mum.delivered_at IS NULL
+RETURNING mum.*;
+
+-- name: markMessagesAsSeenByUser :many
+WITH unseen AS (
+  SELECT mum.message_id, mum.delivered_at IS NULL AS was_undelivered
+  FROM MessageUserMap mum
+  INNER JOIN Messages m ON m.id = mum.message_id
+  WHERE mum.receiver_id = $1
+    AND m.conversation_id = $2
+    AND m.created_at <= $3
+    AND m.sender_id <> $1
+    AND mum.seen_at IS NULL
+  FOR UPDATE OF mum
+)
+UPDATE MessageUserMap mum
+SET seen_at = $4, delivered_at = COALESCE(mum.delivered_at, $4)
+FROM unseen
+WHERE mum.message_id = unseen.message_id
+  AND mum.receiver_id = $1
+RETURNING mum.message_id, unseen.was_undelivered;
+
+-- name: markMessageAsUndeliveredForUser :exec
+UPDATE MessageUserMap
+SET undelivered_at = $3
+WHERE message_id = $1 AND receiver_id = $2;
 
 -- name: updateSeenCountForMessages :exec
 UPDATE Messages
@@ -152,16 +179,6 @@ SET delivered_count = delivered_count + 1
 WHERE id IN (SELECT UNNEST($1));
 
 
--- name: updateSeenCountForMessagesBetweenTimestamps :exec
-UPDATE Messages
-SET seen_count = seen_count + 1
-WHERE EXISTS (
-  SELECT 1
-  FROM Messages AS m2
-  WHERE m2.id = Messages.id
-    AND m2.created_at > $1 AND m2.created_at <= $2
-);
-
 -- name: getFriendsOfUser :many
 SELECT u1.id, u1.name, u1.image_url, f.created_at
 FROM Friends f
@@ -324,6 +341,15 @@ SELECT EXISTS (
   WHERE id = $1 AND user_id = $2
 );
 
+-- name: fIsGameVisibleToUser :one
+SELECT EXISTS (
+  SELECT 1
+  FROM CalendarEvents ce
+  LEFT JOIN CalendarEventParticipants cep ON cep.event_id = ce.id AND cep.user_id = $1
+  WHERE ce.game_id = $2
+    AND (ce.to_time > $3 OR ce.user_id = $1 OR cep.user_id IS NOT NULL)
+);
+
 -- name: updateCalendarRequest :one
 UPDATE CalendarEventRequests
 SET request_status = $3, updated_at = $2
@@ -462,3 +488,202 @@ WHERE event_id = $1
   AND created_at < $2
   AND invite_status = $4
 LIMIT $3;
+
+
+-- name: getPendingMessagesForUser :many
+SELECT m.*, mum.undelivered_at
+FROM Messages m
+INNER JOIN MessageUserMap mum ON m.id = mum.message_id
+WHERE mum.receiver_id = $1
+  AND CASE WHEN $2::VARCHAR = '' THEN mum.delivered_at IS NULL
+    ELSE NOT EXISTS (
+      SELECT 1 FROM MessageDeviceDelivery mdd WHERE mdd.message_id = m.id AND mdd.device_id = $2
+    ) END
+  AND (mum.undelivered_at, m.id) > ($3::BIGINT, $4::VARCHAR)
+ORDER BY mum.undelivered_at ASC, m.id ASC
+LIMIT $5;
+
+-- name: getNewMessagesForUser :many
+SELECT m.*
+FROM Messages m
+INNER JOIN ConversationParticipants cp ON m.conversation_id = cp.conversation_id
+WHERE cp.user_id = $1 AND (m.created_at, m.id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY m.created_at ASC, m.id ASC
+LIMIT $4;
+
+-- name: getReceiptUpdatesForSender :many
+SELECT * FROM Messages
+WHERE sender_id = $1 AND (receipt_updated_at, id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY receipt_updated_at ASC, id ASC
+LIMIT $4;
+
+-- name: getSocialRequestChangesForUser :many
+SELECT *
+FROM SocialRequests
+WHERE (user_id = $1 OR target_user_id = $1)
+  AND (COALESCE(updated_at, created_at), id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY COALESCE(updated_at, created_at) ASC, id ASC
+LIMIT $4;
+
+-- name: getCalendarInviteChangesForUser :many
+SELECT *
+FROM CalendarEventInvites
+WHERE invited_user_id = $1
+  AND (COALESCE(updated_at, created_at), id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY COALESCE(updated_at, created_at) ASC, id ASC
+LIMIT $4;
+
+-- name: getCalendarRequestChangesForUser :many
+SELECT cer.*
+FROM CalendarEventRequests cer
+WHERE (cer.requesting_user_id = $1 OR cer.event_id IN (
+    SELECT ce.id FROM CalendarEvents ce WHERE ce.user_id = $1
+  ))
+  AND (COALESCE(cer.updated_at, cer.created_at), cer.id) > ($2::BIGINT, $3::VARCHAR)
+ORDER BY COALESCE(cer.updated_at, cer.created_at) ASC, cer.id ASC
+LIMIT $4;
+
+-- name: registerDevice :one
+INSERT INTO Devices (id, user_id, name, platform, created_at, last_seen_at)
+VALUES ($1, $2, $3, $4, $5, $5)
+ON CONFLICT (id) DO UPDATE
+SET name = EXCLUDED.name, platform = EXCLUDED.platform, last_seen_at = EXCLUDED.last_seen_at
+WHERE Devices.user_id = EXCLUDED.user_id AND Devices.revoked_at IS NULL
+RETURNING *;
+
+-- name: getDevicesOfUser :many
+SELECT * FROM Devices
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY last_seen_at DESC;
+
+-- name: touchDevice :execrows
+UPDATE Devices
+SET last_seen_at = $3
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: revokeDevice :execrows
+UPDATE Devices
+SET revoked_at = $3
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: markMessagesAsDeliveredToDevice :execrows
+INSERT INTO MessageDeviceDelivery (message_id, device_id, delivered_at)
+SELECT mum.message_id, $2, $3
+FROM MessageUserMap mum
+WHERE mum.message_id = ANY($1::VARCHAR[]) AND mum.receiver_id = $4
+ON CONFLICT (message_id, device_id) DO NOTHING;
+
+-- name: registerPresence :exec
+INSERT INTO WSPresence (user_id, node_id, connected_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, node_id) DO UPDATE SET connected_at = EXCLUDED.connected_at;
+
+-- name: unregisterPresence :exec
+DELETE FROM WSPresence
+WHERE user_id = $1 AND node_id = $2;
+
+-- name: getNodesForUser :many
+SELECT node_id FROM WSPresence
+WHERE user_id = $1;
+
+-- name: clearPresenceForNode :exec
+DELETE FROM WSPresence
+WHERE node_id = $1;
+
+-- name: createClusterMessage :exec
+INSERT INTO ClusterMessages (id, payload, created_at)
+VALUES ($1, $2, $3);
+
+-- name: getClusterMessage :one
+SELECT payload FROM ClusterMessages
+WHERE id = $1;
+
+-- name: deleteExpiredClusterMessages :exec
+DELETE FROM ClusterMessages
+WHERE created_at < $1;
+
+-- name: getMessageUserMapChangesAfter :many
+SELECT json_build_object(
+  'table', 'messageusermap',
+  'op', 'INSERT',
+  'ids', json_build_object('message_id', mum.message_id, 'receiver_id', mum.receiver_id),
+  'version', 1
+)::text AS payload, m.created_at, mum.message_id, mum.receiver_id
+FROM MessageUserMap mum
+INNER JOIN Messages m ON m.id = mum.message_id
+WHERE (m.created_at, mum.message_id, mum.receiver_id) > ($1::BIGINT, $2::VARCHAR, $3::VARCHAR)
+ORDER BY m.created_at ASC, mum.message_id ASC, mum.receiver_id ASC
+LIMIT $4;
+
+-- name: getDeliveredMessageChangesAfter :many
+SELECT json_build_object(
+  'table', 'messages',
+  'op', 'UPDATE',
+  'ids', json_build_object('id', m.id),
+  'version', 1
+)::text AS payload, m.receipt_updated_at, m.id
+FROM Messages m
+WHERE (m.receipt_updated_at, m.id) > ($1::BIGINT, $2::VARCHAR) AND m.delivered_count = m.sent_to_count
+ORDER BY m.receipt_updated_at ASC, m.id ASC
+LIMIT $3;
+
+-- name: getSeenMessageChangesAfter :many
+SELECT json_build_object(
+  'table', 'messages',
+  'op', 'UPDATE',
+  'ids', json_build_object('id', m.id),
+  'version', 1
+)::text AS payload, m.receipt_updated_at, m.id
+FROM Messages m
+WHERE (m.receipt_updated_at, m.id) > ($1::BIGINT, $2::VARCHAR) AND m.seen_count = m.sent_to_count
+ORDER BY m.receipt_updated_at ASC, m.id ASC
+LIMIT $3;
+
+-- name: getListenerLastAliveAt :one
+SELECT last_alive_at FROM ListenerState
+WHERE id = 1;
+
+-- name: updateListenerLastAliveAt :exec
+INSERT INTO ListenerState (id, last_alive_at)
+VALUES (1, $1)
+ON CONFLICT (id) DO UPDATE SET last_alive_at = GREATEST(ListenerState.last_alive_at, EXCLUDED.last_alive_at);
+
+-- name: getMessagesByIds :many
+SELECT * FROM Messages
+WHERE id = ANY($1::VARCHAR[]);
+
+-- name: getSenderMessageIdsInRange :many
+SELECT id FROM Messages
+WHERE conversation_id = $1 AND sender_id = $2 AND sent_at BETWEEN $3 AND $4
+ORDER BY sent_at ASC, id ASC;
+
+-- name: getReceiptsForMessage :many
+SELECT mum.receiver_id, mum.delivered_at,
+  CASE WHEN COALESCE(us.read_receipts_enabled, TRUE) THEN mum.seen_at END AS seen_at
+FROM MessageUserMap mum
+INNER JOIN Messages m ON m.id = mum.message_id
+LEFT JOIN UserSettings us ON us.user_id = mum.receiver_id
+WHERE mum.message_id = $1 AND mum.receiver_id <> m.sender_id
+ORDER BY mum.receiver_id ASC;
+
+-- name: getUserSettings :one
+SELECT * FROM UserSettings
+WHERE user_id = $1;
+
+-- name: upsertUserSettings :exec
+INSERT INTO UserSettings (user_id, read_receipts_enabled, updated_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE SET read_receipts_enabled = EXCLUDED.read_receipts_enabled, updated_at = EXCLUDED.updated_at;
+
+-- name: createWSTicket :exec
+INSERT INTO WSTickets (ticket, user_id, device_id, client_ip, user_agent, token_issued_at, token_expires_at, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- name: consumeWSTicket :one
+DELETE FROM WSTickets
+WHERE ticket = $1 AND expires_at > $2
+RETURNING *;
+
+-- name: deleteExpiredWSTickets :exec
+DELETE FROM WSTickets
+WHERE expires_at <= $1;
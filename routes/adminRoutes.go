@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"g_chat/controllers"
+	"g_chat/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// admin routes need a token with the admin role (role or roles claim)
+func CreateAdminRoutes(baseRouter *gin.RouterGroup) {
+	baseRouter.Use(middleware.ValidateUserToken(), middleware.RequireRole("admin"))
+
+	baseRouter.GET("/health", controllers.GetServerHealth)
+	baseRouter.GET("/ws/queues", controllers.GetSendQueueStats)
+}
@@ -19,4 +19,5 @@ func CreateChatRoutes(baseRouter *gin.RouterGroup) {
 	baseRouter.POST("/sendMessageToUser", controllers.DeleteUser)
 
 	baseRouter.GET("/getAllMessages", controllers.CreateUser)
+	baseRouter.GET("/getMessageReceipts/:id", controllers.GetReceiptsForMessage)
 }
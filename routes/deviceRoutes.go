@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"g_chat/controllers"
+	"g_chat/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateDeviceRoutes(baseRouter *gin.RouterGroup) {
+	baseRouter.Use(middleware.ValidateUserToken())
+
+	baseRouter.POST("/register", controllers.RegisterDevice)
+	baseRouter.GET("/getAll", controllers.GetDevicesOfUser)
+	baseRouter.DELETE("/revoke/:id", controllers.RevokeDevice)
+}
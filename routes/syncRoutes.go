@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"g_chat/controllers"
+	"g_chat/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CreateSyncRoutes(baseRouter *gin.RouterGroup) {
+	baseRouter.Use(middleware.ValidateUserToken())
+
+	baseRouter.GET("", controllers.SyncForUser)
+}
@@ -17,4 +17,6 @@ func CreateUserRoutes(baseRouter *gin.RouterGroup) {
 	baseRouter.DELETE("/delete/:id", controllers.DeleteUser)
 	baseRouter.GET("/getCurrentUser", controllers.GetCurrentUserDetails)
 	baseRouter.GET("/get", controllers.GetUserFromId)
+	baseRouter.GET("/settings", controllers.GetUserSettings)
+	baseRouter.PUT("/settings", controllers.UpdateUserSettings)
 }
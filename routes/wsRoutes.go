@@ -13,6 +13,12 @@ func CreateWSRoutes(baseRouter *gin.RouterGroup) {
 	ticketRoute.Use(middleware.ValidateUserToken())
 	ticketRoute.GET("/createTicket", ws.GetConnectionManager().CreateNewTicket)
 
+	// live sockets of the caller
+	sessionRoute := baseRouter.Group("/sessions")
+	sessionRoute.Use(middleware.ValidateUserToken())
+	sessionRoute.GET("/getSessions", ws.GetConnectionManager().ListSessions)
+	sessionRoute.DELETE("/closeSession/:sessionId", ws.GetConnectionManager().CloseSession)
+
 	// Dont use middleware
 	baseRouter.GET("/connect", ws.GetConnectionManager().ServeWS)
 }
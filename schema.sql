@@ -34,17 +34,54 @@ CREATE TABLE IF NOT EXISTS Messages (
     sent_to_count INT NOT NULL,
     sent_at BIGINT NOT NULL,
     created_at BIGINT NOT NULL,
+    -- bumped every time delivered/seen count changes, used as the receipts sync cursor
+    receipt_updated_at BIGINT NOT NULL DEFAULT 0,
     FOREIGN KEY (conversation_id) REFERENCES Conversations(id) ON DELETE CASCADE
 );
 
 CREATE TABLE IF NOT EXISTS MessageUserMap (
     message_id VARCHAR(255) NOT NULL,
     receiver_id VARCHAR(255) NOT NULL,
+    -- set when the websocket outbox ran out of retries, message is sent again on sync
+    undelivered_at BIGINT,
+    -- per receiver receipts, rows are kept after delivery so group senders can see who received/read a message
+    delivered_at BIGINT,
+    seen_at BIGINT,
     PRIMARY KEY (message_id, receiver_id),
     -- Composite primary key
     FOREIGN KEY (message_id) REFERENCES Messages(id) ON DELETE CASCADE
 );
 
+-- per user preferences, a missing row means defaults
+CREATE TABLE IF NOT EXISTS UserSettings (
+    user_id VARCHAR(255) PRIMARY KEY,
+    -- when disabled reading messages does not set seen_at or bump seen_count
+    read_receipts_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at BIGINT NOT NULL
+);
+
+-- every client (phone, browser ...) registers itself as a device, websocket tickets are bound to a device
+CREATE TABLE IF NOT EXISTS Devices (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    platform VARCHAR(50) NOT NULL,
+    created_at BIGINT NOT NULL,
+    last_seen_at BIGINT NOT NULL,
+    revoked_at BIGINT,
+    FOREIGN KEY (user_id) REFERENCES Users(id) ON DELETE CASCADE
+);
+
+-- delivery of a message to each device of the receiver, sync resends undelivered messages a device has no row for
+CREATE TABLE IF NOT EXISTS MessageDeviceDelivery (
+    message_id VARCHAR(255) NOT NULL,
+    device_id VARCHAR(255) NOT NULL,
+    delivered_at BIGINT NOT NULL,
+    PRIMARY KEY (message_id, device_id),
+    FOREIGN KEY (message_id) REFERENCES Messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (device_id) REFERENCES Devices(id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS ConversationParticipants (
     conversation_id VARCHAR(255) NOT NULL,
     user_id VARCHAR(255) NOT NULL,
@@ -156,11 +193,54 @@ CREATE TABLE IF NOT EXISTS CalendarEventInvites (
   FOREIGN KEY (event_id) REFERENCES CalendarEvents(id) ON DELETE CASCADE
 );
 
+-- which server instance holds websocket connections of a user, only used in cluster mode
+CREATE TABLE IF NOT EXISTS WSPresence (
+  user_id VARCHAR(255) NOT NULL,
+  node_id VARCHAR(255) NOT NULL,
+  connected_at BIGINT NOT NULL,
+  PRIMARY KEY (user_id, node_id)
+);
+
+-- last time a notification listener was known to have handled every notification, shared by every
+-- instance so whichever instance takes over the listener replays what was written since
+CREATE TABLE IF NOT EXISTS ListenerState (
+  id INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+  last_alive_at BIGINT NOT NULL
+);
+
+-- cluster messages too large for a pg_notify payload, the notification carries only the id. Rows are
+-- removed once they are older than CLUSTER_MESSAGE_TTL
+CREATE TABLE IF NOT EXISTS ClusterMessages (
+  id VARCHAR(255) PRIMARY KEY,
+  payload TEXT NOT NULL,
+  created_at BIGINT NOT NULL
+);
+
+-- one time websocket tickets, shared by every instance so a ticket issued by one node can be used on another
+CREATE TABLE IF NOT EXISTS WSTickets (
+  ticket VARCHAR(255) PRIMARY KEY,
+  user_id VARCHAR(255) NOT NULL,
+  device_id VARCHAR(255) NOT NULL,
+  client_ip VARCHAR(255) NOT NULL,
+  user_agent TEXT NOT NULL,
+  -- lifetime of the access token the ticket was requested with, unix seconds
+  token_issued_at BIGINT NOT NULL,
+  token_expires_at BIGINT NOT NULL,
+  created_at BIGINT NOT NULL,
+  expires_at BIGINT NOT NULL
+);
+
+-- notifications only carry an envelope with the identifiers of the changed row, the listener reads
+-- the rows in batches. pg_notify payloads are limited to 8000 bytes so full rows must never be sent.
 CREATE OR REPLACE FUNCTION notify_chat()
 RETURNS TRIGGER AS $$
 BEGIN
-  -- Send notification with row data as payload
-  PERFORM pg_notify('chat_written', to_json(new)::text);
+  PERFORM pg_notify('chat_written', json_build_object(
+    'table', lower(TG_TABLE_NAME),
+    'op', TG_OP,
+    'ids', json_build_object('message_id', NEW.message_id, 'receiver_id', NEW.receiver_id),
+    'version', 1
+  )::text);
   RETURN NULL;
 END;
 $$ LANGUAGE plpgsql;
@@ -171,10 +251,30 @@ FOR EACH ROW
 EXECUTE PROCEDURE notify_chat();
 
 
+CREATE OR REPLACE FUNCTION touch_message_receipt()
+RETURNS TRIGGER AS $$
+BEGIN
+  NEW.receipt_updated_at := (extract(epoch from clock_timestamp()) * 1000000000)::BIGINT;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER touch_message_receipt_trigger
+BEFORE UPDATE ON Messages
+FOR EACH ROW
+WHEN (OLD.delivered_count != NEW.delivered_count or OLD.seen_count != NEW.seen_count)
+EXECUTE PROCEDURE touch_message_receipt();
+
+
 CREATE OR REPLACE FUNCTION notify_chat_received()
 RETURNS TRIGGER AS $$
 BEGIN
-  PERFORM pg_notify('chat_received', to_json(new)::text);
+  PERFORM pg_notify('chat_received', json_build_object(
+    'table', lower(TG_TABLE_NAME),
+    'op', TG_OP,
+    'ids', json_build_object('id', NEW.id),
+    'version', 1
+  )::text);
   RETURN NULL;
 END;
 $$ LANGUAGE plpgsql;
@@ -188,7 +288,12 @@ EXECUTE PROCEDURE notify_chat_received();
 CREATE OR REPLACE FUNCTION notify_chat_read()
 RETURNS TRIGGER AS $$
 BEGIN
-  PERFORM pg_notify('chat_read', to_json(new)::text);
+  PERFORM pg_notify('chat_read', json_build_object(
+    'table', lower(TG_TABLE_NAME),
+    'op', TG_OP,
+    'ids', json_build_object('id', NEW.id),
+    'version', 1
+  )::text);
   RETURN NULL;
 END;
 $$ LANGUAGE plpgsql;
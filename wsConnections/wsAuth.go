@@ -0,0 +1,191 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// clients are asked for a new token this long before the current one expires
+	TOKEN_REFRESH_WINDOW      = 2 * time.Minute
+	TOKEN_CHECK_INTERVAL      = 15 * time.Second
+	TOKEN_REVALIDATE_INTERVAL = 5 * time.Minute
+	TOKEN_VERIFY_TIMEOUT      = 10 * time.Second
+)
+
+type TokenInfo struct {
+	UserId    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// TokenAuthenticator verifies tokens sent over an open socket and checks the account behind a socket is still valid
+type TokenAuthenticator interface {
+	// Verify checks the token signature, expiry and revocation
+	Verify(ctx context.Context, token string) (TokenInfo, error)
+	// Revalidate returns false if the user was disabled or tokens issued at issuedAt were revoked
+	Revalidate(ctx context.Context, userId string, issuedAt time.Time) (bool, error)
+}
+
+// clientToken is the token the socket is currently authorised with
+type clientToken struct {
+	issuedAt         time.Time
+	expiresAt        time.Time
+	refreshRequested bool
+	sync.Mutex
+}
+
+func (client *Client) setToken(info TokenInfo) {
+	client.token.Lock()
+	defer client.token.Unlock()
+
+	client.token.issuedAt = info.IssuedAt
+	client.token.expiresAt = info.ExpiresAt
+	client.token.refreshRequested = false
+}
+
+func handleRefreshToken(event Event, client *Client) error {
+	return client.refreshToken(event)
+}
+
+// refreshToken handles the refresh token event, a token for another user or a revoked token closes the socket
+func (client *Client) refreshToken(event Event) error {
+	var refresh IncomingRefreshToken
+	if err := json.Unmarshal(event.Payload, &refresh); err != nil {
+		log.Printf("error unmarshalling refresh token event %v", err)
+		client.SendAckToClient(Acknowledge{
+			ReceiverID: client.UserId,
+			EventType:  event.Type,
+			Status:     false,
+			Message:    "failed unmarshal to refresh token",
+			AckTime:    time.Now().UnixNano(),
+		}, event.Id)
+		return err
+	}
+
+	authenticator := client.ConnectionManager.TokenAuth
+	if authenticator == nil {
+		client.SendAckToClient(Acknowledge{
+			ReceiverID: client.UserId,
+			EventType:  event.Type,
+			Status:     false,
+			Message:    "token refresh not supported",
+			AckTime:    time.Now().UnixNano(),
+		}, event.Id)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), TOKEN_VERIFY_TIMEOUT)
+	defer cancel()
+
+	info, err := authenticator.Verify(ctx, refresh.Token)
+	if err != nil || info.UserId != client.UserId {
+		log.Printf("invalid refresh token from user %v : error - %v", client.UserId, err)
+		client.ackAndClose(Acknowledge{
+			ReceiverID: client.UserId,
+			EventType:  event.Type,
+			Status:     false,
+			Message:    "invalid token, disconnecting",
+			AckTime:    time.Now().UnixNano(),
+		}, event.Id, websocket.ClosePolicyViolation, "invalid token")
+		return err
+	}
+
+	client.setToken(info)
+
+	client.SendAckToClient(Acknowledge{
+		ReceiverID: client.UserId,
+		EventType:  event.Type,
+		Status:     true,
+		Message:    "success",
+		AckTime:    time.Now().UnixNano(),
+	}, event.Id)
+	return nil
+}
+
+// watchToken asks the client for a new token before expiry, closes the socket once the token expired and
+// periodically checks the account was not disabled or its tokens revoked
+func (client *Client) watchToken() {
+	tick := time.NewTicker(TOKEN_CHECK_INTERVAL)
+	defer tick.Stop()
+
+	lastRevalidated := time.Now()
+
+	for {
+		select {
+		case now := <-tick.C:
+			client.token.Lock()
+			issuedAt := client.token.issuedAt
+			expiresAt := client.token.expiresAt
+			requestRefresh := !expiresAt.IsZero() && !client.token.refreshRequested && now.Add(TOKEN_REFRESH_WINDOW).After(expiresAt)
+			if requestRefresh {
+				client.token.refreshRequested = true
+			}
+			client.token.Unlock()
+
+			if !expiresAt.IsZero() && now.After(expiresAt) {
+				log.Printf("token of user %v expired, closing socket", client.UserId)
+				client.closeWithReason(websocket.ClosePolicyViolation, "token expired")
+				return
+			}
+
+			if requestRefresh {
+				client.sendTokenRefreshRequired(TokenRefreshRequired{
+					ExpiresAt: expiresAt.UnixNano(),
+				})
+			}
+
+			revalidateInterval := client.ConnectionManager.Settings.TokenRevalidateInterval
+			if revalidateInterval > 0 && now.Sub(lastRevalidated) >= revalidateInterval {
+				lastRevalidated = now
+				if !client.revalidateToken(issuedAt) {
+					log.Printf("token of user %v revoked, closing socket", client.UserId)
+					client.closeWithReason(websocket.ClosePolicyViolation, "token revoked")
+					return
+				}
+			}
+
+		case <-client.done:
+			return
+		}
+	}
+}
+
+// revalidateToken only reports false when the account is known to be invalid, errors keep the socket open
+func (client *Client) revalidateToken(issuedAt time.Time) bool {
+	authenticator := client.ConnectionManager.TokenAuth
+	if authenticator == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), TOKEN_VERIFY_TIMEOUT)
+	defer cancel()
+
+	valid, err := authenticator.Revalidate(ctx, client.UserId, issuedAt)
+	if err != nil {
+		log.Printf("error revalidating token of user %v : error - %v", client.UserId, err)
+		return true
+	}
+	return valid
+}
+
+func (client *Client) sendTokenRefreshRequired(refreshRequired TokenRefreshRequired) {
+	payload, err := json.Marshal(refreshRequired)
+
+	if err != nil {
+		log.Printf("Error marshalling token refresh payload %v", err)
+		return
+	}
+
+	client.push(Event{
+		Type:    EventOutgoingTokenRefreshRequired,
+		Payload: payload,
+		Id:      "",
+		Retry:   0,
+	})
+}
@@ -6,26 +6,91 @@ import (
 	"fmt"
 	"g_chat/models"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 type Client struct {
 	Conn              *websocket.Conn
 	UserId            string
-	Egress            chan Event
+	DeviceId          string
+	SessionId         string
+	ConnectedAt       int64
+	ClientIP          string
+	UserAgent         string
+	Egress            *SendQueue
 	ConnectionManager *ConnectionManager
 	MessagesChan      chan []models.OutgoingChatPayload
+	Outbox            *Outbox
+	token             *clientToken
+	done              chan struct{}
+	closeOnce         sync.Once
+	slowOnce          sync.Once
+	// events read from the socket waiting for the dispatcher, see wsDispatcher.go
+	incoming chan Event
+	// encodes events for the subprotocol negotiated on upgrade
+	codec Codec
+	// version and capabilities sent in the client's hello
+	protocol *clientProtocol
+	// asks the writer to send an error event and close, see failAndClose
+	closing chan closeRequest
+}
+
+func newClient(conn *websocket.Conn, ticket WSTicket, manager *ConnectionManager) *Client {
+	client := &Client{
+		Conn:              conn,
+		codec:             codecFor(conn.Subprotocol()),
+		UserId:            ticket.UserId,
+		DeviceId:          ticket.DeviceId,
+		SessionId:         uuid.NewString(),
+		ConnectedAt:       time.Now().UnixNano(),
+		ClientIP:          ticket.ClientIP,
+		UserAgent:         ticket.UserAgent,
+		ConnectionManager: manager,
+		token:             &clientToken{},
+		protocol:          &clientProtocol{},
+		closing:           make(chan closeRequest, 1),
+		done:              make(chan struct{}),
+	}
+	client.Outbox = newOutbox(client)
+	client.Egress = newSendQueue(client, manager.Settings.SendQueueSize)
+	client.incoming = make(chan Event, max(manager.Settings.IncomingQueueSize, 1))
+
+	return client
+}
+
+// close marks the client as done, safe to call multiple times
+func (client *Client) close() {
+	client.closeOnce.Do(func() {
+		close(client.done)
+	})
+}
+
+// closeWithReason sends a close frame with the reason and removes the client from the manager
+func (client *Client) closeWithReason(code int, reason string) {
+	closeMessage := websocket.FormatCloseMessage(code, reason)
+	if err := client.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+		log.Printf("error sending close message to user %v : error - %v", client.UserId, err)
+	}
+	client.ConnectionManager.RemoveClient(client)
 }
 
 func (client *Client) routeIncomingEvent(event Event) error {
 	fmt.Println(event)
-	if _, ok := client.ConnectionManager.IncomingHandlers[event.Type]; !ok {
+	handler, ok := client.ConnectionManager.builtinHandlers[event.Type]
+	if !ok {
+		handler, ok = client.ConnectionManager.IncomingHandlers[event.Type]
+	}
+	if !ok {
 		log.Print("Invalid Event type")
 		return errors.New("invalid event type")
 	}
-	return client.ConnectionManager.IncomingHandlers[event.Type](event, client)
+	// acks, refresh tokens and schema validation run inside the middlewares so they are rate limited and recovered as well
+	handler = client.ConnectionManager.validatePayload(handler)
+	return Chain(handler, client.ConnectionManager.middlewares...)(event, client)
 }
 
 func (client *Client) ReadMessage() {
@@ -35,12 +100,9 @@ func (client *Client) ReadMessage() {
 		client.ConnectionManager.RemoveClient(client)
 	}()
 
-	// Set Max Size of Messages in Bytes
-	client.Conn.SetReadLimit(512)
-
 	// Configure Wait time for Pong response, use Current time + pongWait
 	// This has to be done here to set the first initial timer.
-	if err := client.Conn.SetReadDeadline(time.Now().Add(PONG_INTERVAL * time.Second)); err != nil {
+	if err := client.Conn.SetReadDeadline(time.Now().Add(client.ConnectionManager.Settings.PongWait)); err != nil {
 		log.Printf("Error setting Read Deadline %v", err)
 		return
 	}
@@ -52,7 +114,7 @@ func (client *Client) ReadMessage() {
 	for {
 		// ReadMessage is used to read the next message in queue
 		// in the connection
-		messageType, payload, err := client.Conn.ReadMessage()
+		messageType, payload, tooLarge, err := client.readFrame()
 
 		if err != nil {
 			// If Connection is closed, we will Recieve an error here
@@ -64,10 +126,21 @@ func (client *Client) ReadMessage() {
 			break // Break the loop to close Conn & Cleanup
 		}
 
+		// the rest of the frame is never read, the client learns why before the socket is closed
+		if tooLarge {
+			limit := client.ConnectionManager.Settings.ReadLimit
+			client.failAndClose(ErrorEvent{
+				Code:    ErrorFrameTooLarge,
+				Message: fmt.Sprintf("frames are limited to %v bytes", limit),
+				Limit:   limit,
+			}, websocket.CloseMessageTooBig)
+			break
+		}
+
 		// chat Data recieved
-		if messageType == websocket.TextMessage {
-			var event Event
-			if err := json.Unmarshal(payload, &event); err != nil {
+		if messageType == websocket.TextMessage || messageType == websocket.BinaryMessage {
+			event, err := client.decodeFrame(messageType, payload)
+			if err != nil {
 				log.Printf("Could not read the payload %v", err)
 				client.SendAckToClient(Acknowledge{
 					ReceiverID: client.UserId,
@@ -76,8 +149,16 @@ func (client *Client) ReadMessage() {
 					Message:    "failed unmarshal to event, check input json",
 					AckTime:    time.Now().Unix(),
 				}, "")
-			} else {
-				go client.routeIncomingEvent(event)
+			} else if limit, exceeded := client.exceedsEventLimit(event, len(payload)); exceeded {
+				client.failAndClose(ErrorEvent{
+					Code:      ErrorEventTooLarge,
+					Message:   fmt.Sprintf("%v events are limited to %v bytes", event.Type, limit),
+					EventType: event.Type,
+					Limit:     limit,
+				}, websocket.CloseMessageTooBig)
+				break
+			} else if !client.enqueueIncoming(event) {
+				break // client closed while waiting for the dispatcher
 			}
 		}
 	}
@@ -89,56 +170,61 @@ func (client *Client) WriteMessage() {
 		client.ConnectionManager.RemoveClient(client)
 	}()
 
-	tick := time.NewTicker(PING_INTERVAL)
+	tick := time.NewTicker(client.ConnectionManager.Settings.PingInterval)
 
 	for {
 		select {
-		case message, ok := <-client.Egress:
-			// Ok will be false Incase the Egress channel is closed
-			if !ok {
-				// Manager has closed this connection channel, so communicate that to frontend
-				if err := client.Conn.WriteMessage(websocket.CloseMessage, nil); err != nil {
-					// Log that the connection is closed and the reason
-					log.Println("connection closed: ", err)
+		case <-client.Egress.ready:
+			// everything queued so far is written in order
+			for _, message := range client.Egress.take() {
+				// Write data to the connection
+				if err := client.writeEvent(message); err != nil {
+					log.Println(err)
+					return
 				}
-				// Return to close the goroutine
-				return
-			}
-
-			data, err := json.Marshal(message)
-
-			if err != nil {
-				log.Printf("Error marshalling the message %v", err)
 			}
 
-			// Write data to the connection
-			if err := client.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
-				log.Println(err)
-			}
+		case request := <-client.closing:
+			client.writeClose(request)
+			return
 
 		case <-tick.C:
 			if err := client.Conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
 				log.Printf("Error sending ping to client %v", err)
 				return
 			}
+
+		case <-client.done:
+			return
 		}
 
 	}
 }
 
 func (client *Client) PongHandler(pong string) error {
-	if err := client.Conn.SetReadDeadline(time.Now().Add(PONG_INTERVAL * time.Second)); err != nil {
+	if err := client.Conn.SetReadDeadline(time.Now().Add(client.ConnectionManager.Settings.PongWait)); err != nil {
 		log.Printf("Error setting Read Deadline %v", err)
 		return err
 	}
 	return nil
 }
 
-func (client *Client) SendMessageToClient(messagePayload models.OutgoingChatPayload, retryCount ...uint) {
-	payload, err := json.Marshal(messagePayload)
+// push queues the event for the writer without blocking, it gives up once the client is closed
+func (client *Client) push(event Event) bool {
+	select {
+	case <-client.done:
+		return false
+	default:
+	}
+	return client.Egress.push(event)
+}
+
+// sendTracked stores the event in the outbox before sending so it is retried until acknowledged
+func (client *Client) sendTracked(eventType EventType, data any, retryCount ...uint) {
+	payload, err := json.Marshal(data)
 
 	if err != nil {
-		log.Printf("Error marshalling outgoing message payload %v", err)
+		log.Printf("Error marshalling outgoing payload for event type %v : error - %v", eventType, err)
 		return
 	}
 
@@ -148,59 +234,59 @@ func (client *Client) SendMessageToClient(messagePayload models.OutgoingChatPayl
 	}
 
 	event := Event{
-		Type:    EventOutgoingChatMessage,
+		Type:    eventType,
 		Id:      "",
 		Payload: payload,
 		Retry:   retry,
 	}
 
-	client.Egress <- event
+	client.Outbox.track(&event)
+	client.push(event)
+}
+
+func (client *Client) SendMessageToClient(messagePayload models.OutgoingChatPayload, retryCount ...uint) {
+	client.sendTracked(EventOutgoingChatMessage, messagePayload, retryCount...)
 }
 
 func (client *Client) SendReadUpdateToClient(readUpdatePayload OutgoingReadUpdate, retryCount ...uint) {
-	payload, err := json.Marshal(readUpdatePayload)
+	client.sendTracked(EventOutgoingReadUpdate, readUpdatePayload, retryCount...)
+}
+
+func (client *Client) SendDeliveryUpdateToClient(outgoingDeliveryUpdate OutgoingDeliveredUpdate, retryCount ...uint) {
+	client.sendTracked(EventOutgoingDeliveredUpdate, outgoingDeliveryUpdate, retryCount...)
+}
+
+func (client *Client) sendFailedRetry(failedRetry FailedMessageRetry) {
+	payload, err := json.Marshal(failedRetry)
 
 	if err != nil {
-		log.Printf("Error marshalling outgoing read update payload %v", err)
+		log.Printf("Error marshalling failed retry payload %v", err)
 		return
 	}
 
-	var retry uint = 0
-	if len(retryCount) > 0 {
-		retry = retryCount[0]
-	}
-
-	event := Event{
-		Type:    EventOutgoingReadUpdate,
+	client.push(Event{
+		Type:    EventFailedMessageRetry,
 		Payload: payload,
 		Id:      "",
-		Retry:   retry,
-	}
-
-	client.Egress <- event
+		Retry:   0,
+	})
 }
 
-func (client *Client) SendDeliveryUpdateToClient(outgoingDeliveryUpdate OutgoingDeliveredUpdate, retryCount ...uint) {
-	payload, err := json.Marshal(outgoingDeliveryUpdate)
+// SendSyncResponseToClient answers a sync request, the response carries the id of the request
+func (client *Client) SendSyncResponseToClient(syncResponse models.SyncResponse, id string) {
+	payload, err := json.Marshal(syncResponse)
 
 	if err != nil {
-		log.Printf("Error marshalling outgoing delivery update payload %v", err)
+		log.Printf("Error marshalling sync response payload %v", err)
 		return
 	}
 
-	var retry uint = 0
-	if len(retryCount) > 0 {
-		retry = retryCount[0]
-	}
-
-	event := Event{
-		Type:    EventOutgoingDeliveredUpdate,
+	client.push(Event{
+		Type:    EventOutgoingSyncResponse,
 		Payload: payload,
-		Id:      "",
-		Retry:   retry,
-	}
-
-	client.Egress <- event
+		Id:      id,
+		Retry:   0,
+	})
 }
 
 func (client *Client) SendAckToClient(ack Acknowledge, id string) {
@@ -218,5 +304,21 @@ func (client *Client) SendAckToClient(ack Acknowledge, id string) {
 		Retry:   0,
 	}
 
-	client.Egress <- event
+	client.push(event)
+}
+
+// ackAndClose sends the ack followed by a close frame, unlike SendAckToClient and closeWithReason the
+// ack is written before the socket closes
+func (client *Client) ackAndClose(ack Acknowledge, id string, code int, reason string) {
+	payload, err := json.Marshal(ack)
+	if err != nil {
+		log.Printf("Error marshalling outgoing message payload %v", err)
+	}
+
+	client.sendAndClose(Event{
+		Type:    AckEvent,
+		Payload: payload,
+		Id:      id,
+		Retry:   0,
+	}, code, reason)
 }
@@ -0,0 +1,262 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Broker carries messages between server instances, every instance subscribes to its own node id
+type Broker interface {
+	Publish(ctx context.Context, nodeId string, payload []byte) error
+	Subscribe(ctx context.Context, nodeId string, handler func(payload []byte)) error
+}
+
+// PresenceRegistry keeps track of which instances hold sockets for a user
+type PresenceRegistry interface {
+	Register(ctx context.Context, userId string, nodeId string) error
+	Unregister(ctx context.Context, userId string, nodeId string) error
+	NodesForUser(ctx context.Context, userId string) ([]string, error)
+}
+
+type ClusterMessageKind string
+
+const (
+	ClusterDeliverEvent     ClusterMessageKind = "deliver_event"
+	ClusterDisconnectDevice ClusterMessageKind = "disconnect_device"
+	ClusterPublishTopic     ClusterMessageKind = "publish_topic"
+	ClusterCloseSession     ClusterMessageKind = "close_session"
+)
+
+// every instance also subscribes to this node id, messages published to it reach the whole cluster
+const CLUSTER_BROADCAST_NODE = "all"
+
+// ClusterMessage is what one instance sends to another through the broker
+type ClusterMessage struct {
+	Kind      ClusterMessageKind `json:"kind"`
+	FromNode  string             `json:"from_node"`
+	UserId    string             `json:"user_id"`
+	DeviceId  string             `json:"device_id,omitempty"`
+	Reason    string             `json:"reason,omitempty"`
+	Topic     string             `json:"topic,omitempty"`
+	SessionId string             `json:"session_id,omitempty"`
+	Event     Event              `json:"event"`
+}
+
+// EnableCluster makes the manager register its users in the presence registry and forward
+// events for users held by other instances through the broker
+func (manager *ConnectionManager) EnableCluster(ctx context.Context, nodeId string, broker Broker, presence PresenceRegistry) error {
+	if nodeId == "" || broker == nil || presence == nil {
+		return errors.New("node id, broker and presence registry are required for cluster mode")
+	}
+	if nodeId == CLUSTER_BROADCAST_NODE {
+		return fmt.Errorf("node id %v is reserved", CLUSTER_BROADCAST_NODE)
+	}
+
+	manager.NodeId = nodeId
+	manager.Broker = broker
+	manager.Presence = presence
+
+	if err := broker.Subscribe(ctx, CLUSTER_BROADCAST_NODE, manager.handleClusterMessage); err != nil {
+		return err
+	}
+	return broker.Subscribe(ctx, nodeId, manager.handleClusterMessage)
+}
+
+func (manager *ConnectionManager) IsClusterEnabled() bool {
+	return manager.Broker != nil
+}
+
+func (manager *ConnectionManager) registerPresence(userId string) {
+	if !manager.IsClusterEnabled() {
+		return
+	}
+
+	if err := manager.Presence.Register(context.Background(), userId, manager.NodeId); err != nil {
+		log.Printf("error registering presence of user %v on node %v : error - %v", userId, manager.NodeId, err)
+	}
+}
+
+func (manager *ConnectionManager) unregisterPresence(userId string) {
+	if !manager.IsClusterEnabled() {
+		return
+	}
+
+	if err := manager.Presence.Unregister(context.Background(), userId, manager.NodeId); err != nil {
+		log.Printf("error removing presence of user %v on node %v : error - %v", userId, manager.NodeId, err)
+	}
+}
+
+// connectedOnOtherNodes returns true if another instance holds sockets for the user, presence must be
+// registered or unregistered on this instance first
+func (manager *ConnectionManager) connectedOnOtherNodes(userId string) bool {
+	if !manager.IsClusterEnabled() {
+		return false
+	}
+
+	nodes, err := manager.Presence.NodesForUser(context.Background(), userId)
+	if err != nil {
+		log.Printf("error getting nodes of user %v : error - %v", userId, err)
+		return false
+	}
+
+	for _, nodeId := range nodes {
+		if nodeId != manager.NodeId {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardToNodes sends the message to every other instance holding sockets for the user
+func (manager *ConnectionManager) forwardToNodes(message ClusterMessage) {
+	if !manager.IsClusterEnabled() {
+		return
+	}
+
+	nodes, err := manager.Presence.NodesForUser(context.Background(), message.UserId)
+	if err != nil {
+		log.Printf("error getting nodes of user %v : error - %v", message.UserId, err)
+		return
+	}
+
+	message.FromNode = manager.NodeId
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("error marshalling cluster message %v", err)
+		return
+	}
+
+	for _, nodeId := range nodes {
+		if nodeId == manager.NodeId {
+			continue
+		}
+
+		if err := manager.Broker.Publish(context.Background(), nodeId, payload); err != nil {
+			log.Printf("error publishing to node %v : error - %v", nodeId, err)
+		}
+	}
+}
+
+// broadcastToNodes sends the message to every other instance
+func (manager *ConnectionManager) broadcastToNodes(message ClusterMessage) {
+	if !manager.IsClusterEnabled() {
+		return
+	}
+
+	message.FromNode = manager.NodeId
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("error marshalling cluster message %v", err)
+		return
+	}
+
+	if err := manager.Broker.Publish(context.Background(), CLUSTER_BROADCAST_NODE, payload); err != nil {
+		log.Printf("error broadcasting to cluster : error - %v", err)
+	}
+}
+
+// handleClusterMessage only ever acts on local clients so messages are never forwarded twice
+func (manager *ConnectionManager) handleClusterMessage(payload []byte) {
+	var message ClusterMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		log.Printf("error unmarshalling cluster message %v", err)
+		return
+	}
+
+	// broadcasts reach the sending instance as well
+	if message.FromNode == manager.NodeId {
+		return
+	}
+
+	switch message.Kind {
+	case ClusterPublishTopic:
+		manager.publishLocal(message.Topic, message.Event.Payload)
+	case ClusterDeliverEvent:
+		manager.sendToLocalClients(message.UserId, message.Event.Type, message.Event.Payload)
+	case ClusterCloseSession:
+		manager.closeLocalSession(message.UserId, message.SessionId, message.Reason)
+	case ClusterDisconnectDevice:
+		manager.disconnectLocalDevice(message.UserId, message.DeviceId, message.Reason)
+	default:
+		log.Printf("unknown cluster message kind %v from node %v", message.Kind, message.FromNode)
+	}
+}
+
+// InMemoryBroker connects manager instances living in the same process, used for tests and single node setups
+type InMemoryBroker struct {
+	subscribers map[string][]func(payload []byte)
+	sync.RWMutex
+}
+
+func NewInMemoryBroker() *InMemoryBroker {
+	return &InMemoryBroker{
+		subscribers: make(map[string][]func(payload []byte)),
+	}
+}
+
+func (broker *InMemoryBroker) Publish(ctx context.Context, nodeId string, payload []byte) error {
+	broker.RLock()
+	defer broker.RUnlock()
+
+	for _, handler := range broker.subscribers[nodeId] {
+		go handler(payload)
+	}
+	return nil
+}
+
+func (broker *InMemoryBroker) Subscribe(ctx context.Context, nodeId string, handler func(payload []byte)) error {
+	broker.Lock()
+	defer broker.Unlock()
+
+	broker.subscribers[nodeId] = append(broker.subscribers[nodeId], handler)
+	return nil
+}
+
+// InMemoryPresence is a presence registry shared by manager instances living in the same process
+type InMemoryPresence struct {
+	nodes map[string]map[string]bool
+	sync.RWMutex
+}
+
+func NewInMemoryPresence() *InMemoryPresence {
+	return &InMemoryPresence{
+		nodes: make(map[string]map[string]bool),
+	}
+}
+
+func (presence *InMemoryPresence) Register(ctx context.Context, userId string, nodeId string) error {
+	presence.Lock()
+	defer presence.Unlock()
+
+	if _, ok := presence.nodes[userId]; !ok {
+		presence.nodes[userId] = make(map[string]bool)
+	}
+	presence.nodes[userId][nodeId] = true
+	return nil
+}
+
+func (presence *InMemoryPresence) Unregister(ctx context.Context, userId string, nodeId string) error {
+	presence.Lock()
+	defer presence.Unlock()
+
+	delete(presence.nodes[userId], nodeId)
+	if len(presence.nodes[userId]) == 0 {
+		delete(presence.nodes, userId)
+	}
+	return nil
+}
+
+func (presence *InMemoryPresence) NodesForUser(ctx context.Context, userId string) ([]string, error) {
+	presence.RLock()
+	defer presence.RUnlock()
+
+	nodes := make([]string, 0, len(presence.nodes[userId]))
+	for nodeId := range presence.nodes[userId] {
+		nodes = append(nodes, nodeId)
+	}
+	return nodes, nil
+}
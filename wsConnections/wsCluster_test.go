@@ -0,0 +1,169 @@
+package websockets
+
+import (
+	"encoding/json"
+	"g_chat/models"
+	"testing"
+	"time"
+)
+
+// newTestCluster returns managers sharing an in-memory broker and presence registry
+func newTestCluster(t *testing.T, nodeIds ...string) []*ConnectionManager {
+	t.Helper()
+	broker := NewInMemoryBroker()
+	presence := NewInMemoryPresence()
+
+	managers := make([]*ConnectionManager, len(nodeIds))
+	for i, nodeId := range nodeIds {
+		manager := newTestManager(t)
+		if err := manager.EnableCluster(manager.Context(), nodeId, broker, presence); err != nil {
+			t.Fatalf("enabling cluster on %v: %v", nodeId, err)
+		}
+		managers[i] = manager
+	}
+	return managers
+}
+
+func TestEnableClusterRejectsReservedNode(t *testing.T) {
+	manager := newTestManager(t)
+	if err := manager.EnableCluster(manager.Context(), CLUSTER_BROADCAST_NODE, NewInMemoryBroker(), NewInMemoryPresence()); err == nil {
+		t.Fatalf("expected node id %v to be rejected", CLUSTER_BROADCAST_NODE)
+	}
+}
+
+func TestDeliverToUserOnOtherNodes(t *testing.T) {
+	tests := []struct {
+		name string
+		// nodes holding a socket of the receiver, the message is always sent from node-a
+		receiverNodes []int
+	}{
+		{name: "other node", receiverNodes: []int{1}},
+		{name: "sending node only", receiverNodes: []int{0}},
+		{name: "every node", receiverNodes: []int{0, 1, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			managers := newTestCluster(t, "node-a", "node-b", "node-c")
+
+			clients := make(map[int]*Client)
+			for _, node := range test.receiverNodes {
+				client := newTestClient(t, managers[node], "receiver")
+				managers[node].addClient(client.UserId, client)
+				clients[node] = client
+			}
+
+			managers[0].PerformSendMessageToUserWS(models.OutgoingChatPayload{
+				ID:          "message-1",
+				MessageBody: "hello",
+				ReceiverId:  "receiver",
+			})
+
+			for node, client := range clients {
+				event := nextEvent(t, client)
+				if event.Type != EventOutgoingChatMessage {
+					t.Fatalf("node %v: expected chat message, got %v", node, event.Type)
+				}
+
+				var payload models.OutgoingChatPayload
+				if err := json.Unmarshal(event.Payload, &payload); err != nil {
+					t.Fatalf("node %v: unmarshalling payload: %v", node, err)
+				}
+				if payload.ID != "message-1" {
+					t.Fatalf("node %v: expected message-1, got %v", node, payload.ID)
+				}
+
+				// every node delivers only to its own sockets so nothing arrives twice
+				expectNoEvent(t, client)
+			}
+		})
+	}
+}
+
+func TestPublishReachesEveryNodeOnce(t *testing.T) {
+	managers := newTestCluster(t, "node-a", "node-b")
+	topic := Topic("event", "event-1")
+
+	var clients []*Client
+	for _, manager := range managers {
+		client := newTestClient(t, manager, "subscriber-"+manager.NodeId)
+		if err := manager.Topics.subscribe(client, topic); err != nil {
+			t.Fatalf("subscribing on %v: %v", manager.NodeId, err)
+		}
+		clients = append(clients, client)
+	}
+
+	managers[0].Publish(topic, "updated", map[string]string{"id": "event-1"})
+
+	for _, client := range clients {
+		if event := nextEvent(t, client); event.Type != EventOutgoingTopicMessage {
+			t.Fatalf("user %v: expected topic message, got %v", client.UserId, event.Type)
+		}
+		// the broadcast also reaches the publishing node, it must not deliver it again
+		expectNoEvent(t, client)
+	}
+}
+
+func TestDisconnectDeviceOnOtherNode(t *testing.T) {
+	managers := newTestCluster(t, "node-a", "node-b")
+
+	client := newTestClient(t, managers[1], "user")
+	managers[1].addClient(client.UserId, client)
+
+	managers[0].DisconnectDevice(client.UserId, client.DeviceId, "device revoked")
+
+	select {
+	case <-client.done:
+	case <-time.After(time.Second):
+		t.Fatal("device was not disconnected on the node holding it")
+	}
+
+	if devices := managers[1].ConnectedDevices(client.UserId); devices[client.DeviceId] {
+		t.Fatal("device still connected after disconnect")
+	}
+}
+
+func TestPresenceChangesOnlyOnFirstAndLastNode(t *testing.T) {
+	managers := newTestCluster(t, "node-a", "node-b")
+
+	watcher := newTestClient(t, managers[0], "watcher")
+	if err := managers[0].Topics.subscribe(watcher, Topic(TopicPresence, "user")); err != nil {
+		t.Fatalf("subscribing: %v", err)
+	}
+
+	onNodeA := newTestClient(t, managers[0], "user")
+	onNodeB := newTestClient(t, managers[1], "user")
+
+	steps := []struct {
+		name    string
+		run     func()
+		publish bool
+		want    UserOnlineStatus
+	}{
+		{name: "first socket", run: func() { managers[0].addClient("user", onNodeA) }, publish: true, want: Online},
+		{name: "socket on another node", run: func() { managers[1].addClient("user", onNodeB) }},
+		{name: "other node still connected", run: func() { managers[1].RemoveClient(onNodeB) }},
+		{name: "last socket", run: func() { managers[0].RemoveClient(onNodeA) }, publish: true, want: Offline},
+	}
+
+	for _, step := range steps {
+		step.run()
+
+		if !step.publish {
+			expectNoEvent(t, watcher)
+			continue
+		}
+
+		var message TopicMessage
+		if err := json.Unmarshal(nextEvent(t, watcher).Payload, &message); err != nil {
+			t.Fatalf("%v: unmarshalling topic message: %v", step.name, err)
+		}
+		var update PresenceUpdate
+		if err := json.Unmarshal(message.Data, &update); err != nil {
+			t.Fatalf("%v: unmarshalling presence: %v", step.name, err)
+		}
+		if update.Status != step.want {
+			t.Fatalf("%v: status = %v, want %v", step.name, update.Status, step.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package websockets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// subprotocols clients can ask for, clients without a subprotocol get JSON
+const (
+	SubprotocolJSON     = "json.v1"
+	SubprotocolMsgpack  = "msgpack.v1"
+	SubprotocolProtobuf = "protobuf.v1"
+)
+
+// Codec turns events into frames of one subprotocol
+type Codec interface {
+	Subprotocol() string
+	// websocket.TextMessage or websocket.BinaryMessage
+	FrameType() int
+	Encode(event Event) ([]byte, error)
+	Decode(data []byte) (Event, error)
+}
+
+var codecs = map[string]Codec{
+	SubprotocolJSON:     jsonCodec{},
+	SubprotocolMsgpack:  newMsgpackCodec(),
+	SubprotocolProtobuf: protobufCodec{},
+}
+
+// when a client offers several subprotocols the most compact one wins
+var subprotocolPreference = []string{SubprotocolMsgpack, SubprotocolProtobuf, SubprotocolJSON}
+
+// codecFor returns the codec of the negotiated subprotocol, JSON when none was negotiated
+func codecFor(subprotocol string) Codec {
+	if c, ok := codecs[subprotocol]; ok {
+		return c
+	}
+	return codecs[SubprotocolJSON]
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return SubprotocolJSON }
+func (jsonCodec) FrameType() int      { return websocket.TextMessage }
+
+func (jsonCodec) Encode(event Event) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func (jsonCodec) Decode(data []byte) (Event, error) {
+	var event Event
+	err := json.Unmarshal(data, &event)
+	return event, err
+}
+
+// msgpackCodec sends the payload as a native msgpack value, handlers keep working with JSON payloads so
+// it is converted on the way in and out
+type msgpackCodec struct {
+	handle *codec.MsgpackHandle
+}
+
+type msgpackEvent struct {
+	Type    EventType `codec:"type"`
+	Id      string    `codec:"id"`
+	Payload any       `codec:"payload"`
+	Retry   uint      `codec:"retry"`
+}
+
+func newMsgpackCodec() msgpackCodec {
+	handle := &codec.MsgpackHandle{}
+	handle.MapType = reflect.TypeOf(map[string]any(nil))
+	handle.RawToString = true
+	handle.WriteExt = true
+	return msgpackCodec{handle: handle}
+}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgpack }
+func (msgpackCodec) FrameType() int      { return websocket.BinaryMessage }
+
+func (c msgpackCodec) Encode(event Event) ([]byte, error) {
+	out := msgpackEvent{
+		Type:  event.Type,
+		Id:    event.Id,
+		Retry: event.Retry,
+	}
+	if len(event.Payload) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(event.Payload))
+		decoder.UseNumber()
+		var payload any
+		if err := decoder.Decode(&payload); err != nil {
+			return nil, err
+		}
+		out.Payload = msgpackNumbers(payload)
+	}
+
+	var data []byte
+	err := codec.NewEncoderBytes(&data, c.handle).Encode(out)
+	return data, err
+}
+
+// msgpackNumbers turns JSON numbers into msgpack integers where they fit, decoding them as float64 would
+// round nanosecond timestamps
+func msgpackNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = msgpackNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = msgpackNumbers(item)
+		}
+	}
+	return value
+}
+
+func (c msgpackCodec) Decode(data []byte) (Event, error) {
+	var in msgpackEvent
+	if err := codec.NewDecoderBytes(data, c.handle).Decode(&in); err != nil {
+		return Event{}, err
+	}
+
+	event := Event{
+		Type:  in.Type,
+		Id:    in.Id,
+		Retry: in.Retry,
+	}
+	if in.Payload != nil {
+		payload, err := json.Marshal(in.Payload)
+		if err != nil {
+			return Event{}, err
+		}
+		event.Payload = payload
+	}
+	return event, nil
+}
+
+/*
+protobufCodec writes Event with the wire format of the message below, payload keeps the JSON bytes
+
+	message Event {
+	  uint64 type = 1;
+	  string id = 2;
+	  bytes payload = 3;
+	  uint64 retry = 4;
+	}
+*/
+type protobufCodec struct{}
+
+const (
+	protoFieldType    protowire.Number = 1
+	protoFieldId      protowire.Number = 2
+	protoFieldPayload protowire.Number = 3
+	protoFieldRetry   protowire.Number = 4
+)
+
+func (protobufCodec) Subprotocol() string { return SubprotocolProtobuf }
+func (protobufCodec) FrameType() int      { return websocket.BinaryMessage }
+
+func (protobufCodec) Encode(event Event) ([]byte, error) {
+	var data []byte
+	if event.Type != 0 {
+		data = protowire.AppendTag(data, protoFieldType, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(event.Type))
+	}
+	if event.Id != "" {
+		data = protowire.AppendTag(data, protoFieldId, protowire.BytesType)
+		data = protowire.AppendString(data, event.Id)
+	}
+	if len(event.Payload) > 0 {
+		data = protowire.AppendTag(data, protoFieldPayload, protowire.BytesType)
+		data = protowire.AppendBytes(data, event.Payload)
+	}
+	if event.Retry != 0 {
+		data = protowire.AppendTag(data, protoFieldRetry, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(event.Retry))
+	}
+	return data, nil
+}
+
+func (protobufCodec) Decode(data []byte) (Event, error) {
+	var event Event
+	for len(data) > 0 {
+		number, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return Event{}, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		switch {
+		case number == protoFieldType && wireType == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return Event{}, protowire.ParseError(n)
+			}
+			event.Type = EventType(value)
+			data = data[n:]
+
+		case number == protoFieldId && wireType == protowire.BytesType:
+			value, n := protowire.ConsumeString(data)
+			if n < 0 {
+				return Event{}, protowire.ParseError(n)
+			}
+			event.Id = value
+			data = data[n:]
+
+		case number == protoFieldPayload && wireType == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return Event{}, protowire.ParseError(n)
+			}
+			event.Payload = append(json.RawMessage(nil), value...)
+			data = data[n:]
+
+		case number == protoFieldRetry && wireType == protowire.VarintType:
+			value, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return Event{}, protowire.ParseError(n)
+			}
+			event.Retry = uint(value)
+			data = data[n:]
+
+		default:
+			// unknown fields are skipped like any protobuf decoder would
+			n := protowire.ConsumeFieldValue(number, wireType, data)
+			if n < 0 {
+				return Event{}, protowire.ParseError(n)
+			}
+			data = data[n:]
+		}
+	}
+
+	if len(event.Payload) > 0 && !json.Valid(event.Payload) {
+		return Event{}, errors.New("payload is not valid JSON")
+	}
+	return event, nil
+}
+
+// decodeFrame decodes a frame read from the socket, frames of the wrong type for the codec are rejected
+func (client *Client) decodeFrame(frameType int, data []byte) (Event, error) {
+	if frameType != client.codec.FrameType() {
+		return Event{}, fmt.Errorf("unexpected frame type %v for subprotocol %v", frameType, client.codec.Subprotocol())
+	}
+	return client.codec.Decode(data)
+}
@@ -0,0 +1,138 @@
+package websockets
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// jsonEqual compares payloads by value with exact numbers, msgpack re-encodes them so key order and spacing
+// may change and the JSON codec decodes a missing payload as null
+func jsonEqual(t *testing.T, a json.RawMessage, b json.RawMessage) bool {
+	t.Helper()
+	if string(a) == "null" {
+		a = nil
+	}
+	if string(b) == "null" {
+		b = nil
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+
+	var left, right any
+	for _, decode := range []struct {
+		data   json.RawMessage
+		target *any
+	}{{a, &left}, {b, &right}} {
+		decoder := json.NewDecoder(bytes.NewReader(decode.data))
+		decoder.UseNumber()
+		if err := decoder.Decode(decode.target); err != nil {
+			t.Fatalf("unmarshalling %s: %v", decode.data, err)
+		}
+	}
+	return reflect.DeepEqual(left, right)
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	events := []struct {
+		name  string
+		event Event
+	}{
+		{name: "full event", event: Event{Type: EventOutgoingChatMessage, Id: "event-1", Payload: json.RawMessage(`{"id":"m1","body":"hello","sent_at":1700000000000000000,"tags":["a","b"],"nested":{"ok":true}}`), Retry: 3}},
+		{name: "empty payload", event: Event{Type: AckEvent, Id: "event-2"}},
+		{name: "payload only", event: Event{Type: EventIncomingHello, Payload: json.RawMessage(`{"protocol_version":1}`)}},
+		{name: "nanosecond timestamps", event: Event{Type: EventOutgoingChatMessage, Payload: json.RawMessage(`{"sent_at":1700000000123456789,"max":18446744073709551615,"min":-9223372036854775808,"ratio":0.5}`)}},
+		{name: "unicode", event: Event{Type: EventOutgoingChatMessage, Id: "événement", Payload: json.RawMessage(`{"body":"héllo 👋"}`)}},
+		{name: "zero event", event: Event{}},
+	}
+
+	for subprotocol, c := range codecs {
+		for _, tt := range events {
+			t.Run(subprotocol+"/"+tt.name, func(t *testing.T) {
+				data, err := c.Encode(tt.event)
+				if err != nil {
+					t.Fatalf("encoding: %v", err)
+				}
+
+				decoded, err := c.Decode(data)
+				if err != nil {
+					t.Fatalf("decoding: %v", err)
+				}
+
+				if decoded.Type != tt.event.Type || decoded.Id != tt.event.Id || decoded.Retry != tt.event.Retry {
+					t.Fatalf("decoded %+v, want %+v", decoded, tt.event)
+				}
+				if !jsonEqual(t, decoded.Payload, tt.event.Payload) {
+					t.Fatalf("decoded payload %s, want %s", decoded.Payload, tt.event.Payload)
+				}
+			})
+		}
+	}
+}
+
+func TestCodecsRejectMalformedFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec Codec
+		data  []byte
+	}{
+		{name: "json garbage", codec: codecs[SubprotocolJSON], data: []byte(`{"type":`)},
+		{name: "json unknown event name", codec: codecs[SubprotocolJSON], data: []byte(`{"type":"not.an.event"}`)},
+		{name: "msgpack garbage", codec: codecs[SubprotocolMsgpack], data: []byte{0xc1}},
+		{name: "protobuf truncated", codec: codecs[SubprotocolProtobuf], data: protowire.AppendTag(nil, protoFieldId, protowire.BytesType)},
+		{name: "protobuf payload not json", codec: codecs[SubprotocolProtobuf], data: protowire.AppendBytes(protowire.AppendTag(nil, protoFieldPayload, protowire.BytesType), []byte("not json"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.codec.Decode(tt.data); err == nil {
+				t.Fatalf("expected an error decoding %v", tt.data)
+			}
+		})
+	}
+}
+
+func TestProtobufSkipsUnknownFields(t *testing.T) {
+	data, err := codecs[SubprotocolProtobuf].Encode(Event{Type: EventIncomingChatMessage, Id: "event-1"})
+	if err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+	data = protowire.AppendTag(data, 15, protowire.BytesType)
+	data = protowire.AppendString(data, "added by a newer client")
+
+	event, err := codecs[SubprotocolProtobuf].Decode(data)
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if event.Type != EventIncomingChatMessage || event.Id != "event-1" {
+		t.Fatalf("decoded %+v", event)
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		subprotocol   string
+		wantProtocol  string
+		wantFrameType int
+	}{
+		{subprotocol: SubprotocolJSON, wantProtocol: SubprotocolJSON, wantFrameType: websocket.TextMessage},
+		{subprotocol: SubprotocolMsgpack, wantProtocol: SubprotocolMsgpack, wantFrameType: websocket.BinaryMessage},
+		{subprotocol: SubprotocolProtobuf, wantProtocol: SubprotocolProtobuf, wantFrameType: websocket.BinaryMessage},
+		{subprotocol: "", wantProtocol: SubprotocolJSON, wantFrameType: websocket.TextMessage},
+		{subprotocol: "unknown.v9", wantProtocol: SubprotocolJSON, wantFrameType: websocket.TextMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subprotocol, func(t *testing.T) {
+			c := codecFor(tt.subprotocol)
+			if c.Subprotocol() != tt.wantProtocol || c.FrameType() != tt.wantFrameType {
+				t.Fatalf("codec %v with frame type %v, want %v with %v", c.Subprotocol(), c.FrameType(), tt.wantProtocol, tt.wantFrameType)
+			}
+		})
+	}
+}
@@ -3,42 +3,105 @@ package websockets
 import (
 	"context"
 	"encoding/json"
+	"g_chat/middleware"
 	"g_chat/models"
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
-// checkOrigin will check origin and return true if its allowed
-func checkOrigin(r *http.Request) bool {
+// Settings are the websocket limits and timeouts of a manager, see DefaultSettings
+type Settings struct {
+	// a socket is closed when no pong arrives within PongWait, pings are sent every PingInterval
+	PongWait     time.Duration
+	PingInterval time.Duration
+	// largest frame read from a client, larger frames close the socket with an error event
+	ReadLimit int64
+	// frames of these event types are limited further, see DefaultEventSizeLimits
+	EventSizeLimits map[EventType]int64
+	ReadBufferSize  int
+	WriteBufferSize int
+	// permessage-deflate, frames smaller than CompressionThreshold bytes are sent uncompressed
+	EnableCompression    bool
+	CompressionLevel     int
+	CompressionThreshold int
+	// browser origins allowed to open a socket, shared with the CORS middleware
+	OriginPolicy *middleware.OriginPolicy
+	// receipts are sent right away when zero
+	ReceiptCoalesceWindow time.Duration
+	// open sockets are not revalidated when zero
+	TokenRevalidateInterval time.Duration
+	// events waiting to be written per socket, see SlowConsumerPolicy for what happens once it is full
+	SendQueueSize        int
+	SlowConsumerPolicy   SlowConsumerPolicy
+	SlowConsumerMaxDrops int
+	// events read from a socket waiting to be handled, reading pauses once it is full
+	IncomingQueueSize int
+	// handlers running at once across every socket
+	HandlerWorkers int
+	// sockets a user can hold on this instance, zero is unlimited, see ConnectionLimitStrategy. sockets on
+	// other instances of a cluster are not counted
+	MaxConnectionsPerUser   int
+	ConnectionLimitStrategy ConnectionLimitStrategy
+}
 
-	// Grab the request origin
-	origin := r.Header.Get("Origin")
+func DefaultSettings() Settings {
+	return Settings{
+		PongWait:                60 * time.Second,
+		PingInterval:            54 * time.Second,
+		ReadLimit:               DEFAULT_READ_LIMIT,
+		EventSizeLimits:         DefaultEventSizeLimits(),
+		EnableCompression:       true,
+		CompressionLevel:        DEFAULT_COMPRESSION_LEVEL,
+		CompressionThreshold:    DEFAULT_COMPRESSION_THRESHOLD,
+		ReadBufferSize:          1024,
+		WriteBufferSize:         1024,
+		ReceiptCoalesceWindow:   RECEIPT_COALESCE_WINDOW,
+		TokenRevalidateInterval: TOKEN_REVALIDATE_INTERVAL,
+		SendQueueSize:           DEFAULT_SEND_QUEUE_SIZE,
+		SlowConsumerPolicy:      SlowConsumerDropOldest,
+		SlowConsumerMaxDrops:    DEFAULT_SLOW_CONSUMER_MAX_DROPS,
+		IncomingQueueSize:       DEFAULT_INCOMING_QUEUE_SIZE,
+		HandlerWorkers:          DEFAULT_HANDLER_WORKERS,
+		MaxConnectionsPerUser:   DEFAULT_MAX_CONNECTIONS_PER_USER,
+		ConnectionLimitStrategy: ConnectionLimitEvictOldest,
+	}
+}
 
-	switch origin {
-	case "http://localhost:8080":
-		return true
-	default:
-		return true
+// Configure applies the settings, it must be called before the manager serves sockets
+func (manager *ConnectionManager) Configure(settings Settings) {
+	manager.Settings = settings
+	manager.upgrader = websocket.Upgrader{
+		CheckOrigin:     manager.checkOrigin,
+		Subprotocols:    subprotocolPreference,
+		ReadBufferSize:  settings.ReadBufferSize,
+		WriteBufferSize: settings.WriteBufferSize, // might use a pool
+		// compression is used only when the client offers permessage-deflate
+		EnableCompression: settings.EnableCompression,
 	}
+	manager.Receipts.window = settings.ReceiptCoalesceWindow
+	manager.workers = make(chan struct{}, max(settings.HandlerWorkers, 1))
 }
 
-var (
-	websocketUpgrader = websocket.Upgrader{
-		CheckOrigin:     checkOrigin,
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024, // might use a pool
+// checkOrigin will check origin and return true if its allowed. Requests without an origin come from
+// native clients (browsers always send one) and are allowed, without a policy only those are allowed.
+func (manager *ConnectionManager) checkOrigin(r *http.Request) bool {
+	// Grab the request origin
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
 	}
-)
 
-const (
-	PONG_INTERVAL = 30
-	PING_INTERVAL = 35
-)
+	if manager.Settings.OriginPolicy == nil {
+		return false
+	}
+	return manager.Settings.OriginPolicy.Allowed(origin)
+}
 
 type Event struct {
 	// Type is the message type sent
@@ -54,8 +117,42 @@ type Event struct {
 type ConnectionManager struct {
 	ConnectionMap    map[string][]*Client
 	IncomingHandlers map[EventType]EventHandler
-	OutgoingHandlers map[string]func(channel string, payload string) error
-	TicketManager    *Tickets
+	// methods clients can call with EventIncomingRPCRequest, see wsRPC.go
+	RPCMethods map[string]RPCHandler
+	// handlers of events the manager answers itself (rpc, subscriptions), they take precedence
+	builtinHandlers map[EventType]EventHandler
+	Topics          *TopicRegistry
+	// decides who may subscribe to a topic, subscriptions are rejected while nil
+	TopicAuthorizer TopicAuthorizer
+	// payloads are validated against the schema of their event type before they are handled
+	PayloadSchemas map[EventType]PayloadSchema
+	TicketManager  *Tickets
+	// called for tracked events which were never acknowledged by the client
+	UndeliveredHandler EventHandler
+	// checks the device belongs to the user and is not revoked before issuing a ticket
+	DeviceValidator func(userId string, deviceId string) (bool, error)
+	// returns ids of the sender's messages in the conversation between two send times, used to build receipt ranges
+	ReceiptRangeResolver func(conversationId string, senderId string, fromSentAt int64, toSentAt int64) ([]string, error)
+	Receipts             *ReceiptCoalescer
+	// verifies refresh tokens sent by clients and revalidates long lived sockets, nil disables both
+	TokenAuth TokenAuthenticator
+	// set only in cluster mode, see EnableCluster
+	NodeId   string
+	Broker   Broker
+	Presence PresenceRegistry
+	Settings Settings
+	upgrader websocket.Upgrader
+	metrics  queueMetrics
+	// cancelled by Shutdown, see Context
+	ctx          context.Context
+	cancel       context.CancelFunc
+	shuttingDown atomic.Bool
+	// goroutines of every client, see spawn
+	routines sync.WaitGroup
+	// limits handlers running at once, see wsDispatcher.go
+	workers chan struct{}
+	// run for every incoming event, see Use
+	middlewares []EventMiddleware
 	sync.RWMutex
 }
 
@@ -65,11 +162,26 @@ func (manager *ConnectionManager) SetupIncomingEventHandlers(handlers map[EventT
 
 func (manager *ConnectionManager) addClient(userId string, client *Client) {
 	manager.Lock()
-	defer manager.Unlock()
+	firstClient := len(manager.ConnectionMap[userId]) == 0
 	manager.ConnectionMap[userId] = append(manager.ConnectionMap[userId], client)
+	manager.Unlock()
+
+	if firstClient {
+		manager.registerPresence(userId)
+		manager.publishPresence(userId, Online)
+	}
 }
 
 func (manager *ConnectionManager) RemoveClient(client *Client) {
+	manager.Topics.removeClient(client)
+	if lastClient := manager.removeClient(client); lastClient {
+		manager.unregisterPresence(client.UserId)
+		manager.publishPresence(client.UserId, Offline)
+	}
+}
+
+// removeClient returns true when the removed client was the last one of the user
+func (manager *ConnectionManager) removeClient(client *Client) bool {
 	manager.Lock()
 	defer manager.Unlock()
 	indexToDelete := -1
@@ -81,17 +193,21 @@ func (manager *ConnectionManager) RemoveClient(client *Client) {
 		}
 	}
 
+	// sockets evicted by admitClient are no longer in the map but still have to be closed
+	client.close()
+	client.Conn.Close()
+
 	if indexToDelete >= 0 {
-		client.Conn.Close()
 		clients = append(clients[:indexToDelete], clients[indexToDelete+1:]...)
 		if len(clients) == 0 {
 			delete(manager.ConnectionMap, client.UserId)
-		} else {
-			manager.ConnectionMap[client.UserId] = clients
+			return true
 		}
+		manager.ConnectionMap[client.UserId] = clients
 	} else {
 		log.Println("client does not exist")
 	}
+	return false
 }
 
 var (
@@ -102,22 +218,76 @@ func GetConnectionManager() *ConnectionManager {
 	return myWSConnectionManager
 }
 
-// TODO - one client per user only (implement requests for disconnecting)
 func CreateConnectionManager(ctx context.Context) {
-	myWSConnectionManager = &ConnectionManager{
+	myWSConnectionManager = NewConnectionManager(ctx)
+}
+
+// NewConnectionManager creates a standalone manager, the server uses the one from CreateConnectionManager
+func NewConnectionManager(parent context.Context) *ConnectionManager {
+	ctx, cancel := context.WithCancel(parent)
+	manager := &ConnectionManager{
+		ctx:              ctx,
+		cancel:           cancel,
 		ConnectionMap:    make(map[string][]*Client),
 		IncomingHandlers: make(map[EventType]EventHandler),
-		OutgoingHandlers: make(map[string]func(channel string, payload string) error),
-		TicketManager:    CreateNewTicketsMap(ctx, time.Second*30),
+		RPCMethods:       make(map[string]RPCHandler),
+		TicketManager:    CreateNewTicketsMap(ctx, DEFAULT_TICKET_TTL),
+		Topics:           newTopicRegistry(),
+		PayloadSchemas:   builtinPayloadSchemas(),
+	}
+	manager.builtinHandlers = map[EventType]EventHandler{
+		AckEvent:                  handleAck,
+		EventIncomingRefreshToken: handleRefreshToken,
+		EventIncomingHello:        Decode(manager.handleHello),
+		EventIncomingRPCRequest:   manager.handleRPCRequest,
+		EventIncomingSubscribe:    Chain(Decode(manager.handleSubscribe), Acknowledged()),
+		EventIncomingUnsubscribe:  Chain(Decode(manager.handleUnsubscribe), Acknowledged()),
 	}
+	manager.Receipts = newReceiptCoalescer(manager, RECEIPT_COALESCE_WINDOW)
+	manager.Configure(DefaultSettings())
+
+	return manager
 }
 
 func (manager *ConnectionManager) CreateNewTicket(ctx *gin.Context) {
-	manager.TicketManager.generateTicket(ctx)
+	if manager.rejectWhileShuttingDown(ctx) {
+		return
+	}
+
+	deviceId := ctx.Query("deviceId")
+	if deviceId == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "deviceId is required",
+		})
+		return
+	}
+
+	if manager.DeviceValidator != nil {
+		valid, err := manager.DeviceValidator(middleware.UserId(ctx), deviceId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error validating device",
+			})
+			return
+		}
+
+		if !valid {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"message": "device not registered or revoked",
+			})
+			return
+		}
+	}
+
+	manager.TicketManager.generateTicket(ctx, deviceId)
 }
 
 func (manager *ConnectionManager) ServeWS(ctx *gin.Context) {
-	userId, valid := manager.TicketManager.validateTicket(ctx)
+	if manager.rejectWhileShuttingDown(ctx) {
+		return
+	}
+
+	ticket, valid := manager.TicketManager.validateTicket(ctx)
 	if !valid {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
 			"message": "unauthorized or request timeout",
@@ -125,62 +295,165 @@ func (manager *ConnectionManager) ServeWS(ctx *gin.Context) {
 		return
 	}
 
-	Conn, err := websocketUpgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if manager.rejectOverLimit(ctx, ticket.UserId) {
+		return
+	}
+
+	Conn, err := manager.upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	client := &Client{
-		Conn:              Conn,
-		UserId:            userId,
-		Egress:            make(chan Event),
-		ConnectionManager: manager,
+	if manager.Settings.EnableCompression {
+		if err := Conn.SetCompressionLevel(manager.Settings.CompressionLevel); err != nil {
+			log.Printf("invalid compression level %v : error - %v", manager.Settings.CompressionLevel, err)
+		}
 	}
 
-	manager.addClient(userId, client)
+	client := newClient(Conn, ticket, manager)
+	client.setToken(TokenInfo{
+		UserId:    ticket.UserId,
+		IssuedAt:  ticket.TokenIssuedAt,
+		ExpiresAt: ticket.TokenExpiresAt,
+	})
+
+	admitted, evicted := manager.admitClient(client)
+	if !admitted {
+		client.closeOverLimit()
+		return
+	}
+	manager.closeEvicted(evicted)
 
-	go client.ReadMessage()
-	go client.WriteMessage()
+	manager.spawn(client.ReadMessage)
+	manager.spawn(client.WriteMessage)
+	manager.spawn(client.dispatchIncoming)
+	manager.spawn(client.Outbox.run)
+	manager.spawn(client.watchToken)
 }
 
-func (manager *ConnectionManager) PerformOutgoingReadUpdateWS(outgoingReadUpdate OutgoingReadUpdate) {
-	userId := outgoingReadUpdate.ReceiverID
+// DisconnectDevice closes every socket opened by the given device of the user on every instance
+func (manager *ConnectionManager) DisconnectDevice(userId string, deviceId string, reason string) {
+	manager.disconnectLocalDevice(userId, deviceId, reason)
 
-	if _, ok := manager.ConnectionMap[userId]; !ok {
-		log.Printf("user not connected %v", userId)
-		return
-	}
+	manager.forwardToNodes(ClusterMessage{
+		Kind:     ClusterDisconnectDevice,
+		UserId:   userId,
+		DeviceId: deviceId,
+		Reason:   reason,
+	})
+}
 
+func (manager *ConnectionManager) disconnectLocalDevice(userId string, deviceId string, reason string) {
+	manager.RLock()
+	var toRemove []*Client
 	for _, client := range manager.ConnectionMap[userId] {
-		go client.SendReadUpdateToClient(outgoingReadUpdate)
+		if client.DeviceId == deviceId {
+			toRemove = append(toRemove, client)
+		}
+	}
+	manager.RUnlock()
+
+	for _, client := range toRemove {
+		client.closeWithReason(websocket.ClosePolicyViolation, reason)
 	}
 }
 
-func (manager *ConnectionManager) PerformOutgoingDeliveredUpdateWS(outgoingdeliveryUpdate OutgoingDeliveredUpdate) {
-	userId := outgoingdeliveryUpdate.ReceiverID
+// ConnectedDevices returns the ids of devices of the user which currently hold a socket on this instance
+func (manager *ConnectionManager) ConnectedDevices(userId string) map[string]bool {
+	manager.RLock()
+	defer manager.RUnlock()
 
-	if _, ok := manager.ConnectionMap[userId]; !ok {
-		log.Printf("user not connected %v", userId)
-		return
+	devices := make(map[string]bool)
+	for _, client := range manager.ConnectionMap[userId] {
+		devices[client.DeviceId] = true
 	}
+	return devices
+}
 
-	for _, client := range manager.ConnectionMap[userId] {
-		go client.SendDeliveryUpdateToClient(outgoingdeliveryUpdate)
+// sendToLocalClients sends a tracked event to every socket of the user held by this instance
+func (manager *ConnectionManager) sendToLocalClients(userId string, eventType EventType, payload json.RawMessage) bool {
+	manager.RLock()
+	clients := append([]*Client(nil), manager.ConnectionMap[userId]...)
+	manager.RUnlock()
+
+	for _, client := range clients {
+		client.sendTracked(eventType, payload)
 	}
+	return len(clients) > 0
 }
 
-func (manager *ConnectionManager) PerformSendMessageToUserWS(messagePayload models.OutgoingChatPayload) {
-	if _, ok := manager.ConnectionMap[messagePayload.ReceiverId]; !ok {
-		log.Printf("user not connected %v", messagePayload.ReceiverId)
+// deliverToUser sends the event to local sockets of the user and forwards it to other instances holding the user
+func (manager *ConnectionManager) deliverToUser(userId string, eventType EventType, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshalling outgoing payload for event type %v : error - %v", eventType, err)
 		return
 	}
 
-	for _, client := range manager.ConnectionMap[messagePayload.ReceiverId] {
-		go client.SendMessageToClient(messagePayload)
+	if !manager.sendToLocalClients(userId, eventType, payload) && !manager.IsClusterEnabled() {
+		log.Printf("user not connected %v", userId)
+		return
 	}
+
+	manager.forwardToNodes(ClusterMessage{
+		Kind:   ClusterDeliverEvent,
+		UserId: userId,
+		Event: Event{
+			Type:    eventType,
+			Payload: payload,
+		},
+	})
+}
+
+func (manager *ConnectionManager) PerformOutgoingReadUpdateWS(outgoingReadUpdate OutgoingReadUpdate) {
+	manager.deliverToUser(outgoingReadUpdate.ReceiverID, EventOutgoingReadUpdate, outgoingReadUpdate)
+}
+
+func (manager *ConnectionManager) PerformOutgoingDeliveredUpdateWS(outgoingdeliveryUpdate OutgoingDeliveredUpdate) {
+	manager.deliverToUser(outgoingdeliveryUpdate.ReceiverID, EventOutgoingDeliveredUpdate, outgoingdeliveryUpdate)
+}
+
+// QueueDeliveredUpdateWS lets the sender know a message was delivered, updates are batched per conversation
+func (manager *ConnectionManager) QueueDeliveredUpdateWS(senderId string, conversationId string, messageId string, sentAt int64) {
+	manager.Receipts.add(receiptKey{
+		kind:           ReceiptDelivered,
+		receiverId:     senderId,
+		conversationId: conversationId,
+	}, pendingReceipt{
+		messageId: messageId,
+		sentAt:    sentAt,
+	})
+}
+
+// QueueReadUpdateWS lets the sender know a message was read, updates are batched per conversation
+func (manager *ConnectionManager) QueueReadUpdateWS(senderId string, conversationId string, messageId string, sentAt int64) {
+	manager.Receipts.add(receiptKey{
+		kind:           ReceiptRead,
+		receiverId:     senderId,
+		conversationId: conversationId,
+	}, pendingReceipt{
+		messageId: messageId,
+		sentAt:    sentAt,
+	})
+}
+
+func (manager *ConnectionManager) PerformSendMessageToUserWS(messagePayload models.OutgoingChatPayload) {
+	manager.deliverToUser(messagePayload.ReceiverId, EventOutgoingChatMessage, messagePayload)
+}
+
+func (manager *ConnectionManager) SetupUndeliveredEventHandler(handler EventHandler) {
+	manager.UndeliveredHandler = handler
+}
+
+func (manager *ConnectionManager) SetupReceiptRangeResolver(resolver func(conversationId string, senderId string, fromSentAt int64, toSentAt int64) ([]string, error)) {
+	manager.ReceiptRangeResolver = resolver
+}
+
+func (manager *ConnectionManager) SetupTokenAuthenticator(authenticator TokenAuthenticator) {
+	manager.TokenAuth = authenticator
 }
 
-func (manager *ConnectionManager) SetupOutgoingEventHandlers(handlers map[string]func(channel string, payload string) error) {
-	manager.OutgoingHandlers = handlers
+func (manager *ConnectionManager) SetupDeviceValidator(validator func(userId string, deviceId string) (bool, error)) {
+	manager.DeviceValidator = validator
 }
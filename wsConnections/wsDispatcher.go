@@ -0,0 +1,59 @@
+package websockets
+
+import (
+	"log"
+)
+
+const (
+	DEFAULT_INCOMING_QUEUE_SIZE = 64
+	DEFAULT_HANDLER_WORKERS     = 256
+)
+
+/*
+	Ordering of incoming events
+
+	every socket has a single dispatcher, events read from a socket are handled one at a time in the order
+	they arrived, so a chat message is always written before a read update sent after it on the same socket.
+	events from different sockets (including two devices of the same user) are handled concurrently and
+	have no ordering between them.
+
+	at most Settings.HandlerWorkers handlers run at once across all sockets. when the incoming queue of a
+	socket is full the reader stops reading from it until the dispatcher catches up.
+*/
+
+// enqueueIncoming blocks while the incoming queue is full, returns false once the client is closed
+func (client *Client) enqueueIncoming(event Event) bool {
+	select {
+	case client.incoming <- event:
+		return true
+	case <-client.done:
+		return false
+	}
+}
+
+// dispatchIncoming handles queued events in order until the client is closed
+func (client *Client) dispatchIncoming() {
+	for {
+		select {
+		case event := <-client.incoming:
+			client.handleWithWorker(event)
+		case <-client.done:
+			return
+		}
+	}
+}
+
+// handleWithWorker waits for a free worker slot of the manager before running the handler
+func (client *Client) handleWithWorker(event Event) {
+	workers := client.ConnectionManager.workers
+	select {
+	case workers <- struct{}{}:
+	case <-client.done:
+		return
+	}
+	defer func() { <-workers }()
+
+	if err := client.routeIncomingEvent(event); err != nil {
+		log.Printf("error handling event %v of type %v from user %v : error - %v", event.Id, event.Type, client.UserId, err)
+	}
+}
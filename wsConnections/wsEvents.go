@@ -1,44 +1,184 @@
 package websockets
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type EventHandler func(event Event, client *Client) error
 type EventType uint
 type NotificationType uint
 
+// event codes are part of the wire protocol, they are explicit so they never change once released. new
+// events take the next free code and get a name in eventNames
 const (
 	/*
 		when entire payload could not parsed
 	*/
-	Unknown EventType = iota
+	Unknown EventType = 0
 
 	/*
 		fired back to client for every event type received, payload's event type will signify the type of
-		event it acknowledges along with an ID to keep track on client side which event has been received
+		event it acknowledges along with an ID to keep track on client side which event has been received.
+		client sends the same event type with the ID of a server event to remove it from the outbox
 	*/
-	AckEvent
+	AckEvent EventType = 1
 
 	/*
 		events related to incoming messages, their delivery and seen status, note ack will be fired for
 		all the events
 	*/
-	EventIncomingChatMessage
-	EventIncomingDeliveredUpdate
-	EventIncomingReadUpdate
+	EventIncomingChatMessage     EventType = 2
+	EventIncomingDeliveredUpdate EventType = 3
+	EventIncomingReadUpdate      EventType = 4
 
-	EventOutgoingChatMessage
-	EventOutgoingReadUpdate
-	EventOutgoingDeliveredUpdate
+	EventOutgoingChatMessage     EventType = 5
+	EventOutgoingReadUpdate      EventType = 6
+	EventOutgoingDeliveredUpdate EventType = 7
 
 	// NOT IMPLEMENTED---------------------------------------------------------------------------------------------------------
-	EventIncomingUserStatusChange
-	EventNotifyFriendStatusChange
-	EventFailedMessageRetry
+	EventIncomingUserStatusChange EventType = 8
+	EventNotifyFriendStatusChange EventType = 9
+
+	/*
+		sent to client when events in the outbox ran out of retries, client should sync to get them
+	*/
+	EventFailedMessageRetry EventType = 10
+
+	/*
+		client sends its last seen cursor after (re)connecting, server responds with everything missed
+		after the cursor and a new cursor, client keeps requesting while has_more is set
+	*/
+	EventIncomingSyncRequest  EventType = 11
+	EventOutgoingSyncResponse EventType = 12
+
+	/*
+		delivered/read updates coalesced per conversation, payload carries ranges of message ids
+		instead of a single id, see ReceiptBatch
+	*/
+	EventOutgoingReadUpdateBatch      EventType = 13
+	EventOutgoingDeliveredUpdateBatch EventType = 14
+
+	/*
+		server asks for a new access token shortly before the current one expires, client answers with
+		the refresh token event. sockets whose token expired or was revoked are closed
+	*/
+	EventOutgoingTokenRefreshRequired EventType = 15
+	EventIncomingRefreshToken         EventType = 16
+
+	/*
+		request/response over the socket, the client sends a method with params and the event id, the server
+		answers with a response or an error event carrying the same id, see RPCRequest
+	*/
+	EventIncomingRPCRequest  EventType = 17
+	EventOutgoingRPCResponse EventType = 18
+	EventOutgoingRPCError    EventType = 19
+
+	/*
+		clients subscribe to topics (a conversation, an event, an LFG feed, a user's presence), everything
+		published to a topic is sent to its subscribers as a topic message, see TopicMessage
+	*/
+	EventIncomingSubscribe    EventType = 20
+	EventIncomingUnsubscribe  EventType = 21
+	EventOutgoingTopicMessage EventType = 22
+
+	/*
+		first event of a client, announces its protocol version and capabilities. the server answers with
+		its own hello or closes the socket when the version is not supported, see Hello
+	*/
+	EventIncomingHello EventType = 23
+	EventOutgoingHello EventType = 24
+
+	/*
+		sent right before the server closes the socket, e.g. when a frame is over the size limit, see ErrorEvent
+	*/
+	EventOutgoingError EventType = 25
+
+	/*
+		sent right before a session is closed because the user opened too many sockets or closed it from
+		another session, see SessionClosed
+	*/
+	EventOutgoingSessionClosed EventType = 26
+
+	/*
+		sent to every client before the server shuts down, followed by a close frame, see ServerRestarting
+	*/
+	EventOutgoingServerRestarting EventType = 27
+
 	// LFG feed (entirely in websockets)
 	// friends status(online/offline) and game playing
 )
 
+// stable string codes, clients may send these instead of the numeric code
+var eventNames = map[EventType]string{
+	Unknown:                           "unknown",
+	AckEvent:                          "ack",
+	EventIncomingChatMessage:          "chat.message.incoming",
+	EventIncomingDeliveredUpdate:      "chat.delivered.incoming",
+	EventIncomingReadUpdate:           "chat.read.incoming",
+	EventOutgoingChatMessage:          "chat.message.outgoing",
+	EventOutgoingReadUpdate:           "chat.read.outgoing",
+	EventOutgoingDeliveredUpdate:      "chat.delivered.outgoing",
+	EventIncomingUserStatusChange:     "user.status.incoming",
+	EventNotifyFriendStatusChange:     "friend.status.outgoing",
+	EventFailedMessageRetry:           "outbox.failed_retry",
+	EventIncomingSyncRequest:          "sync.request",
+	EventOutgoingSyncResponse:         "sync.response",
+	EventOutgoingReadUpdateBatch:      "chat.read.batch",
+	EventOutgoingDeliveredUpdateBatch: "chat.delivered.batch",
+	EventOutgoingTokenRefreshRequired: "token.refresh_required",
+	EventIncomingRefreshToken:         "token.refresh",
+	EventIncomingRPCRequest:           "rpc.request",
+	EventOutgoingRPCResponse:          "rpc.response",
+	EventOutgoingRPCError:             "rpc.error",
+	EventIncomingSubscribe:            "topic.subscribe",
+	EventIncomingUnsubscribe:          "topic.unsubscribe",
+	EventOutgoingTopicMessage:         "topic.message",
+	EventIncomingHello:                "hello",
+	EventOutgoingHello:                "hello.server",
+	EventOutgoingError:                "error",
+	EventOutgoingSessionClosed:        "session.closed",
+	EventOutgoingServerRestarting:     "server.restarting",
+}
+
+var eventCodes = func() map[string]EventType {
+	codes := make(map[string]EventType, len(eventNames))
+	for eventType, name := range eventNames {
+		codes[name] = eventType
+	}
+	return codes
+}()
+
+func (eventType EventType) String() string {
+	if name, ok := eventNames[eventType]; ok {
+		return name
+	}
+	return fmt.Sprintf("event(%d)", uint(eventType))
+}
+
+// UnmarshalJSON accepts the numeric code or the string code of an event
+func (eventType *EventType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		code, ok := eventCodes[name]
+		if !ok {
+			return fmt.Errorf("unknown event %v", name)
+		}
+		*eventType = code
+		return nil
+	}
+
+	var code uint
+	if err := json.Unmarshal(data, &code); err != nil {
+		return fmt.Errorf("event type must be a number or a string : %w", err)
+	}
+	*eventType = EventType(code)
+	return nil
+}
+
 type UserOnlineStatus uint
 
 const (
-	Offline UserOnlineStatus = 0
+	Offline UserOnlineStatus = iota
 	Online
 )
@@ -0,0 +1,133 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// protocol versions the server speaks, clients which never send hello are treated as MIN_PROTOCOL_VERSION
+const (
+	PROTOCOL_VERSION     = 1
+	MIN_PROTOCOL_VERSION = 1
+)
+
+// capabilities the server offers, the hello answer carries the ones both sides support
+var serverCapabilities = []string{
+	"receipt_batch",
+	"token_refresh",
+	"rpc",
+	"topics",
+	"string_event_codes",
+}
+
+// Hello is the payload of EventIncomingHello
+type Hello struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Capabilities    []string `json:"capabilities"`
+	// name and version of the client app, only logged
+	Client string `json:"client"`
+}
+
+// ServerHello is the payload of EventOutgoingHello
+type ServerHello struct {
+	ProtocolVersion    int      `json:"protocol_version"`
+	MinProtocolVersion int      `json:"min_protocol_version"`
+	MaxProtocolVersion int      `json:"max_protocol_version"`
+	Capabilities       []string `json:"capabilities"`
+	Subprotocol        string   `json:"subprotocol"`
+	// id of this socket, used to tell sessions apart in the sessions endpoint
+	SessionId string `json:"session_id"`
+}
+
+// clientProtocol is what the client announced in its hello
+type clientProtocol struct {
+	helloReceived bool
+	version       int
+	capabilities  []string
+	sync.RWMutex
+}
+
+// ProtocolVersion is the version the client announced, MIN_PROTOCOL_VERSION until it sends hello
+func (client *Client) ProtocolVersion() int {
+	client.protocol.RLock()
+	defer client.protocol.RUnlock()
+
+	if !client.protocol.helloReceived {
+		return MIN_PROTOCOL_VERSION
+	}
+	return client.protocol.version
+}
+
+// HasCapability is true when both the client and the server support the capability
+func (client *Client) HasCapability(capability string) bool {
+	client.protocol.RLock()
+	defer client.protocol.RUnlock()
+
+	return slices.Contains(client.protocol.capabilities, capability)
+}
+
+// handleHello answers the hello of a client, sockets with an unsupported version are closed
+func (manager *ConnectionManager) handleHello(hello Hello, event Event, client *Client) error {
+	answer := ServerHello{
+		MinProtocolVersion: MIN_PROTOCOL_VERSION,
+		MaxProtocolVersion: PROTOCOL_VERSION,
+		Subprotocol:        client.codec.Subprotocol(),
+		SessionId:          client.SessionId,
+	}
+
+	if hello.ProtocolVersion < MIN_PROTOCOL_VERSION || hello.ProtocolVersion > PROTOCOL_VERSION {
+		log.Printf("unsupported protocol version %v from user %v client %v", hello.ProtocolVersion, client.UserId, hello.Client)
+		// the hello with the supported range is queued, the writer sends it before the error and the close frame
+		client.sendServerHello(answer, event.Id)
+		client.failAndClose(ErrorEvent{
+			Code:    ErrorUnsupportedProtocolVersion,
+			Message: fmt.Sprintf("protocol version %v is not supported, use %v to %v", hello.ProtocolVersion, MIN_PROTOCOL_VERSION, PROTOCOL_VERSION),
+		}, websocket.CloseProtocolError)
+		return errors.New("unsupported protocol version")
+	}
+
+	var capabilities []string
+	for _, capability := range hello.Capabilities {
+		if slices.Contains(serverCapabilities, capability) && !slices.Contains(capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+
+	client.protocol.Lock()
+	if client.protocol.helloReceived {
+		client.protocol.Unlock()
+		client.sendFailedAck(event, "hello already received")
+		return errors.New("hello already received")
+	}
+	client.protocol.helloReceived = true
+	client.protocol.version = hello.ProtocolVersion
+	client.protocol.capabilities = capabilities
+	client.protocol.Unlock()
+
+	answer.ProtocolVersion = hello.ProtocolVersion
+	answer.Capabilities = capabilities
+	client.sendServerHello(answer, event.Id)
+	return nil
+}
+
+func (client *Client) sendServerHello(answer ServerHello, id string) {
+	payload, err := json.Marshal(answer)
+
+	if err != nil {
+		log.Printf("Error marshalling server hello payload %v", err)
+		return
+	}
+
+	client.push(Event{
+		Type:    EventOutgoingHello,
+		Payload: payload,
+		Id:      id,
+		Retry:   0,
+	})
+}
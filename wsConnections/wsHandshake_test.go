@@ -0,0 +1,48 @@
+package websockets
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHelloWithUnsupportedVersionIsAnsweredBeforeClose(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+	}{
+		{name: "too old", version: MIN_PROTOCOL_VERSION - 1},
+		{name: "too new", version: PROTOCOL_VERSION + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t)
+			conn, peer := newTestConnPair(t)
+			client := newClient(conn, WSTicket{UserId: "user", DeviceId: "device"}, manager)
+			manager.addClient(client.UserId, client)
+			go client.WriteMessage()
+
+			if err := manager.handleHello(Hello{ProtocolVersion: tt.version}, Event{Type: EventIncomingHello, Id: "hello-1"}, client); err == nil {
+				t.Fatalf("expected version %v to be rejected", tt.version)
+			}
+
+			hello := readPeerEvent(t, peer)
+			if hello.Type != EventOutgoingHello || hello.Id != "hello-1" {
+				t.Fatalf("expected the server hello first, got %v with id %q", hello.Type, hello.Id)
+			}
+
+			errorEvent := readPeerEvent(t, peer)
+			var payload ErrorEvent
+			if err := json.Unmarshal(errorEvent.Payload, &payload); err != nil {
+				t.Fatalf("unmarshalling error event: %v", err)
+			}
+			if errorEvent.Type != EventOutgoingError || payload.Code != ErrorUnsupportedProtocolVersion {
+				t.Fatalf("expected %v error, got %v %+v", ErrorUnsupportedProtocolVersion, errorEvent.Type, payload)
+			}
+
+			expectPeerClose(t, peer, websocket.CloseProtocolError)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestManager returns a manager which is shut down when the test ends
+func newTestManager(t *testing.T) *ConnectionManager {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewConnectionManager(ctx)
+}
+
+// newTestConn returns the client end of a socket served by an httptest server which discards what it reads
+func newTestConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dialing test server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTestConnPair returns both ends of a socket, the server end lets tests read what a client writes
+func newTestConnPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	serverConns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		serverConns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dialing test server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	peer := <-serverConns
+	t.Cleanup(func() { peer.Close() })
+	return conn, peer
+}
+
+// readPeerEvent reads the next JSON event written to the peer end of a socket
+func readPeerEvent(t *testing.T, peer *websocket.Conn) Event {
+	t.Helper()
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := peer.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading event: %v", err)
+	}
+
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("unmarshalling event %s: %v", data, err)
+	}
+	return event
+}
+
+// expectPeerClose fails unless the next frame written to the peer end is a close frame with the code
+func expectPeerClose(t *testing.T, peer *websocket.Conn, code int) {
+	t.Helper()
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := peer.ReadMessage()
+	if err == nil {
+		t.Fatalf("expected close %v, got frame %s", code, data)
+	}
+	if !websocket.IsCloseError(err, code) {
+		t.Fatalf("expected close %v, got %v", code, err)
+	}
+}
+
+// newTestClient creates a client of the user without starting its reader and writer, queued events are
+// read with nextEvent
+func newTestClient(t *testing.T, manager *ConnectionManager, userId string) *Client {
+	t.Helper()
+	return newClient(newTestConn(t), WSTicket{
+		UserId:   userId,
+		DeviceId: "device-" + userId,
+	}, manager)
+}
+
+// nextEvent waits for the next event queued for the client
+func nextEvent(t *testing.T, client *Client) Event {
+	t.Helper()
+	select {
+	case <-client.Egress.ready:
+	case <-time.After(time.Second):
+		t.Fatalf("no event queued for user %v", client.UserId)
+	}
+
+	events := client.Egress.take()
+	if len(events) != 1 {
+		t.Fatalf("expected one queued event for user %v, got %v", client.UserId, len(events))
+	}
+	return events[0]
+}
+
+// expectNoEvent fails if an event is queued for the client within a short wait
+func expectNoEvent(t *testing.T, client *Client) {
+	t.Helper()
+	select {
+	case <-client.Egress.ready:
+		t.Fatalf("unexpected events queued for user %v : %v", client.UserId, client.Egress.take())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
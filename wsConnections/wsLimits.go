@@ -0,0 +1,176 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DEFAULT_READ_LIMIT            = 64 * 1024
+	DEFAULT_COMPRESSION_LEVEL     = 1
+	DEFAULT_COMPRESSION_THRESHOLD = 512
+	// how long the reader waits for the error event and close frame to be written
+	CLOSE_WRITE_TIMEOUT = time.Second
+)
+
+// error codes sent in ErrorEvent
+const (
+	ErrorFrameTooLarge              = "frame_too_large"
+	ErrorEventTooLarge              = "event_too_large"
+	ErrorUnsupportedProtocolVersion = "unsupported_protocol_version"
+)
+
+// ErrorEvent is the payload of EventOutgoingError, sent right before the server closes the socket
+type ErrorEvent struct {
+	Code      string    `json:"code"`
+	Message   string    `json:"message"`
+	EventType EventType `json:"event_type,omitempty"`
+	Limit     int64     `json:"limit,omitempty"`
+}
+
+// DefaultEventSizeLimits caps frames of single events below the read limit, events without an entry
+// are only limited by Settings.ReadLimit
+func DefaultEventSizeLimits() map[EventType]int64 {
+	return map[EventType]int64{
+		EventIncomingChatMessage:     16 * 1024,
+		EventIncomingDeliveredUpdate: 1024,
+		EventIncomingReadUpdate:      1024,
+		EventIncomingSyncRequest:     1024,
+		EventIncomingHello:           2 * 1024,
+		EventIncomingSubscribe:       1024,
+		EventIncomingUnsubscribe:     1024,
+		EventIncomingRefreshToken:    8 * 1024,
+	}
+}
+
+// ParseEventType returns the event type of a string code, see eventNames
+func ParseEventType(name string) (EventType, bool) {
+	eventType, ok := eventCodes[name]
+	return eventType, ok
+}
+
+type closeRequest struct {
+	event  Event
+	code   int
+	reason string
+	// writes give up after the deadline
+	deadline time.Time
+	written  chan struct{}
+}
+
+// readFrame reads the next frame, frames larger than the read limit are not read any further
+func (client *Client) readFrame() (int, []byte, bool, error) {
+	messageType, reader, err := client.Conn.NextReader()
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	limit := client.ConnectionManager.Settings.ReadLimit
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return 0, nil, false, err
+	}
+	return messageType, data, int64(len(data)) > limit, nil
+}
+
+// exceedsEventLimit is true when the frame is larger than the limit of its event type
+func (client *Client) exceedsEventLimit(event Event, frameSize int) (int64, bool) {
+	limit, ok := client.ConnectionManager.Settings.EventSizeLimits[event.Type]
+	return limit, ok && int64(frameSize) > limit
+}
+
+// failAndClose sends the error event and closes the socket
+func (client *Client) failAndClose(errorEvent ErrorEvent, code int) {
+	payload, err := json.Marshal(errorEvent)
+	if err != nil {
+		log.Printf("Error marshalling error event payload %v", err)
+	}
+
+	client.sendAndClose(Event{
+		Type:    EventOutgoingError,
+		Payload: payload,
+		Id:      "",
+		Retry:   0,
+	}, code, errorEvent.Code)
+}
+
+// sendAndClose has the writer send the event followed by a close frame, it returns once both were
+// written or CLOSE_WRITE_TIMEOUT passed
+func (client *Client) sendAndClose(event Event, code int, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), CLOSE_WRITE_TIMEOUT)
+	defer cancel()
+
+	client.sendAndCloseContext(ctx, event, code, reason)
+}
+
+// sendAndCloseContext is sendAndClose with the deadline of ctx
+func (client *Client) sendAndCloseContext(ctx context.Context, event Event, code int, reason string) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(CLOSE_WRITE_TIMEOUT)
+	}
+
+	request := closeRequest{
+		event:    event,
+		code:     code,
+		reason:   reason,
+		deadline: deadline,
+		written:  make(chan struct{}),
+	}
+
+	select {
+	case client.closing <- request:
+	default:
+		// already closing
+		return
+	}
+
+	select {
+	case <-request.written:
+	case <-client.done:
+	case <-ctx.Done():
+	}
+}
+
+// writeClose writes whatever is still queued, the error event and the close frame
+func (client *Client) writeClose(request closeRequest) {
+	defer close(request.written)
+
+	// a slow client can not hold up the close past the deadline
+	if err := client.Conn.SetWriteDeadline(request.deadline); err != nil {
+		log.Printf("error setting write deadline for user %v : error - %v", client.UserId, err)
+	}
+
+	for _, message := range append(client.Egress.take(), request.event) {
+		if err := client.writeEvent(message); err != nil {
+			log.Printf("error writing before close to user %v : error - %v", client.UserId, err)
+			return
+		}
+	}
+
+	closeMessage := websocket.FormatCloseMessage(request.code, request.reason)
+	if err := client.Conn.WriteControl(websocket.CloseMessage, closeMessage, request.deadline); err != nil {
+		log.Printf("error sending close message to user %v : error - %v", client.UserId, err)
+	}
+}
+
+// writeEvent encodes and writes one event, large frames are compressed when compression was negotiated
+func (client *Client) writeEvent(event Event) error {
+	data, err := client.codec.Encode(event)
+	if err != nil {
+		log.Printf("Error encoding the message %v", err)
+		return nil
+	}
+
+	settings := client.ConnectionManager.Settings
+	if settings.EnableCompression {
+		client.Conn.EnableWriteCompression(len(data) >= settings.CompressionThreshold)
+	}
+
+	return client.Conn.WriteMessage(client.codec.FrameType(), data)
+}
@@ -0,0 +1,198 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// EventMiddleware wraps an EventHandler the way gin middleware wraps a route, it can stop the chain by
+// returning without calling next
+type EventMiddleware func(next EventHandler) EventHandler
+
+// Chain wraps the handler with the middlewares, the first middleware is the outermost one
+func Chain(handler EventHandler, middlewares ...EventMiddleware) EventHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Use adds middlewares run for every incoming event before the handler specific ones
+func (manager *ConnectionManager) Use(middlewares ...EventMiddleware) {
+	manager.middlewares = append(manager.middlewares, middlewares...)
+}
+
+// EventError is a failure the client should know about, Acknowledged sends its message in the ack
+type EventError struct {
+	Message    string
+	Disconnect bool
+	Err        error
+}
+
+func (e *EventError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v : %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// Reject fails the event with a message for the client, err (if any) is only logged
+func Reject(message string, err ...error) error {
+	return &EventError{Message: message, Err: errors.Join(err...)}
+}
+
+// RejectAndDisconnect fails the event and closes the socket afterwards
+func RejectAndDisconnect(message string, err ...error) error {
+	return &EventError{Message: message, Disconnect: true, Err: errors.Join(err...)}
+}
+
+// Acknowledged sends a successful ack when the handler returns nil and a failed one otherwise, errors
+// which are not an EventError are reported as an internal error
+func Acknowledged() EventMiddleware {
+	return func(next EventHandler) EventHandler {
+		return func(event Event, client *Client) error {
+			err := next(event, client)
+
+			var eventErr *EventError
+			switch {
+			case err == nil:
+				client.SendAckToClient(Acknowledge{
+					EventType: event.Type,
+					Status:    true,
+					Message:   "success",
+					AckTime:   time.Now().UnixNano(),
+				}, event.Id)
+			case errors.As(err, &eventErr):
+				client.sendFailedAck(event, eventErr.Message)
+			default:
+				client.sendFailedAck(event, "internal error")
+			}
+
+			if eventErr != nil && eventErr.Disconnect {
+				client.ConnectionManager.RemoveClient(client)
+			}
+			return err
+		}
+	}
+}
+
+// Validator is implemented by payloads which check their own fields
+type Validator interface {
+	Validate() error
+}
+
+// SenderOwned is implemented by payloads carrying the id of the user who sent them
+type SenderOwned interface {
+	Sender() string
+}
+
+// Decode unmarshals the payload into T before calling the handler. Payloads implementing Validator are
+// validated and payloads implementing SenderOwned must be sent by the user of the socket, otherwise the
+// client is disconnected
+func Decode[T any](handler func(payload T, event Event, client *Client) error) EventHandler {
+	return func(event Event, client *Client) error {
+		var payload T
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return Reject("error unmarshalling data", err)
+		}
+
+		if owned, ok := any(&payload).(SenderOwned); ok && owned.Sender() != client.UserId {
+			return RejectAndDisconnect("unauthorized, disconnecting", fmt.Errorf("sender %v on socket of user %v", owned.Sender(), client.UserId))
+		}
+
+		if validator, ok := any(&payload).(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				return Reject(err.Error())
+			}
+		}
+
+		return handler(payload, event, client)
+	}
+}
+
+// Recover turns a panicking handler into an error so one bad event does not take the server down
+func Recover() EventMiddleware {
+	return func(next EventHandler) EventHandler {
+		return func(event Event, client *Client) (err error) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					log.Printf("panic handling event %v of type %v from user %v : %v\n%s", event.Id, event.Type, client.UserId, recovered, debug.Stack())
+					err = fmt.Errorf("panic handling event : %v", recovered)
+				}
+			}()
+			return next(event, client)
+		}
+	}
+}
+
+// Logger logs every event with the time it took and the error, if any
+func Logger() EventMiddleware {
+	return func(next EventHandler) EventHandler {
+		return func(event Event, client *Client) error {
+			start := time.Now()
+			err := next(event, client)
+			log.Printf("ws event %v type %v user %v device %v took %v error %v", event.Id, event.Type, client.UserId, client.DeviceId, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+// RateLimit allows every socket perSecond events on average with bursts of up to burst events, the
+// rest are rejected with a failed ack
+func RateLimit(perSecond float64, burst int) EventMiddleware {
+	var buckets sync.Map
+
+	return func(next EventHandler) EventHandler {
+		return func(event Event, client *Client) error {
+			value, loaded := buckets.LoadOrStore(client, &tokenBucket{tokens: float64(burst), last: time.Now()})
+			if !loaded {
+				go func() {
+					<-client.done
+					buckets.Delete(client)
+				}()
+			}
+
+			bucket := value.(*tokenBucket)
+			bucket.Lock()
+			now := time.Now()
+			bucket.tokens = min(float64(burst), bucket.tokens+now.Sub(bucket.last).Seconds()*perSecond)
+			bucket.last = now
+			allowed := bucket.tokens >= 1
+			if allowed {
+				bucket.tokens--
+			}
+			bucket.Unlock()
+
+			if !allowed {
+				client.sendFailedAck(event, "rate limit exceeded")
+				return Reject("rate limit exceeded")
+			}
+			return next(event, client)
+		}
+	}
+}
+
+func (client *Client) sendFailedAck(event Event, message string) {
+	client.SendAckToClient(Acknowledge{
+		ReceiverID: client.UserId,
+		EventType:  event.Type,
+		Status:     false,
+		Message:    message,
+		AckTime:    time.Now().UnixNano(),
+	}, event.Id)
+}
@@ -0,0 +1,127 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type testPayload struct {
+	SenderId string `json:"sender_id"`
+	Body     string `json:"body"`
+}
+
+func (payload testPayload) Sender() string {
+	return payload.SenderId
+}
+
+func (payload testPayload) Validate() error {
+	if payload.Body == "" {
+		return errors.New("body is required")
+	}
+	return nil
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name           string
+		payload        string
+		wantCalled     bool
+		wantMessage    string
+		wantDisconnect bool
+	}{
+		{name: "valid payload", payload: `{"sender_id":"user","body":"hello"}`, wantCalled: true},
+		{name: "malformed json", payload: `{"sender_id":`, wantMessage: "error unmarshalling data"},
+		{name: "other sender", payload: `{"sender_id":"other","body":"hello"}`, wantMessage: "unauthorized, disconnecting", wantDisconnect: true},
+		{name: "invalid payload", payload: `{"sender_id":"user"}`, wantMessage: "body is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, newTestManager(t), "user")
+
+			called := false
+			handler := Decode(func(payload testPayload, event Event, client *Client) error {
+				called = true
+				if payload.Body != "hello" {
+					t.Errorf("decoded body %q, want hello", payload.Body)
+				}
+				return nil
+			})
+
+			err := handler(Event{Type: EventIncomingChatMessage, Payload: json.RawMessage(tt.payload)}, client)
+			if called != tt.wantCalled {
+				t.Fatalf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+			if tt.wantCalled {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+
+			var eventErr *EventError
+			if !errors.As(err, &eventErr) {
+				t.Fatalf("expected an EventError, got %v", err)
+			}
+			if eventErr.Message != tt.wantMessage || eventErr.Disconnect != tt.wantDisconnect {
+				t.Fatalf("error = (%q, disconnect %v), want (%q, disconnect %v)", eventErr.Message, eventErr.Disconnect, tt.wantMessage, tt.wantDisconnect)
+			}
+		})
+	}
+}
+
+func TestRouteIncomingEventRunsMiddlewares(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+	}{
+		{name: "ack", event: Event{Type: AckEvent, Id: "event-1"}},
+		{name: "refresh token", event: Event{Type: EventIncomingRefreshToken, Payload: json.RawMessage(`{"token":"token"}`)}},
+		{name: "invalid payload", event: Event{Type: EventIncomingHello, Payload: json.RawMessage(`{}`)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t)
+
+			// the middleware stops every event, nothing may run before it
+			var seen []EventType
+			manager.Use(func(next EventHandler) EventHandler {
+				return func(event Event, client *Client) error {
+					seen = append(seen, event.Type)
+					return Reject("stopped")
+				}
+			})
+
+			client := newTestClient(t, manager, "user")
+			if err := client.routeIncomingEvent(tt.event); err == nil {
+				t.Fatalf("expected the middleware to stop the event")
+			}
+
+			if len(seen) != 1 || seen[0] != tt.event.Type {
+				t.Fatalf("middleware saw %v, want %v", seen, tt.event.Type)
+			}
+			expectNoEvent(t, client)
+		})
+	}
+}
+
+func TestRouteIncomingEventValidatesPayload(t *testing.T) {
+	manager := newTestManager(t)
+	client := newTestClient(t, manager, "user")
+
+	err := client.routeIncomingEvent(Event{Type: EventIncomingSubscribe, Id: "event-1", Payload: json.RawMessage(`{"topic":1}`)})
+	if err == nil {
+		t.Fatalf("expected the payload to be rejected")
+	}
+
+	event := nextEvent(t, client)
+	var ack Acknowledge
+	if err := json.Unmarshal(event.Payload, &ack); err != nil {
+		t.Fatalf("unmarshalling ack: %v", err)
+	}
+	if event.Type != AckEvent || event.Id != "event-1" || ack.Status {
+		t.Fatalf("expected a failed ack for event-1, got %v %+v", event.Type, ack)
+	}
+}
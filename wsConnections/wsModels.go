@@ -1,5 +1,10 @@
 package websockets
 
+import (
+	"encoding/json"
+	"errors"
+)
+
 type IncomingUserStatusChange struct {
 	ID     string           `json:"id"`
 	Status UserOnlineStatus `json:"status"`
@@ -13,6 +18,37 @@ type Acknowledge struct {
 	AckTime    int64     `json:"ack_time"`
 }
 
+type FailedMessageRetry struct {
+	EventIds []string `json:"event_ids"`
+	Time     int64    `json:"time"`
+}
+
+// TopicSubscription is the payload of the subscribe and unsubscribe events
+type TopicSubscription struct {
+	Topic string `json:"topic"`
+}
+
+// TopicMessage is the payload of EventOutgoingTopicMessage, kind tells what data holds
+type TopicMessage struct {
+	Topic string          `json:"topic"`
+	Kind  string          `json:"kind"`
+	Data  json.RawMessage `json:"data"`
+	Time  int64           `json:"time"`
+}
+
+type PresenceUpdate struct {
+	UserId string           `json:"user_id"`
+	Status UserOnlineStatus `json:"status"`
+}
+
+type IncomingRefreshToken struct {
+	Token string `json:"token"`
+}
+
+type TokenRefreshRequired struct {
+	ExpiresAt int64 `json:"expires_at"`
+}
+
 type IncomingReadUpdate struct {
 	ID             string `json:"id"`
 	SenderId       string `json:"sender_id"`
@@ -20,6 +56,17 @@ type IncomingReadUpdate struct {
 	Time           int64  `json:"time"`
 }
 
+func (update *IncomingReadUpdate) Sender() string {
+	return update.SenderId
+}
+
+func (update *IncomingReadUpdate) Validate() error {
+	if update.ConversationId == "" || update.Time <= 0 {
+		return errors.New("conversation_id and time are required")
+	}
+	return nil
+}
+
 type OutgoingReadUpdate struct {
 	MessageId  string `json:"message_id"`
 	ReceiverID string `json:"receiver_id"`
@@ -32,12 +79,37 @@ type OutgoingDeliveredUpdate struct {
 	Time       int64  `json:"time"`
 }
 
+// MessageIdRange covers the receiver's own messages in the conversation from From to To (inclusive) in send order
+type MessageIdRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ReceiptBatch aggregates delivered or read updates of one conversation sent within a short window
+type ReceiptBatch struct {
+	ConversationId string           `json:"conversation_id"`
+	ReceiverID     string           `json:"receiver_id"`
+	Ranges         []MessageIdRange `json:"ranges"`
+	Time           int64            `json:"time"`
+}
+
 type IncomingDeliveredUpdate struct {
 	MessageId string `json:"message_id"`
 	SenderId  string `json:"receiver_id"`
 	Time      int64  `json:"time"`
 }
 
+func (update *IncomingDeliveredUpdate) Sender() string {
+	return update.SenderId
+}
+
+func (update *IncomingDeliveredUpdate) Validate() error {
+	if update.MessageId == "" {
+		return errors.New("message_id is required")
+	}
+	return nil
+}
+
 type IncomingSocialRequest struct {
 	RequestType  string `json:"request_type"`
 	RequestorId  string `json:"requestor_id"`
@@ -0,0 +1,168 @@
+package websockets
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	OUTBOX_RETRY_INTERVAL = 2 * time.Second
+	OUTBOX_MAX_RETRY_WAIT = 30 * time.Second
+	OUTBOX_MAX_RETRIES    = 5
+	OUTBOX_SCAN_INTERVAL  = time.Second
+)
+
+type outboxEntry struct {
+	event    Event
+	nextSend time.Time
+}
+
+// Outbox keeps every tracked event sent to a client until the client acknowledges it.
+// Unacknowledged events are resent with exponential backoff, once retries are exhausted
+// the event is handed to the manager's UndeliveredHandler so it can be picked up on sync.
+type Outbox struct {
+	client  *Client
+	pending map[string]*outboxEntry
+	sync.Mutex
+}
+
+func newOutbox(client *Client) *Outbox {
+	return &Outbox{
+		client:  client,
+		pending: make(map[string]*outboxEntry),
+	}
+}
+
+// track assigns an id to the event (if it has none) and stores it until acknowledged
+func (outbox *Outbox) track(event *Event) {
+	if event.Id == "" {
+		event.Id = uuid.NewString()
+	}
+
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	outbox.pending[event.Id] = &outboxEntry{
+		event:    *event,
+		nextSend: time.Now().Add(retryBackoff(event.Retry)),
+	}
+}
+
+// ack removes the event from the outbox, returns false if the id is unknown
+func (outbox *Outbox) ack(eventId string) bool {
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	if _, ok := outbox.pending[eventId]; !ok {
+		return false
+	}
+	delete(outbox.pending, eventId)
+	return true
+}
+
+// handleAck handles the client acknowledging an event sent from the server
+func handleAck(event Event, client *Client) error {
+	if !client.Outbox.ack(event.Id) {
+		log.Printf("ack for unknown event %v from user %v", event.Id, client.UserId)
+	}
+	return nil
+}
+
+// drain empties the outbox and returns every event still waiting for an ack
+func (outbox *Outbox) drain() []Event {
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	events := make([]Event, 0, len(outbox.pending))
+	for id, entry := range outbox.pending {
+		events = append(events, entry.event)
+		delete(outbox.pending, id)
+	}
+	return events
+}
+
+// due returns events whose retry time has passed along with events which exhausted their retries
+func (outbox *Outbox) due(now time.Time) ([]Event, []Event) {
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	var resend, failed []Event
+	for id, entry := range outbox.pending {
+		if entry.nextSend.After(now) {
+			continue
+		}
+
+		if entry.event.Retry >= OUTBOX_MAX_RETRIES {
+			failed = append(failed, entry.event)
+			delete(outbox.pending, id)
+			continue
+		}
+
+		entry.event.Retry++
+		entry.nextSend = now.Add(retryBackoff(entry.event.Retry))
+		resend = append(resend, entry.event)
+	}
+	return resend, failed
+}
+
+// run resends due events until the client is closed, whatever is left is reported as undelivered
+func (outbox *Outbox) run() {
+	tick := time.NewTicker(OUTBOX_SCAN_INTERVAL)
+	defer tick.Stop()
+
+	for {
+		select {
+		case now := <-tick.C:
+			resend, failed := outbox.due(now)
+			for _, event := range resend {
+				log.Printf("resending event %v to user %v, retry %v", event.Id, outbox.client.UserId, event.Retry)
+				outbox.client.push(event)
+			}
+			outbox.reportUndelivered(failed)
+
+		case <-outbox.client.done:
+			outbox.reportUndelivered(outbox.drain())
+			return
+		}
+	}
+}
+
+func (outbox *Outbox) reportUndelivered(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.Id
+	}
+
+	// let the client know (if it is still around) that it should sync these events
+	outbox.client.sendFailedRetry(FailedMessageRetry{
+		EventIds: ids,
+		Time:     time.Now().UnixNano(),
+	})
+
+	handler := outbox.client.ConnectionManager.UndeliveredHandler
+	if handler == nil {
+		log.Printf("%v events undelivered to user %v, no handler registered", len(events), outbox.client.UserId)
+		return
+	}
+
+	for _, event := range events {
+		if err := handler(event, outbox.client); err != nil {
+			log.Printf("error handling undelivered event %v for user %v : error - %v", event.Id, outbox.client.UserId, err)
+		}
+	}
+}
+
+func retryBackoff(retry uint) time.Duration {
+	wait := OUTBOX_RETRY_INTERVAL << retry
+	if wait <= 0 || wait > OUTBOX_MAX_RETRY_WAIT {
+		return OUTBOX_MAX_RETRY_WAIT
+	}
+	return wait
+}
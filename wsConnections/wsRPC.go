@@ -0,0 +1,144 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+const RPC_TIMEOUT = 10 * time.Second
+
+// error codes sent in RPCError
+const (
+	RPCInvalidRequest = "invalid_request"
+	RPCMethodNotFound = "method_not_found"
+	RPCForbidden      = "forbidden"
+	RPCNotFound       = "not_found"
+	RPCInternal       = "internal"
+)
+
+// RPCRequest is the payload of EventIncomingRPCRequest, the event id correlates the response
+type RPCRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// RPCResponse is the payload of EventOutgoingRPCResponse, sent with the id of the request
+type RPCResponse struct {
+	Method string `json:"method"`
+	Result any    `json:"result"`
+}
+
+// RPCError is the payload of EventOutgoingRPCError, sent with the id of the request
+type RPCError struct {
+	Method  string `json:"method"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Code + " : " + e.Message
+}
+
+// NewRPCError is returned by methods to send the client a specific code, other errors are sent as internal
+func NewRPCError(code string, message string) error {
+	return &RPCError{Code: code, Message: message}
+}
+
+// RPCHandler answers a request, the result is marshalled into the response
+type RPCHandler func(ctx context.Context, params json.RawMessage, client *Client) (any, error)
+
+// RPCMethod decodes params into P (validating them if P implements Validator) before calling the method
+func RPCMethod[P any, R any](method func(ctx context.Context, params P, client *Client) (R, error)) RPCHandler {
+	return func(ctx context.Context, rawParams json.RawMessage, client *Client) (any, error) {
+		var params P
+		if len(rawParams) > 0 {
+			if err := json.Unmarshal(rawParams, &params); err != nil {
+				return nil, NewRPCError(RPCInvalidRequest, "error unmarshalling params")
+			}
+		}
+
+		if validator, ok := any(&params).(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				return nil, NewRPCError(RPCInvalidRequest, err.Error())
+			}
+		}
+
+		return method(ctx, params, client)
+	}
+}
+
+func (manager *ConnectionManager) SetupRPCMethods(methods map[string]RPCHandler) {
+	manager.RPCMethods = methods
+}
+
+// handleRPCRequest runs the requested method and answers with a response or an error event carrying the
+// id of the request
+func (manager *ConnectionManager) handleRPCRequest(event Event, client *Client) error {
+	var request RPCRequest
+	if err := json.Unmarshal(event.Payload, &request); err != nil {
+		client.sendRPCError(event.Id, RPCError{Code: RPCInvalidRequest, Message: "error unmarshalling request"})
+		return err
+	}
+
+	if event.Id == "" {
+		client.sendRPCError(event.Id, RPCError{Method: request.Method, Code: RPCInvalidRequest, Message: "id is required"})
+		return errors.New("rpc request without id")
+	}
+
+	method, ok := manager.RPCMethods[request.Method]
+	if !ok {
+		client.sendRPCError(event.Id, RPCError{Method: request.Method, Code: RPCMethodNotFound, Message: "unknown method"})
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), RPC_TIMEOUT)
+	defer cancel()
+
+	result, err := method(ctx, request.Params, client)
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			log.Printf("error in rpc method %v for user %v : error - %v", request.Method, client.UserId, err)
+			rpcErr = &RPCError{Code: RPCInternal, Message: "internal error"}
+		}
+		client.sendRPCError(event.Id, RPCError{Method: request.Method, Code: rpcErr.Code, Message: rpcErr.Message})
+		return err
+	}
+
+	payload, err := json.Marshal(RPCResponse{
+		Method: request.Method,
+		Result: result,
+	})
+	if err != nil {
+		log.Printf("Error marshalling rpc response of method %v : error - %v", request.Method, err)
+		client.sendRPCError(event.Id, RPCError{Method: request.Method, Code: RPCInternal, Message: "internal error"})
+		return err
+	}
+
+	client.push(Event{
+		Type:    EventOutgoingRPCResponse,
+		Payload: payload,
+		Id:      event.Id,
+		Retry:   0,
+	})
+	return nil
+}
+
+func (client *Client) sendRPCError(id string, rpcError RPCError) {
+	payload, err := json.Marshal(rpcError)
+
+	if err != nil {
+		log.Printf("Error marshalling rpc error payload %v", err)
+		return
+	}
+
+	client.push(Event{
+		Type:    EventOutgoingRPCError,
+		Payload: payload,
+		Id:      id,
+		Retry:   0,
+	})
+}
@@ -0,0 +1,123 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type testParams struct {
+	ConversationId string `json:"conversation_id"`
+}
+
+func (params testParams) Validate() error {
+	if params.ConversationId == "" {
+		return errors.New("conversation_id is required")
+	}
+	return nil
+}
+
+func TestRPCMethod(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     string
+		wantCalled bool
+		wantCode   string
+	}{
+		{name: "valid params", params: `{"conversation_id":"conversation"}`, wantCalled: true},
+		{name: "malformed params", params: `{"conversation_id":`, wantCode: RPCInvalidRequest},
+		{name: "wrong type", params: `{"conversation_id":1}`, wantCode: RPCInvalidRequest},
+		{name: "missing params are validated", params: ``, wantCode: RPCInvalidRequest},
+		{name: "invalid params", params: `{}`, wantCode: RPCInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := RPCMethod(func(ctx context.Context, params testParams, client *Client) (string, error) {
+				called = true
+				return params.ConversationId, nil
+			})
+
+			result, err := handler(context.Background(), json.RawMessage(tt.params), nil)
+			if called != tt.wantCalled {
+				t.Fatalf("method called = %v, want %v", called, tt.wantCalled)
+			}
+
+			if tt.wantCode == "" {
+				if err != nil || result != "conversation" {
+					t.Fatalf("result = (%v, %v), want conversation", result, err)
+				}
+				return
+			}
+
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) || rpcErr.Code != tt.wantCode {
+				t.Fatalf("error = %v, want code %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandleRPCRequest(t *testing.T) {
+	methods := map[string]RPCHandler{
+		"echo": RPCMethod(func(ctx context.Context, params testParams, client *Client) (string, error) {
+			return params.ConversationId, nil
+		}),
+		"forbidden": func(ctx context.Context, params json.RawMessage, client *Client) (any, error) {
+			return nil, NewRPCError(RPCForbidden, "not a participant")
+		},
+		"failing": func(ctx context.Context, params json.RawMessage, client *Client) (any, error) {
+			return nil, errors.New("database down")
+		},
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		request  string
+		wantType EventType
+		wantCode string
+	}{
+		{name: "response", id: "request-1", request: `{"method":"echo","params":{"conversation_id":"conversation"}}`, wantType: EventOutgoingRPCResponse},
+		{name: "unknown method", id: "request-1", request: `{"method":"missing"}`, wantType: EventOutgoingRPCError, wantCode: RPCMethodNotFound},
+		{name: "missing id", id: "", request: `{"method":"echo"}`, wantType: EventOutgoingRPCError, wantCode: RPCInvalidRequest},
+		{name: "method error code", id: "request-1", request: `{"method":"forbidden"}`, wantType: EventOutgoingRPCError, wantCode: RPCForbidden},
+		{name: "internal error", id: "request-1", request: `{"method":"failing"}`, wantType: EventOutgoingRPCError, wantCode: RPCInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t)
+			manager.SetupRPCMethods(methods)
+			client := newTestClient(t, manager, "user")
+
+			manager.handleRPCRequest(Event{Type: EventIncomingRPCRequest, Id: tt.id, Payload: json.RawMessage(tt.request)}, client)
+
+			event := nextEvent(t, client)
+			if event.Type != tt.wantType || event.Id != tt.id {
+				t.Fatalf("got %v with id %q, want %v with id %q", event.Type, event.Id, tt.wantType, tt.id)
+			}
+
+			if tt.wantType == EventOutgoingRPCResponse {
+				var response RPCResponse
+				if err := json.Unmarshal(event.Payload, &response); err != nil {
+					t.Fatalf("unmarshalling response: %v", err)
+				}
+				if response.Method != "echo" || response.Result != "conversation" {
+					t.Fatalf("unexpected response %+v", response)
+				}
+				return
+			}
+
+			var rpcErr RPCError
+			if err := json.Unmarshal(event.Payload, &rpcErr); err != nil {
+				t.Fatalf("unmarshalling error: %v", err)
+			}
+			if rpcErr.Code != tt.wantCode {
+				t.Fatalf("error code = %v, want %v", rpcErr.Code, tt.wantCode)
+			}
+		})
+	}
+}
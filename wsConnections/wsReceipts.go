@@ -0,0 +1,171 @@
+package websockets
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	RECEIPT_COALESCE_WINDOW = 250 * time.Millisecond
+)
+
+type ReceiptKind uint
+
+const (
+	ReceiptDelivered ReceiptKind = iota
+	ReceiptRead
+)
+
+type receiptKey struct {
+	kind           ReceiptKind
+	receiverId     string
+	conversationId string
+}
+
+type pendingReceipt struct {
+	messageId string
+	sentAt    int64
+}
+
+// ReceiptCoalescer collects delivered/read receipts per receiver and conversation over a short window
+// and sends them as a single batch event instead of one event per message
+type ReceiptCoalescer struct {
+	manager *ConnectionManager
+	window  time.Duration
+	pending map[receiptKey][]pendingReceipt
+	sync.Mutex
+}
+
+func newReceiptCoalescer(manager *ConnectionManager, window time.Duration) *ReceiptCoalescer {
+	return &ReceiptCoalescer{
+		manager: manager,
+		window:  window,
+		pending: make(map[receiptKey][]pendingReceipt),
+	}
+}
+
+// add queues a receipt, the first receipt of a key starts the window after which the key is flushed.
+// Without a window the receipt is sent right away.
+func (coalescer *ReceiptCoalescer) add(key receiptKey, receipt pendingReceipt) {
+	coalescer.Lock()
+	defer coalescer.Unlock()
+
+	if coalescer.window <= 0 {
+		coalescer.pending[key] = append(coalescer.pending[key], receipt)
+		go coalescer.flush(key)
+		return
+	}
+
+	if _, ok := coalescer.pending[key]; !ok {
+		time.AfterFunc(coalescer.window, func() {
+			coalescer.flush(key)
+		})
+	}
+	coalescer.pending[key] = append(coalescer.pending[key], receipt)
+}
+
+// flushAll sends every pending batch right away, used on shutdown
+func (coalescer *ReceiptCoalescer) flushAll() {
+	coalescer.Lock()
+	keys := make([]receiptKey, 0, len(coalescer.pending))
+	for key := range coalescer.pending {
+		keys = append(keys, key)
+	}
+	coalescer.Unlock()
+
+	for _, key := range keys {
+		coalescer.flush(key)
+	}
+}
+
+func (coalescer *ReceiptCoalescer) flush(key receiptKey) {
+	coalescer.Lock()
+	receipts := coalescer.pending[key]
+	delete(coalescer.pending, key)
+	coalescer.Unlock()
+
+	if len(receipts) == 0 {
+		return
+	}
+
+	batch := ReceiptBatch{
+		ConversationId: key.conversationId,
+		ReceiverID:     key.receiverId,
+		Ranges:         coalescer.ranges(key, receipts),
+		Time:           time.Now().UnixNano(),
+	}
+
+	switch key.kind {
+	case ReceiptDelivered:
+		coalescer.manager.deliverToUser(key.receiverId, EventOutgoingDeliveredUpdateBatch, batch)
+	case ReceiptRead:
+		coalescer.manager.deliverToUser(key.receiverId, EventOutgoingReadUpdateBatch, batch)
+	}
+}
+
+// ranges merges receipts into runs of consecutive messages of the receiver in the conversation, the
+// manager's ReceiptRangeResolver tells which messages lie in between. Without it every message is its own range.
+func (coalescer *ReceiptCoalescer) ranges(key receiptKey, receipts []pendingReceipt) []MessageIdRange {
+	sort.SliceStable(receipts, func(i, j int) bool {
+		if receipts[i].sentAt == receipts[j].sentAt {
+			return receipts[i].messageId < receipts[j].messageId
+		}
+		return receipts[i].sentAt < receipts[j].sentAt
+	})
+
+	inBatch := make(map[string]bool, len(receipts))
+	var ordered []string
+	for _, receipt := range receipts {
+		if !inBatch[receipt.messageId] {
+			inBatch[receipt.messageId] = true
+			ordered = append(ordered, receipt.messageId)
+		}
+	}
+
+	resolver := coalescer.manager.ReceiptRangeResolver
+	if resolver == nil || len(ordered) == 1 {
+		return singleRanges(ordered)
+	}
+
+	between, err := resolver(key.conversationId, key.receiverId, receipts[0].sentAt, receipts[len(receipts)-1].sentAt)
+	if err != nil {
+		log.Printf("error resolving receipt ranges for conversation %v : error - %v", key.conversationId, err)
+		return singleRanges(ordered)
+	}
+
+	var ranges []MessageIdRange
+	covered := make(map[string]bool, len(ordered))
+	open := false
+	for _, id := range between {
+		if !inBatch[id] {
+			open = false
+			continue
+		}
+
+		covered[id] = true
+		if open {
+			ranges[len(ranges)-1].To = id
+			continue
+		}
+		ranges = append(ranges, MessageIdRange{From: id, To: id})
+		open = true
+	}
+
+	// receipts for messages the resolver did not return are still sent on their own
+	for _, id := range ordered {
+		if !covered[id] {
+			ranges = append(ranges, MessageIdRange{From: id, To: id})
+		}
+	}
+	return ranges
+}
+
+func singleRanges(ids []string) []MessageIdRange {
+	ranges := make([]MessageIdRange, len(ids))
+	for i, id := range ids {
+		ranges[i] = MessageIdRange{From: id, To: id}
+	}
+	return ranges
+}
@@ -0,0 +1,129 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReceiptRanges(t *testing.T) {
+	// m1..m5 are the messages of the conversation in sent order
+	conversation := []string{"m1", "m2", "m3", "m4", "m5"}
+
+	tests := []struct {
+		name     string
+		resolver func(conversationId string, senderId string, fromSentAt int64, toSentAt int64) ([]string, error)
+		receipts []pendingReceipt
+		want     []MessageIdRange
+	}{
+		{
+			name:     "without resolver every message is its own range",
+			receipts: []pendingReceipt{{"m2", 2}, {"m1", 1}},
+			want:     []MessageIdRange{{"m1", "m1"}, {"m2", "m2"}},
+		},
+		{
+			name: "consecutive messages are merged",
+			resolver: func(string, string, int64, int64) ([]string, error) {
+				return conversation[0:3], nil
+			},
+			receipts: []pendingReceipt{{"m3", 3}, {"m1", 1}, {"m2", 2}},
+			want:     []MessageIdRange{{"m1", "m3"}},
+		},
+		{
+			name: "a message missing from the batch splits the range",
+			resolver: func(string, string, int64, int64) ([]string, error) {
+				return conversation, nil
+			},
+			receipts: []pendingReceipt{{"m1", 1}, {"m2", 2}, {"m4", 4}, {"m5", 5}},
+			want:     []MessageIdRange{{"m1", "m2"}, {"m4", "m5"}},
+		},
+		{
+			name: "duplicate receipts are sent once",
+			resolver: func(string, string, int64, int64) ([]string, error) {
+				return conversation[0:2], nil
+			},
+			receipts: []pendingReceipt{{"m1", 1}, {"m2", 2}, {"m2", 2}},
+			want:     []MessageIdRange{{"m1", "m2"}},
+		},
+		{
+			name: "messages unknown to the resolver are sent on their own",
+			resolver: func(string, string, int64, int64) ([]string, error) {
+				return conversation[0:2], nil
+			},
+			receipts: []pendingReceipt{{"m1", 1}, {"m2", 2}, {"other", 3}},
+			want:     []MessageIdRange{{"m1", "m2"}, {"other", "other"}},
+		},
+		{
+			name: "resolver error falls back to single ranges",
+			resolver: func(string, string, int64, int64) ([]string, error) {
+				return nil, errors.New("resolver failed")
+			},
+			receipts: []pendingReceipt{{"m1", 1}, {"m2", 2}},
+			want:     []MessageIdRange{{"m1", "m1"}, {"m2", "m2"}},
+		},
+		{
+			name: "equal sent times are ordered by id",
+			resolver: func(string, string, int64, int64) ([]string, error) {
+				return []string{"a", "b"}, nil
+			},
+			receipts: []pendingReceipt{{"b", 1}, {"a", 1}},
+			want:     []MessageIdRange{{"a", "b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t)
+			manager.SetupReceiptRangeResolver(tt.resolver)
+
+			got := manager.Receipts.ranges(receiptKey{kind: ReceiptRead, receiverId: "sender", conversationId: "conversation"}, tt.receipts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ranges = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceiptsCoalesceWithinWindow(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      ReceiptKind
+		eventType EventType
+	}{
+		{name: "delivered", kind: ReceiptDelivered, eventType: EventOutgoingDeliveredUpdateBatch},
+		{name: "read", kind: ReceiptRead, eventType: EventOutgoingReadUpdateBatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager(t)
+			manager.Receipts = newReceiptCoalescer(manager, 50*time.Millisecond)
+
+			client := newTestClient(t, manager, "sender")
+			manager.addClient(client.UserId, client)
+
+			key := receiptKey{kind: tt.kind, receiverId: "sender", conversationId: "conversation"}
+			manager.Receipts.add(key, pendingReceipt{messageId: "m1", sentAt: 1})
+			manager.Receipts.add(key, pendingReceipt{messageId: "m2", sentAt: 2})
+
+			event := nextEvent(t, client)
+			if event.Type != tt.eventType {
+				t.Fatalf("expected %v, got %v", tt.eventType, event.Type)
+			}
+
+			var batch ReceiptBatch
+			if err := json.Unmarshal(event.Payload, &batch); err != nil {
+				t.Fatalf("unmarshalling batch: %v", err)
+			}
+			want := []MessageIdRange{{"m1", "m1"}, {"m2", "m2"}}
+			if batch.ConversationId != "conversation" || !reflect.DeepEqual(batch.Ranges, want) {
+				t.Fatalf("unexpected batch %+v", batch)
+			}
+
+			// both receipts went out in the one batch
+			expectNoEvent(t, client)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package websockets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// JSON types a payload field can have
+type FieldType string
+
+const (
+	FieldString  FieldType = "string"
+	FieldNumber  FieldType = "number"
+	FieldInteger FieldType = "integer"
+	FieldBoolean FieldType = "boolean"
+	FieldArray   FieldType = "array"
+	FieldObject  FieldType = "object"
+)
+
+// PayloadSchema is a small subset of JSON schema for event payloads : the payload is an object, required
+// fields are present and not null and known fields have the declared type
+type PayloadSchema struct {
+	Required   []string
+	Properties map[string]FieldType
+	// fields missing from Properties are rejected when set
+	DisallowAdditional bool
+}
+
+func (schema PayloadSchema) Validate(payload json.RawMessage) error {
+	if len(bytes.TrimSpace(payload)) == 0 {
+		payload = json.RawMessage("{}")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil || fields == nil {
+		return errors.New("payload must be an object")
+	}
+
+	for _, name := range schema.Required {
+		if value, ok := fields[name]; !ok || string(value) == "null" {
+			return fmt.Errorf("%v is required", name)
+		}
+	}
+
+	for name, value := range fields {
+		expected, known := schema.Properties[name]
+		if !known {
+			if schema.DisallowAdditional {
+				return fmt.Errorf("unknown field %v", name)
+			}
+			continue
+		}
+
+		if string(value) == "null" {
+			continue
+		}
+		if actual := jsonType(value); actual != expected && !(expected == FieldNumber && actual == FieldInteger) {
+			return fmt.Errorf("%v must be %v", name, expected)
+		}
+	}
+	return nil
+}
+
+// jsonType returns the type of a raw JSON value, numbers without fraction or exponent are integers
+func jsonType(value json.RawMessage) FieldType {
+	switch value[0] {
+	case '"':
+		return FieldString
+	case '{':
+		return FieldObject
+	case '[':
+		return FieldArray
+	case 't', 'f':
+		return FieldBoolean
+	}
+	if strings.ContainsAny(string(value), ".eE") {
+		return FieldNumber
+	}
+	return FieldInteger
+}
+
+// SetupPayloadSchemas adds schemas for handler events, payloads of these events are validated before the
+// handler runs
+func (manager *ConnectionManager) SetupPayloadSchemas(schemas map[EventType]PayloadSchema) {
+	for eventType, schema := range schemas {
+		manager.PayloadSchemas[eventType] = schema
+	}
+}
+
+// validatePayload rejects events whose payload does not match the schema of their type before the handler runs
+func (manager *ConnectionManager) validatePayload(next EventHandler) EventHandler {
+	return func(event Event, client *Client) error {
+		if schema, ok := manager.PayloadSchemas[event.Type]; ok {
+			if err := schema.Validate(event.Payload); err != nil {
+				client.sendFailedAck(event, "invalid payload : "+err.Error())
+				return err
+			}
+		}
+		return next(event, client)
+	}
+}
+
+// schemas of events handled by the manager itself
+func builtinPayloadSchemas() map[EventType]PayloadSchema {
+	return map[EventType]PayloadSchema{
+		EventIncomingHello: {
+			Required: []string{"protocol_version"},
+			Properties: map[string]FieldType{
+				"protocol_version": FieldInteger,
+				"capabilities":     FieldArray,
+				"client":           FieldString,
+			},
+		},
+		EventIncomingRefreshToken: {
+			Required:   []string{"token"},
+			Properties: map[string]FieldType{"token": FieldString},
+		},
+		EventIncomingRPCRequest: {
+			Required: []string{"method"},
+			Properties: map[string]FieldType{
+				"method": FieldString,
+				"params": FieldObject,
+			},
+		},
+		EventIncomingSubscribe: {
+			Required:   []string{"topic"},
+			Properties: map[string]FieldType{"topic": FieldString},
+		},
+		EventIncomingUnsubscribe: {
+			Required:   []string{"topic"},
+			Properties: map[string]FieldType{"topic": FieldString},
+		},
+	}
+}
@@ -0,0 +1,192 @@
+package websockets
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DEFAULT_SEND_QUEUE_SIZE         = 256
+	DEFAULT_SLOW_CONSUMER_MAX_DROPS = 64
+)
+
+// SlowConsumerPolicy decides what happens when the send queue of a client is full
+type SlowConsumerPolicy string
+
+const (
+	// drop the oldest queued event which is not critical, the client is disconnected once it dropped
+	// more than SlowConsumerMaxDrops events in a row or only critical events are queued
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop_oldest"
+	// disconnect as soon as the queue is full
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// critical events are never dropped, a full queue of them disconnects the client. Only receipts may be
+// dropped since sync rebuilds them, everything else (chat messages included) is critical
+func isCriticalEvent(eventType EventType) bool {
+	switch eventType {
+	case EventOutgoingReadUpdate, EventOutgoingDeliveredUpdate,
+		EventOutgoingReadUpdateBatch, EventOutgoingDeliveredUpdateBatch:
+		return false
+	}
+	return true
+}
+
+// SendQueue is the bounded queue of events waiting to be written to a socket, events are written in
+// the order they were queued by the client's writer
+type SendQueue struct {
+	client *Client
+	events []Event
+	size   int
+	// signalled when events are queued, buffered so producers never block
+	ready chan struct{}
+	// drops since the last successful write
+	consecutiveDrops int
+	dropped          atomic.Uint64
+	sync.Mutex
+}
+
+func newSendQueue(client *Client, size int) *SendQueue {
+	if size <= 0 {
+		size = DEFAULT_SEND_QUEUE_SIZE
+	}
+	return &SendQueue{
+		client: client,
+		size:   size,
+		events: make([]Event, 0, size),
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// Depth is the number of events waiting to be written
+func (queue *SendQueue) Depth() int {
+	queue.Lock()
+	defer queue.Unlock()
+	return len(queue.events)
+}
+
+// Dropped is the number of events dropped since the client connected
+func (queue *SendQueue) Dropped() uint64 {
+	return queue.dropped.Load()
+}
+
+// push queues the event without blocking, returns false if the event was not queued
+func (queue *SendQueue) push(event Event) bool {
+	queue.Lock()
+	if len(queue.events) < queue.size {
+		queue.events = append(queue.events, event)
+		queue.Unlock()
+		queue.signal()
+		return true
+	}
+
+	settings := queue.client.ConnectionManager.Settings
+	disconnect := settings.SlowConsumerPolicy == SlowConsumerDisconnect
+	queued := false
+
+	if !disconnect {
+		// when only critical events are queued a non critical event is dropped itself
+		if dropAt := queue.oldestDroppable(); dropAt >= 0 {
+			queue.events = append(append(queue.events[:dropAt], queue.events[dropAt+1:]...), event)
+			queued = true
+		} else if isCriticalEvent(event.Type) {
+			disconnect = true
+		}
+
+		queue.consecutiveDrops++
+		if settings.SlowConsumerMaxDrops > 0 && queue.consecutiveDrops > settings.SlowConsumerMaxDrops {
+			disconnect = true
+		}
+	}
+	queue.Unlock()
+
+	queue.dropped.Add(1)
+	queue.client.ConnectionManager.metrics.dropped.Add(1)
+
+	if disconnect {
+		queue.client.disconnectSlowConsumer()
+		return false
+	}
+
+	if queued {
+		queue.signal()
+	}
+	return queued
+}
+
+// oldestDroppable returns the index of the oldest non critical event or -1, must hold the lock
+func (queue *SendQueue) oldestDroppable() int {
+	for i, event := range queue.events {
+		if !isCriticalEvent(event.Type) {
+			return i
+		}
+	}
+	return -1
+}
+
+// take removes every queued event in order
+func (queue *SendQueue) take() []Event {
+	queue.Lock()
+	defer queue.Unlock()
+
+	events := queue.events
+	queue.events = make([]Event, 0, queue.size)
+	queue.consecutiveDrops = 0
+	return events
+}
+
+func (queue *SendQueue) signal() {
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+}
+
+// disconnectSlowConsumer closes a socket which does not keep up with its events, it can sync once it reconnects
+func (client *Client) disconnectSlowConsumer() {
+	client.slowOnce.Do(func() {
+		log.Printf("disconnecting slow consumer, user %v device %v", client.UserId, client.DeviceId)
+		client.ConnectionManager.metrics.slowDisconnects.Add(1)
+		go client.closeWithReason(websocket.CloseTryAgainLater, "slow consumer")
+	})
+}
+
+type queueMetrics struct {
+	dropped         atomic.Uint64
+	slowDisconnects atomic.Uint64
+}
+
+// SendQueueStats describes the send queues of every socket held by this instance
+type SendQueueStats struct {
+	Clients         int    `json:"clients"`
+	TotalDepth      int    `json:"total_depth"`
+	MaxDepth        int    `json:"max_depth"`
+	QueueSize       int    `json:"queue_size"`
+	Dropped         uint64 `json:"dropped"`
+	SlowDisconnects uint64 `json:"slow_disconnects"`
+}
+
+func (manager *ConnectionManager) SendQueueStats() SendQueueStats {
+	manager.RLock()
+	var clients []*Client
+	for _, userClients := range manager.ConnectionMap {
+		clients = append(clients, userClients...)
+	}
+	manager.RUnlock()
+
+	stats := SendQueueStats{
+		Clients:         len(clients),
+		QueueSize:       manager.Settings.SendQueueSize,
+		Dropped:         manager.metrics.dropped.Load(),
+		SlowDisconnects: manager.metrics.slowDisconnects.Load(),
+	}
+	for _, client := range clients {
+		depth := client.Egress.Depth()
+		stats.TotalDepth += depth
+		stats.MaxDepth = max(stats.MaxDepth, depth)
+	}
+	return stats
+}
@@ -0,0 +1,267 @@
+package websockets
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"g_chat/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+const DEFAULT_MAX_CONNECTIONS_PER_USER = 5
+
+// what happens when a user opens more sockets than Settings.MaxConnectionsPerUser
+type ConnectionLimitStrategy string
+
+const (
+	// the new socket is refused with 429
+	ConnectionLimitRejectNew ConnectionLimitStrategy = "reject_new"
+	// the oldest sockets of the user are closed with a session closed event
+	ConnectionLimitEvictOldest ConnectionLimitStrategy = "evict_oldest"
+)
+
+// reasons sent in SessionClosed
+const (
+	SessionEvicted        = "evicted"
+	SessionClosedByUser   = "closed_by_user"
+	SessionLimitReached   = "limit_reached"
+	SessionCloseCodeLimit = 4001
+	SessionCloseCodeUser  = 4002
+)
+
+// SessionClosed is the payload of EventOutgoingSessionClosed, sent right before the socket is closed
+type SessionClosed struct {
+	SessionId string `json:"session_id"`
+	Reason    string `json:"reason"`
+	Message   string `json:"message"`
+}
+
+// Session describes a live socket of a user
+type Session struct {
+	SessionId   string `json:"session_id"`
+	DeviceId    string `json:"device_id"`
+	ConnectedAt int64  `json:"connected_at"`
+	ClientIP    string `json:"client_ip"`
+	UserAgent   string `json:"user_agent"`
+	Subprotocol string `json:"subprotocol"`
+	NodeId      string `json:"node_id,omitempty"`
+	Current     bool   `json:"current"`
+}
+
+func (client *Client) session() Session {
+	return Session{
+		SessionId:   client.SessionId,
+		DeviceId:    client.DeviceId,
+		ConnectedAt: client.ConnectedAt,
+		ClientIP:    client.ClientIP,
+		UserAgent:   client.UserAgent,
+		Subprotocol: client.codec.Subprotocol(),
+		NodeId:      client.ConnectionManager.NodeId,
+	}
+}
+
+// connectionLimitReached is true when the user can not open another socket under the reject strategy, it
+// only spares the upgrade of most sockets over the limit, admitClient makes the decision
+func (manager *ConnectionManager) connectionLimitReached(userId string) bool {
+	limit := manager.Settings.MaxConnectionsPerUser
+	if limit <= 0 || manager.Settings.ConnectionLimitStrategy != ConnectionLimitRejectNew {
+		return false
+	}
+
+	manager.RLock()
+	defer manager.RUnlock()
+	return len(manager.ConnectionMap[userId]) >= limit
+}
+
+// admitClient registers the client unless the user is at the connection limit. The check and the
+// registration happen under the manager lock so concurrent connects can not both take the last slot.
+// Under the evict strategy the oldest sockets over the limit are removed in the same step and returned,
+// the caller closes them with closeEvicted.
+func (manager *ConnectionManager) admitClient(newClient *Client) (bool, []*Client) {
+	limit := manager.Settings.MaxConnectionsPerUser
+	strategy := manager.Settings.ConnectionLimitStrategy
+
+	manager.Lock()
+	clients := manager.ConnectionMap[newClient.UserId]
+	firstClient := len(clients) == 0
+
+	if limit > 0 && len(clients) >= limit && strategy == ConnectionLimitRejectNew {
+		manager.Unlock()
+		return false, nil
+	}
+
+	var evicted []*Client
+	if limit > 0 && len(clients) >= limit && strategy == ConnectionLimitEvictOldest {
+		oldest := append([]*Client(nil), clients...)
+		sort.SliceStable(oldest, func(i, j int) bool {
+			return oldest[i].ConnectedAt < oldest[j].ConnectedAt
+		})
+		evicted = oldest[:len(clients)-limit+1]
+		clients = slices.DeleteFunc(append([]*Client(nil), clients...), func(client *Client) bool {
+			return slices.Contains(evicted, client)
+		})
+	}
+
+	manager.ConnectionMap[newClient.UserId] = append(clients, newClient)
+	manager.Unlock()
+
+	if firstClient {
+		manager.registerPresence(newClient.UserId)
+		manager.publishPresence(newClient.UserId, Online)
+	}
+	return true, evicted
+}
+
+// closeEvicted closes the sockets admitClient evicted and returns once each got its session closed event
+// and close frame, or CLOSE_WRITE_TIMEOUT passed
+func (manager *ConnectionManager) closeEvicted(evicted []*Client) {
+	var wg sync.WaitGroup
+	for _, client := range evicted {
+		log.Printf("evicting session %v of user %v, connection limit %v reached", client.SessionId, client.UserId, manager.Settings.MaxConnectionsPerUser)
+
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			client.closeSession(SessionClosed{
+				SessionId: client.SessionId,
+				Reason:    SessionEvicted,
+				Message:   "closed because a newer session was opened",
+			}, SessionCloseCodeLimit)
+		}(client)
+	}
+	wg.Wait()
+}
+
+// closeOverLimit closes a socket which lost the race for the last slot of the user. It was never
+// registered and its writer never started, so the event and the close frame are written here
+func (client *Client) closeOverLimit() {
+	client.writeClose(closeRequest{
+		event: sessionClosedEvent(SessionClosed{
+			SessionId: client.SessionId,
+			Reason:    SessionLimitReached,
+			Message:   "connection limit reached, close another session first",
+		}),
+		code:     SessionCloseCodeLimit,
+		reason:   SessionLimitReached,
+		deadline: time.Now().Add(CLOSE_WRITE_TIMEOUT),
+		written:  make(chan struct{}),
+	})
+	client.close()
+	client.Conn.Close()
+}
+
+func sessionClosedEvent(sessionClosed SessionClosed) Event {
+	payload, err := json.Marshal(sessionClosed)
+	if err != nil {
+		log.Printf("Error marshalling session closed payload %v", err)
+	}
+
+	return Event{
+		Type:    EventOutgoingSessionClosed,
+		Payload: payload,
+		Id:      "",
+		Retry:   0,
+	}
+}
+
+// closeSession tells the client why its socket is closed before closing it
+func (client *Client) closeSession(sessionClosed SessionClosed, code int) {
+	client.sendAndClose(sessionClosedEvent(sessionClosed), code, sessionClosed.Reason)
+	client.ConnectionManager.RemoveClient(client)
+}
+
+// closeLocalSession closes the socket with the session id if it belongs to the user and is held here
+func (manager *ConnectionManager) closeLocalSession(userId string, sessionId string, reason string) bool {
+	manager.RLock()
+	var target *Client
+	for _, client := range manager.ConnectionMap[userId] {
+		if client.SessionId == sessionId {
+			target = client
+			break
+		}
+	}
+	manager.RUnlock()
+
+	if target == nil {
+		return false
+	}
+
+	target.closeSession(SessionClosed{
+		SessionId: sessionId,
+		Reason:    reason,
+		Message:   "session closed from another session",
+	}, SessionCloseCodeUser)
+	return true
+}
+
+// ListSessions returns the live sockets of the caller held by this instance
+func (manager *ConnectionManager) ListSessions(ctx *gin.Context) {
+	userId := middleware.UserId(ctx)
+	currentSession := ctx.Query("currentSessionId")
+
+	manager.RLock()
+	sessions := make([]Session, 0, len(manager.ConnectionMap[userId]))
+	for _, client := range manager.ConnectionMap[userId] {
+		session := client.session()
+		session.Current = session.SessionId == currentSession
+		sessions = append(sessions, session)
+	}
+	manager.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt < sessions[j].ConnectedAt
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+	})
+}
+
+// CloseSession closes one socket of the caller, on whichever instance holds it
+func (manager *ConnectionManager) CloseSession(ctx *gin.Context) {
+	userId := middleware.UserId(ctx)
+	sessionId := ctx.Param("sessionId")
+
+	if manager.closeLocalSession(userId, sessionId, SessionClosedByUser) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "success",
+		})
+		return
+	}
+
+	if !manager.IsClusterEnabled() {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "session not found",
+		})
+		return
+	}
+
+	// the session may live on another instance, those close it if they hold it
+	manager.forwardToNodes(ClusterMessage{
+		Kind:      ClusterCloseSession,
+		UserId:    userId,
+		SessionId: sessionId,
+		Reason:    SessionClosedByUser,
+	})
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"message": "close requested",
+	})
+}
+
+// rejectOverLimit answers the upgrade request with 429 when the user has too many sockets
+func (manager *ConnectionManager) rejectOverLimit(ctx *gin.Context, userId string) bool {
+	if !manager.connectionLimitReached(userId) {
+		return false
+	}
+
+	ctx.JSON(http.StatusTooManyRequests, gin.H{
+		"message": "connection limit reached, close another session first",
+	})
+	return true
+}
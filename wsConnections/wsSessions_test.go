@@ -0,0 +1,165 @@
+package websockets
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newLimitedManager returns a manager allowing limit sockets per user with the strategy
+func newLimitedManager(t *testing.T, limit int, strategy ConnectionLimitStrategy) *ConnectionManager {
+	t.Helper()
+	manager := newTestManager(t)
+	settings := manager.Settings
+	settings.MaxConnectionsPerUser = limit
+	settings.ConnectionLimitStrategy = strategy
+	manager.Configure(settings)
+	return manager
+}
+
+func TestAdmitClientConcurrently(t *testing.T) {
+	tests := []struct {
+		name         string
+		limit        int
+		strategy     ConnectionLimitStrategy
+		wantAdmitted int
+	}{
+		{name: "reject new", limit: 2, strategy: ConnectionLimitRejectNew, wantAdmitted: 2},
+		{name: "evict oldest", limit: 2, strategy: ConnectionLimitEvictOldest, wantAdmitted: 10},
+		{name: "unlimited", limit: 0, strategy: ConnectionLimitRejectNew, wantAdmitted: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newLimitedManager(t, tt.limit, tt.strategy)
+
+			clients := make([]*Client, 10)
+			for i := range clients {
+				clients[i] = newTestClient(t, manager, "user")
+			}
+
+			var admitted atomic.Int32
+			var evictedLock sync.Mutex
+			var evicted []*Client
+			var wg sync.WaitGroup
+			for _, client := range clients {
+				wg.Add(1)
+				go func(client *Client) {
+					defer wg.Done()
+					ok, out := manager.admitClient(client)
+					if ok {
+						admitted.Add(1)
+					}
+					evictedLock.Lock()
+					evicted = append(evicted, out...)
+					evictedLock.Unlock()
+				}(client)
+			}
+			wg.Wait()
+
+			if int(admitted.Load()) != tt.wantAdmitted {
+				t.Fatalf("admitted %v sockets, want %v", admitted.Load(), tt.wantAdmitted)
+			}
+
+			wantRegistered := tt.wantAdmitted
+			if tt.limit > 0 {
+				wantRegistered = min(tt.wantAdmitted, tt.limit)
+			}
+			manager.RLock()
+			registered := len(manager.ConnectionMap["user"])
+			manager.RUnlock()
+			if registered != wantRegistered {
+				t.Fatalf("%v sockets registered, want %v", registered, wantRegistered)
+			}
+			if len(evicted) != tt.wantAdmitted-wantRegistered {
+				t.Fatalf("%v sockets evicted, want %v", len(evicted), tt.wantAdmitted-wantRegistered)
+			}
+		})
+	}
+}
+
+func TestEvictedSessionsAreClosedBeforeAdmitReturns(t *testing.T) {
+	manager := newLimitedManager(t, 1, ConnectionLimitEvictOldest)
+
+	conn, peer := newTestConnPair(t)
+	oldest := newClient(conn, WSTicket{UserId: "user", DeviceId: "old-device"}, manager)
+	oldest.ConnectedAt = time.Now().Add(-time.Minute).UnixNano()
+	manager.admitClient(oldest)
+	go oldest.WriteMessage()
+
+	newest := newTestClient(t, manager, "user")
+	ok, evicted := manager.admitClient(newest)
+	if !ok || len(evicted) != 1 || evicted[0] != oldest {
+		t.Fatalf("expected the oldest session to be evicted, got admitted %v evicted %v", ok, evicted)
+	}
+	manager.closeEvicted(evicted)
+
+	select {
+	case <-oldest.done:
+	default:
+		t.Fatal("evicted session still open after closeEvicted returned")
+	}
+
+	event := readPeerEvent(t, peer)
+	var closed SessionClosed
+	if err := json.Unmarshal(event.Payload, &closed); err != nil {
+		t.Fatalf("unmarshalling session closed: %v", err)
+	}
+	if event.Type != EventOutgoingSessionClosed || closed.Reason != SessionEvicted {
+		t.Fatalf("expected an evicted session closed event, got %v %+v", event.Type, closed)
+	}
+	expectPeerClose(t, peer, SessionCloseCodeLimit)
+}
+
+func TestCloseOverLimit(t *testing.T) {
+	manager := newLimitedManager(t, 1, ConnectionLimitRejectNew)
+	manager.admitClient(newTestClient(t, manager, "user"))
+
+	conn, peer := newTestConnPair(t)
+	client := newClient(conn, WSTicket{UserId: "user", DeviceId: "device"}, manager)
+	if ok, _ := manager.admitClient(client); ok {
+		t.Fatal("expected the socket over the limit to be refused")
+	}
+	client.closeOverLimit()
+
+	event := readPeerEvent(t, peer)
+	var closed SessionClosed
+	if err := json.Unmarshal(event.Payload, &closed); err != nil {
+		t.Fatalf("unmarshalling session closed: %v", err)
+	}
+	if event.Type != EventOutgoingSessionClosed || closed.Reason != SessionLimitReached {
+		t.Fatalf("expected a limit reached session closed event, got %v %+v", event.Type, closed)
+	}
+	expectPeerClose(t, peer, SessionCloseCodeLimit)
+}
+
+func TestConnectionLimitIsPerInstance(t *testing.T) {
+	managers := newTestCluster(t, "node-a", "node-b")
+	for _, manager := range managers {
+		settings := manager.Settings
+		settings.MaxConnectionsPerUser = 1
+		settings.ConnectionLimitStrategy = ConnectionLimitRejectNew
+		manager.Configure(settings)
+	}
+
+	steps := []struct {
+		name         string
+		node         int
+		wantAdmitted bool
+	}{
+		{name: "first socket on node-a", node: 0, wantAdmitted: true},
+		// sockets on other instances are not counted, the user is over the limit cluster-wide
+		{name: "first socket on node-b", node: 1, wantAdmitted: true},
+		{name: "second socket on node-a", node: 0, wantAdmitted: false},
+		{name: "second socket on node-b", node: 1, wantAdmitted: false},
+	}
+
+	for _, step := range steps {
+		ok, _ := managers[step.node].admitClient(newTestClient(t, managers[step.node], "user"))
+		if ok != step.wantAdmitted {
+			t.Fatalf("%v: admitted = %v, want %v", step.name, ok, step.wantAdmitted)
+		}
+	}
+}
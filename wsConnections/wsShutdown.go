@@ -0,0 +1,120 @@
+package websockets
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// ServerRestarting is the payload of EventOutgoingServerRestarting, clients should wait ReconnectAfter
+// milliseconds before opening a new socket
+type ServerRestarting struct {
+	ReconnectAfter int64  `json:"reconnect_after"`
+	Message        string `json:"message"`
+}
+
+// Context is cancelled once the manager shut down, background work of the manager stops with it
+func (manager *ConnectionManager) Context() context.Context {
+	return manager.ctx
+}
+
+func (manager *ConnectionManager) IsShuttingDown() bool {
+	return manager.shuttingDown.Load()
+}
+
+// rejectWhileShuttingDown answers with 503 once shutdown started so clients retry on another instance
+func (manager *ConnectionManager) rejectWhileShuttingDown(ctx *gin.Context) bool {
+	if !manager.IsShuttingDown() {
+		return false
+	}
+
+	ctx.JSON(http.StatusServiceUnavailable, gin.H{
+		"message": "server restarting",
+	})
+	return true
+}
+
+// spawn runs a client goroutine, Shutdown waits for all of them
+func (manager *ConnectionManager) spawn(routine func()) {
+	manager.routines.Add(1)
+	go func() {
+		defer manager.routines.Done()
+		routine()
+	}()
+}
+
+// Shutdown stops accepting sockets, flushes pending receipts, sends every client a restart event after
+// whatever is still queued for it and closes the sockets. Clients are told to reconnect after
+// reconnectAfter plus a random jitter of up to reconnectAfter so they do not all come back at once.
+// Background work (ticket janitor, cluster subscriptions) stops once the sockets are closed or ctx is done.
+func (manager *ConnectionManager) Shutdown(ctx context.Context, reconnectAfter time.Duration) error {
+	if manager.shuttingDown.Swap(true) {
+		return nil
+	}
+	defer manager.cancel()
+
+	manager.Receipts.flushAll()
+
+	manager.RLock()
+	var clients []*Client
+	for _, userClients := range manager.ConnectionMap {
+		clients = append(clients, userClients...)
+	}
+	manager.RUnlock()
+
+	log.Printf("shutting down websockets, closing %v sockets", len(clients))
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			client.closeForRestart(ctx, reconnectAfter)
+		}(client)
+	}
+	wg.Wait()
+
+	// reader, writer, outbox and dispatcher of every client, the outbox reports undelivered events on exit
+	done := make(chan struct{})
+	go func() {
+		manager.routines.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (client *Client) closeForRestart(ctx context.Context, reconnectAfter time.Duration) {
+	wait := reconnectAfter
+	if reconnectAfter > 0 {
+		wait += rand.N(reconnectAfter)
+	}
+
+	payload, err := json.Marshal(ServerRestarting{
+		ReconnectAfter: wait.Milliseconds(),
+		Message:        "server restarting",
+	})
+	if err != nil {
+		log.Printf("Error marshalling server restarting payload %v", err)
+	}
+
+	client.sendAndCloseContext(ctx, Event{
+		Type:    EventOutgoingServerRestarting,
+		Payload: payload,
+		Id:      "",
+		Retry:   0,
+	}, websocket.CloseServiceRestart, "server restarting")
+	client.ConnectionManager.RemoveClient(client)
+}
@@ -2,72 +2,194 @@ package websockets
 
 import (
 	"context"
-	"fmt"
+	"g_chat/middleware"
+	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-// TODO : also store accessToken and store it in client struct, validate on every request
+const (
+	DEFAULT_TICKET_TTL      = 30 * time.Second
+	TICKET_JANITOR_INTERVAL = 5 * time.Second
+)
+
+// WSTicket also carries the lifetime of the access token it was requested with, the socket has to
+// refresh the token before it expires (see watchToken)
 type WSTicket struct {
-	ticket    string
-	userId    string
-	createdAt time.Time
+	Ticket         string
+	UserId         string
+	DeviceId       string
+	ClientIP       string
+	UserAgent      string
+	TokenIssuedAt  time.Time
+	TokenExpiresAt time.Time
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+// TicketStore keeps issued tickets until they are consumed or expire. Consume must remove the
+// ticket atomically so a ticket can only ever be used once, even across instances.
+type TicketStore interface {
+	Save(ctx context.Context, ticket WSTicket) error
+	// Consume removes the ticket and returns it, false if it does not exist or expired
+	Consume(ctx context.Context, ticket string) (WSTicket, bool, error)
+	DeleteExpired(ctx context.Context, now time.Time) error
+}
+
+// MemoryTicketStore is a TicketStore for a single instance
+type MemoryTicketStore struct {
+	tickets map[string]WSTicket
+	sync.Mutex
+}
+
+func NewMemoryTicketStore() *MemoryTicketStore {
+	return &MemoryTicketStore{
+		tickets: make(map[string]WSTicket),
+	}
+}
+
+func (store *MemoryTicketStore) Save(ctx context.Context, ticket WSTicket) error {
+	store.Lock()
+	defer store.Unlock()
+
+	store.tickets[ticket.Ticket] = ticket
+	return nil
+}
+
+func (store *MemoryTicketStore) Consume(ctx context.Context, ticket string) (WSTicket, bool, error) {
+	store.Lock()
+	defer store.Unlock()
+
+	wsTicket, ok := store.tickets[ticket]
+	if !ok {
+		return WSTicket{}, false, nil
+	}
+	delete(store.tickets, ticket)
+
+	if !wsTicket.ExpiresAt.After(time.Now()) {
+		return WSTicket{}, false, nil
+	}
+	return wsTicket, true, nil
 }
 
-type Tickets map[string]*WSTicket
+func (store *MemoryTicketStore) DeleteExpired(ctx context.Context, now time.Time) error {
+	store.Lock()
+	defer store.Unlock()
 
-// CreateNewTicketsMap will create a new ticketmap and start the retention given the set period
-func CreateNewTicketsMap(ctx context.Context, retentionPeriod time.Duration) *Tickets {
-	ticketManager := make(Tickets)
-	go ticketManager.discardOldTickets(ctx, retentionPeriod)
+	for id, ticket := range store.tickets {
+		if !ticket.ExpiresAt.After(now) {
+			delete(store.tickets, id)
+		}
+	}
+	return nil
+}
 
-	return &ticketManager
+// Tickets issues and validates the one time tickets used to open a websocket
+type Tickets struct {
+	store TicketStore
+	ttl   time.Duration
+	sync.RWMutex
 }
 
-func (t *Tickets) generateTicket(ctx *gin.Context) {
-	wsTicket := &WSTicket{
-		ticket:    uuid.NewString(), //userId+IP+timestamp(micros) OR uuid
-		userId:    ctx.Keys["userId"].(string),
-		createdAt: time.Now(),
+// CreateNewTicketsMap creates tickets backed by an in memory store and starts discarding tickets older than ttl
+func CreateNewTicketsMap(ctx context.Context, ttl time.Duration) *Tickets {
+	tickets := &Tickets{
+		store: NewMemoryTicketStore(),
+		ttl:   ttl,
 	}
+	go tickets.discardOldTickets(ctx)
 
-	(*t)[wsTicket.ticket] = wsTicket
+	return tickets
+}
 
-	fmt.Printf("ticket = %v", wsTicket)
+// Configure swaps the store (tickets in the old store are dropped) and the ttl of new tickets
+func (t *Tickets) Configure(store TicketStore, ttl time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+
+	if store != nil {
+		t.store = store
+	}
+	if ttl > 0 {
+		t.ttl = ttl
+	}
+}
+
+func (t *Tickets) config() (TicketStore, time.Duration) {
+	t.RLock()
+	defer t.RUnlock()
+	return t.store, t.ttl
+}
+
+func (t *Tickets) generateTicket(ctx *gin.Context, deviceId string) {
+	store, ttl := t.config()
+
+	principal, _ := middleware.GetPrincipal(ctx)
+
+	now := time.Now()
+	wsTicket := WSTicket{
+		Ticket:         uuid.NewString(), //userId+IP+timestamp(micros) OR uuid
+		UserId:         principal.UserId,
+		DeviceId:       deviceId,
+		ClientIP:       ctx.ClientIP(),
+		UserAgent:      ctx.Request.UserAgent(),
+		TokenIssuedAt:  principal.IssuedAt,
+		TokenExpiresAt: principal.ExpiresAt,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(ttl),
+	}
+
+	if err := store.Save(ctx.Request.Context(), wsTicket); err != nil {
+		log.Printf("error saving ticket for user %v : error - %v", wsTicket.UserId, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "error creating ticket",
+		})
+		return
+	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"ticket": wsTicket.ticket,
+		"ticket":     wsTicket.Ticket,
+		"expires_at": wsTicket.ExpiresAt.UnixNano(),
 	})
 }
 
-func (t *Tickets) validateTicket(ctx *gin.Context) (string, bool) {
-	ticket := ctx.Query("ticket")
+// validateTicket consumes the ticket and returns it, the ticket must be used from the same IP and user
+// agent that requested it
+func (t *Tickets) validateTicket(ctx *gin.Context) (WSTicket, bool) {
+	store, _ := t.config()
 
-	if _, ok := (*t)[ticket]; !ok {
-		return "", false
+	wsTicket, ok, err := store.Consume(ctx.Request.Context(), ctx.Query("ticket"))
+	if err != nil {
+		log.Printf("error consuming ticket : error - %v", err)
+		return WSTicket{}, false
 	}
 
-	userId := (*t)[ticket].userId
+	if !ok {
+		return WSTicket{}, false
+	}
 
-	delete(*t, ticket)
+	if wsTicket.ClientIP != ctx.ClientIP() || wsTicket.UserAgent != ctx.Request.UserAgent() {
+		log.Printf("ticket of user %v used from a different client", wsTicket.UserId)
+		return WSTicket{}, false
+	}
 
-	return userId, true
+	return wsTicket, true
 }
 
-func (t *Tickets) discardOldTickets(ctx context.Context, retentionPeriod time.Duration) {
-	ticker := time.NewTicker(time.Second * 5)
+func (t *Tickets) discardOldTickets(ctx context.Context) {
+	ticker := time.NewTicker(TICKET_JANITOR_INTERVAL)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			for _, ticket := range *t {
-				// Add Retention to Created and check if it is expired
-				if ticket.createdAt.Add(retentionPeriod).Before(time.Now()) {
-					delete(*t, ticket.ticket)
-				}
+		case now := <-ticker.C:
+			store, _ := t.config()
+			if err := store.DeleteExpired(ctx, now); err != nil {
+				log.Printf("error discarding expired tickets : error - %v", err)
 			}
 		case <-ctx.Done():
 			return
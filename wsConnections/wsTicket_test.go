@@ -0,0 +1,154 @@
+package websockets
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryTicketStoreConsume(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		saved     []WSTicket
+		consume   string
+		wantFound bool
+		wantUser  string
+	}{
+		{
+			name:      "valid ticket",
+			saved:     []WSTicket{{Ticket: "ticket", UserId: "user", ExpiresAt: now.Add(time.Minute)}},
+			consume:   "ticket",
+			wantFound: true,
+			wantUser:  "user",
+		},
+		{
+			name:    "unknown ticket",
+			saved:   []WSTicket{{Ticket: "ticket", UserId: "user", ExpiresAt: now.Add(time.Minute)}},
+			consume: "other",
+		},
+		{
+			name:    "expired ticket",
+			saved:   []WSTicket{{Ticket: "ticket", UserId: "user", ExpiresAt: now.Add(-time.Second)}},
+			consume: "ticket",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := NewMemoryTicketStore()
+			for _, ticket := range tt.saved {
+				if err := store.Save(ctx, ticket); err != nil {
+					t.Fatalf("saving ticket: %v", err)
+				}
+			}
+
+			ticket, ok, err := store.Consume(ctx, tt.consume)
+			if err != nil {
+				t.Fatalf("consuming ticket: %v", err)
+			}
+			if ok != tt.wantFound || ticket.UserId != tt.wantUser {
+				t.Fatalf("consume = (%v, %v), want (%v, %v)", ticket.UserId, ok, tt.wantUser, tt.wantFound)
+			}
+
+			// a ticket is gone after the first consume whether or not it was valid
+			if _, ok, _ := store.Consume(ctx, tt.consume); ok {
+				t.Fatalf("ticket %v consumed twice", tt.consume)
+			}
+		})
+	}
+}
+
+func TestMemoryTicketStoreConsumeOnceConcurrently(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTicketStore()
+	store.Save(ctx, WSTicket{Ticket: "ticket", ExpiresAt: time.Now().Add(time.Minute)})
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	consumed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, _ := store.Consume(ctx, "ticket"); ok {
+				lock.Lock()
+				consumed++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if consumed != 1 {
+		t.Fatalf("ticket consumed %v times, want once", consumed)
+	}
+}
+
+func TestMemoryTicketStoreDeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryTicketStore()
+	store.Save(ctx, WSTicket{Ticket: "expired", ExpiresAt: now.Add(-time.Second)})
+	store.Save(ctx, WSTicket{Ticket: "expires-now", ExpiresAt: now})
+	store.Save(ctx, WSTicket{Ticket: "valid", ExpiresAt: now.Add(time.Minute)})
+
+	if err := store.DeleteExpired(ctx, now); err != nil {
+		t.Fatalf("deleting expired tickets: %v", err)
+	}
+
+	tests := []struct {
+		ticket   string
+		wantKept bool
+	}{
+		{ticket: "expired", wantKept: false},
+		{ticket: "expires-now", wantKept: false},
+		{ticket: "valid", wantKept: true},
+	}
+	for _, tt := range tests {
+		if _, kept := store.tickets[tt.ticket]; kept != tt.wantKept {
+			t.Errorf("ticket %v kept = %v, want %v", tt.ticket, kept, tt.wantKept)
+		}
+	}
+}
+
+func TestValidateTicketChecksClient(t *testing.T) {
+	tests := []struct {
+		name      string
+		clientIP  string
+		userAgent string
+		want      bool
+	}{
+		{name: "same client", clientIP: "10.0.0.1", userAgent: "agent", want: true},
+		{name: "other ip", clientIP: "10.0.0.2", userAgent: "agent", want: false},
+		{name: "other user agent", clientIP: "10.0.0.1", userAgent: "other", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tickets := &Tickets{store: NewMemoryTicketStore(), ttl: time.Minute}
+			tickets.store.Save(context.Background(), WSTicket{
+				Ticket:    "ticket",
+				UserId:    "user",
+				ClientIP:  "10.0.0.1",
+				UserAgent: "agent",
+				ExpiresAt: time.Now().Add(time.Minute),
+			})
+
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest("GET", "/ws/connect?ticket=ticket", nil)
+			ctx.Request.RemoteAddr = tt.clientIP + ":1234"
+			ctx.Request.Header.Set("User-Agent", tt.userAgent)
+
+			if _, ok := tickets.validateTicket(ctx); ok != tt.want {
+				t.Fatalf("validate = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MAX_TOPICS_PER_CLIENT = 100
+
+// topics are "<kind>:<id>", the authorizer decides per kind who may subscribe
+const (
+	TopicConversation = "conversation"
+	TopicEvent        = "event"
+	TopicLFG          = "lfg"
+	TopicPresence     = "presence"
+)
+
+func Topic(kind string, id string) string {
+	return kind + ":" + id
+}
+
+// SplitTopic returns the kind and id of a topic
+func SplitTopic(topic string) (string, string, error) {
+	kind, id, found := strings.Cut(topic, ":")
+	if !found || kind == "" || id == "" {
+		return "", "", fmt.Errorf("invalid topic %v", topic)
+	}
+	return kind, id, nil
+}
+
+// TopicAuthorizer returns true if the user may subscribe to the topic
+type TopicAuthorizer func(userId string, topic string) (bool, error)
+
+// TopicRegistry keeps the sockets subscribed to every topic
+type TopicRegistry struct {
+	subscribers map[string]map[*Client]bool
+	byClient    map[*Client]map[string]bool
+	sync.RWMutex
+}
+
+func newTopicRegistry() *TopicRegistry {
+	return &TopicRegistry{
+		subscribers: make(map[string]map[*Client]bool),
+		byClient:    make(map[*Client]map[string]bool),
+	}
+}
+
+func (registry *TopicRegistry) subscribe(client *Client, topic string) error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	// a subscription handled while the socket is removed must not outlive removeClient
+	select {
+	case <-client.done:
+		return errors.New("connection closed")
+	default:
+	}
+
+	if registry.byClient[client][topic] {
+		return nil
+	}
+	if len(registry.byClient[client]) >= MAX_TOPICS_PER_CLIENT {
+		return errors.New("too many subscriptions")
+	}
+
+	if _, ok := registry.subscribers[topic]; !ok {
+		registry.subscribers[topic] = make(map[*Client]bool)
+	}
+	if _, ok := registry.byClient[client]; !ok {
+		registry.byClient[client] = make(map[string]bool)
+	}
+	registry.subscribers[topic][client] = true
+	registry.byClient[client][topic] = true
+	return nil
+}
+
+func (registry *TopicRegistry) unsubscribe(client *Client, topic string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.remove(client, topic)
+}
+
+// removeClient drops every subscription of a socket and marks it closed under the lock, so subscribe
+// refuses it from then on
+func (registry *TopicRegistry) removeClient(client *Client) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	client.close()
+
+	for topic := range registry.byClient[client] {
+		registry.remove(client, topic)
+	}
+}
+
+// must hold the lock
+func (registry *TopicRegistry) remove(client *Client, topic string) {
+	delete(registry.subscribers[topic], client)
+	if len(registry.subscribers[topic]) == 0 {
+		delete(registry.subscribers, topic)
+	}
+	delete(registry.byClient[client], topic)
+	if len(registry.byClient[client]) == 0 {
+		delete(registry.byClient, client)
+	}
+}
+
+func (registry *TopicRegistry) clients(topic string) []*Client {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	clients := make([]*Client, 0, len(registry.subscribers[topic]))
+	for client := range registry.subscribers[topic] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+func (manager *ConnectionManager) SetupTopicAuthorizer(authorizer TopicAuthorizer) {
+	manager.TopicAuthorizer = authorizer
+}
+
+// Publish sends data to every socket subscribed to the topic on every instance. topic messages are not
+// tracked by the outbox, clients catch up on anything missed through sync or rpc
+func (manager *ConnectionManager) Publish(topic string, kind string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshalling payload for topic %v : error - %v", topic, err)
+		return
+	}
+
+	message, err := json.Marshal(TopicMessage{
+		Topic: topic,
+		Kind:  kind,
+		Data:  payload,
+		Time:  time.Now().UnixNano(),
+	})
+	if err != nil {
+		log.Printf("Error marshalling topic message for topic %v : error - %v", topic, err)
+		return
+	}
+
+	manager.publishLocal(topic, message)
+	manager.broadcastToNodes(ClusterMessage{
+		Kind:  ClusterPublishTopic,
+		Topic: topic,
+		Event: Event{
+			Type:    EventOutgoingTopicMessage,
+			Payload: message,
+		},
+	})
+}
+
+func (manager *ConnectionManager) publishLocal(topic string, message json.RawMessage) {
+	for _, client := range manager.Topics.clients(topic) {
+		client.push(Event{
+			Type:    EventOutgoingTopicMessage,
+			Payload: message,
+			Id:      "",
+			Retry:   0,
+		})
+	}
+}
+
+func (manager *ConnectionManager) handleSubscribe(subscription TopicSubscription, event Event, client *Client) error {
+	if _, _, err := SplitTopic(subscription.Topic); err != nil {
+		return Reject(err.Error())
+	}
+
+	if manager.TopicAuthorizer == nil {
+		return Reject("subscriptions not supported")
+	}
+
+	allowed, err := manager.TopicAuthorizer(client.UserId, subscription.Topic)
+	if err != nil {
+		return Reject("cannot confirm authorization", err)
+	}
+	if !allowed {
+		return Reject("not allowed to subscribe to topic")
+	}
+
+	if err := manager.Topics.subscribe(client, subscription.Topic); err != nil {
+		return Reject(err.Error())
+	}
+	return nil
+}
+
+func (manager *ConnectionManager) handleUnsubscribe(subscription TopicSubscription, event Event, client *Client) error {
+	manager.Topics.unsubscribe(client, subscription.Topic)
+	return nil
+}
+
+// publishPresence lets subscribers of the user's presence topic know the user came online or went offline.
+// The user stays online while any instance holds one of their sockets, so nothing is published when
+// another instance still (or already) has one
+func (manager *ConnectionManager) publishPresence(userId string, status UserOnlineStatus) {
+	if manager.connectedOnOtherNodes(userId) {
+		return
+	}
+
+	manager.Publish(Topic(TopicPresence, userId), "status", PresenceUpdate{
+		UserId: userId,
+		Status: status,
+	})
+}
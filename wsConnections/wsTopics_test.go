@@ -0,0 +1,110 @@
+package websockets
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+// lfgAuthorizer lets users subscribe to the LFG feeds of the visible games only
+func lfgAuthorizer(visibleGames ...string) TopicAuthorizer {
+	return func(userId string, topic string) (bool, error) {
+		kind, id, err := SplitTopic(topic)
+		if err != nil || kind != TopicLFG {
+			return false, nil
+		}
+		for _, game := range visibleGames {
+			if id == game {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+func TestLFGSubscribeAndPublish(t *testing.T) {
+	tests := []struct {
+		name string
+		// node the subscriber is connected to, the feed change is always published from node-a
+		subscriberNode int
+		game           string
+		wantAllowed    bool
+	}{
+		{name: "same node", subscriberNode: 0, game: "game-1", wantAllowed: true},
+		{name: "other node", subscriberNode: 1, game: "game-1", wantAllowed: true},
+		{name: "game not visible", subscriberNode: 0, game: "game-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			managers := newTestCluster(t, "node-a", "node-b")
+			for _, manager := range managers {
+				manager.SetupTopicAuthorizer(lfgAuthorizer("game-1"))
+			}
+
+			subscriber := newTestClient(t, managers[tt.subscriberNode], "player")
+			topic := Topic(TopicLFG, tt.game)
+			err := managers[tt.subscriberNode].handleSubscribe(TopicSubscription{Topic: topic}, Event{}, subscriber)
+			if (err == nil) != tt.wantAllowed {
+				t.Fatalf("subscribe error = %v, want allowed %v", err, tt.wantAllowed)
+			}
+
+			managers[0].Publish(topic, "created", map[string]string{"id": "calendar-event", "game_id": tt.game})
+
+			if !tt.wantAllowed {
+				expectNoEvent(t, subscriber)
+				return
+			}
+
+			event := nextEvent(t, subscriber)
+			var message TopicMessage
+			if err := json.Unmarshal(event.Payload, &message); err != nil {
+				t.Fatalf("unmarshalling topic message: %v", err)
+			}
+			if event.Type != EventOutgoingTopicMessage || message.Topic != topic || message.Kind != "created" {
+				t.Fatalf("got %v %+v, want a created message on %v", event.Type, message, topic)
+			}
+			expectNoEvent(t, subscriber)
+		})
+	}
+}
+
+func TestSubscribeAfterRemoveIsRefused(t *testing.T) {
+	manager := newTestManager(t)
+	client := newTestClient(t, manager, "user")
+	topic := Topic(TopicLFG, "game-1")
+
+	manager.RemoveClient(client)
+
+	if err := manager.Topics.subscribe(client, topic); err == nil {
+		t.Fatal("expected a removed client to be refused")
+	}
+	if clients := manager.Topics.clients(topic); len(clients) != 0 {
+		t.Fatalf("%v subscribers after remove, want none", len(clients))
+	}
+}
+
+func TestSubscribeRacingRemoveLeavesNoSubscription(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		manager := newTestManager(t)
+		client := newTestClient(t, manager, "user")
+
+		var wg sync.WaitGroup
+		for _, topic := range []string{Topic(TopicLFG, "game-1"), Topic(TopicLFG, "game-2"), Topic(TopicEvent, "event-1")} {
+			wg.Add(1)
+			go func(topic string) {
+				defer wg.Done()
+				manager.Topics.subscribe(client, topic)
+			}(topic)
+		}
+		manager.RemoveClient(client)
+		wg.Wait()
+
+		manager.Topics.RLock()
+		remaining := len(manager.Topics.byClient[client])
+		manager.Topics.RUnlock()
+		if remaining != 0 {
+			t.Fatalf("%v subscriptions of a removed client left", remaining)
+		}
+	}
+}